@@ -2,20 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/dashboard-platform/api-gateway/internal/audit"
 	"github.com/dashboard-platform/api-gateway/internal/config"
 	"github.com/dashboard-platform/api-gateway/internal/logger"
+	"github.com/dashboard-platform/api-gateway/internal/metrics"
 	"github.com/dashboard-platform/api-gateway/internal/middleware"
 	"github.com/dashboard-platform/api-gateway/internal/proxy"
+	"github.com/dashboard-platform/api-gateway/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 )
 
 func main() {
@@ -30,7 +40,50 @@ func main() {
 	baseLogger := logger.Init(c.Env)
 	httpLogger := logger.NewComponentLogger(baseLogger, "http")
 
-	app := fiber.New()
+	// Behind trusted proxies, the client IP is taken from the header they set.
+	fiberConfig := fiber.Config{}
+	if len(c.TrustedProxies) > 0 {
+		fiberConfig.ProxyHeader = c.ClientIPHeader
+		fiberConfig.EnableTrustedProxyCheck = true
+		fiberConfig.TrustedProxies = c.TrustedProxies
+		fiberConfig.EnableIPValidation = true
+	}
+	app := fiber.New(fiberConfig)
+
+	// Metrics registry, exposed on /metrics. Requests are recorded first, so that the
+	// recorded durations cover every middleware and the statuses every rejection.
+	registry := prometheus.NewRegistry()
+	app.Use(middleware.Metrics(metrics.NewHTTP(registry)))
+
+	// Trace every request, forwarding the span to the upstreams. Spans are only recorded
+	// when a collector is configured.
+	tracerProvider, stopTracing, err := tracing.NewProvider(c.OTLPEndpoint)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+	app.Use(middleware.Tracing(tracerProvider))
+
+	// Registered first so that every response carries the gateway clock.
+	if c.GatewayTimeFormat != "" {
+		app.Use(middleware.GatewayTime(c.GatewayTimeFormat))
+	}
+
+	// Announces incidents on every response while turned on through the admin endpoint.
+	degraded := &middleware.DegradedMode{}
+	app.Use(degraded.Handler())
+
+	// Announces deprecated routes on their responses, errors included.
+	if len(c.DeprecatedRoutes) > 0 {
+		notices := make(map[string]middleware.DeprecationNotice, len(c.DeprecatedRoutes))
+		for prefix, route := range c.DeprecatedRoutes {
+			notices[prefix] = middleware.DeprecationNotice{Deprecated: route.Deprecated, Sunset: route.Sunset, Link: route.Link}
+		}
+		app.Use(middleware.Deprecation(notices))
+	}
+
+	forwardedOptions := middleware.ForwardedOptions(servicePrefixes(c, func(s config.Service) bool { return s.ForwardOptions })...)
+	upstreamCORS := middleware.UpstreamCORS(servicePrefixes(c, func(s config.Service) bool { return s.UpstreamCORS })...)
+
 	// Middlewares
 	app.Use(
 		cors.New(cors.Config{
@@ -38,73 +91,297 @@ func main() {
 			AllowMethods:     "GET, POST, PUT, DELETE",
 			AllowOrigins:     c.FrontendURL,
 			AllowCredentials: true,
+			ExposeHeaders:    middleware.RequestIDHeader,
+			// Preflights for upstreams that implement their own CORS are forwarded, and
+			// upstreams that set their own CORS headers get none from the gateway.
+			Next: func(ctx *fiber.Ctx) bool {
+				return forwardedOptions(ctx) || upstreamCORS(ctx)
+			},
 		}),
 
 		helmet.New(),
 
 		//csrf.New(),
 
+		// Correlate the request across the gateway and upstreams, and in the access log.
+		middleware.RequestID(),
+
 		// Add custom request logger middleware.
 		middleware.RequestLogger(httpLogger),
+
+		// Extract the configured request attributes once for the middleware below.
+		middleware.Enrich(c.RequestAttributes),
+
+		// Reject denied user agents before any auth or proxying.
+		middleware.UserAgentFilter(c.BlockedUserAgents, c.AllowedUserAgents),
 	)
 
-	// Proxy handlers
-	authProxy := proxy.New(c.AuthServiceURL)
-	templatesProxy := proxy.New(c.TemplateServiceURL)
-	pdfProxy := proxy.New(c.PDFServiceURL)
+	// Audit auth failures of every route, including those rejected by upstreams.
+	var auditSink *audit.Sink
+	if c.Audit.WebhookURL != "" {
+		auditSink = audit.NewSink(c.Audit.WebhookURL, int(c.Audit.BufferSize))
+		app.Use(middleware.AuditAuthFailures(auditSink))
+	}
 
-	// JWT object for authentication middleware
-	jwtObj := &middleware.JWTObj{
-		Secret: c.JWTSecret,
+	// Reject unexpected Host headers before any auth or proxying.
+	if len(c.AllowedHosts) > 0 {
+		app.Use(middleware.HostAllowlist(c.AllowedHosts))
 	}
 
-	globalLimiter := limiter.New(limiter.Config{
-		Max:        50,
-		Expiration: 1 * time.Minute,
-	})
+	// Cap in-flight requests per IP before any auth or proxying.
+	var ipConcurrencyLimiter *middleware.ConcurrencyLimiter
+	if c.MaxConcurrentPerIP > 0 {
+		ipConcurrencyLimiter = middleware.NewIPConcurrencyLimiter(int(c.MaxConcurrentPerIP))
+		app.Use(ipConcurrencyLimiter.Handler())
+	}
+
+	// Issue the anonymous id before any proxy so even anonymous requests carry it.
+	if c.AnonID.Enabled {
+		app.Use(middleware.AnonymousID(middleware.AnonIDConfig{
+			TTL:      c.AnonID.TTL,
+			Domain:   c.AnonID.CookieDomain,
+			SameSite: c.AnonID.CookieSameSite,
+			Secure:   c.CookieSecure,
+		}))
+	}
+
+	// Upstream and audit metrics, exposed on /metrics with the request metrics.
+	upstreamMetrics := metrics.NewUpstream(registry)
+	if auditSink != nil {
+		registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "audit_events_dropped_total",
+			Help: "Audit events that could not be delivered to the webhook.",
+		}, func() float64 { return float64(auditSink.Dropped()) }))
+	}
+
+	// Proxy handlers, whose health checks run until the server has shut down.
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	authProxy := proxy.NewBalanced(c.AuthServiceURL, proxyOptions(healthCtx, "auth", c.AuthService, c, upstreamMetrics))
+	templatesProxy := proxy.NewBalanced(c.TemplateServiceURL, proxyOptions(healthCtx, "templates", c.TemplateService, c, upstreamMetrics))
+	pdfProxy := proxy.NewBalanced(c.PDFServiceURL, proxyOptions(healthCtx, "pdf", c.PDFService, c, upstreamMetrics))
+
+	// JWKS fetches and secrets provider requests share the bound on concurrent dependency calls.
+	dependencyLimiter := middleware.NewDependencyLimiter(int(c.Dependencies.MaxConcurrent), c.Dependencies.Wait)
+
+	// The JWT_SECRET validator's secret is refreshed in the background when it can be rotated.
+	var jwtSecrets *middleware.RotatingSecret
+	stopRotation := func() {}
+	if source := secretSource(c.JWTRotation, dependencyLimiter); source != nil {
+		jwtSecrets = middleware.NewRotatingSecret(c.JWTSecret, c.JWTRotation.Grace)
+		var ctx context.Context
+		ctx, stopRotation = context.WithCancel(context.Background())
+		go jwtSecrets.Refresh(ctx, c.JWTRotation.Interval, source)
+	}
+
+	// Token validators for the authentication middleware, selected per service.
+	newValidator := func(secret []byte, publicKey *rsa.PublicKey) *middleware.JWTObj {
+		return &middleware.JWTObj{
+			Secret:      secret,
+			PublicKey:   publicKey,
+			Algs:        c.JWTAllowedAlgs,
+			RequireExp:  c.JWTRequireExp,
+			MaxLifetime: c.JWTMaxLifetime,
+			Leeway:      c.JWTLeeway,
+
+			ExpectedAudience: c.JWTAudience,
+			ExpectedIssuer:   c.JWTIssuer,
+		}
+	}
+	defaultValidator := newValidator(c.JWTSecret, c.JWTPublicKey)
+	defaultValidator.Secrets = jwtSecrets
+	validators := map[string]middleware.JWTValidator{
+		"": defaultValidator,
+	}
+	for name, secret := range c.JWTValidators {
+		validators[name] = newValidator(secret, nil)
+	}
+	if c.JWKS.URL != "" {
+		validators["jwks"] = middleware.NewJWKSValidator(middleware.JWKSConfig{
+			URL:                c.JWKS.URL,
+			TTL:                c.JWKS.TTL,
+			Timeout:            c.JWKS.Timeout,
+			MinRefreshInterval: c.JWKS.MinRefreshInterval,
+			Limiter:            dependencyLimiter,
+			RequireExp:         c.JWTRequireExp,
+			MaxLifetime:        c.JWTMaxLifetime,
+			Leeway:             c.JWTLeeway,
+			Audience:           c.JWTAudience,
+			Issuer:             c.JWTIssuer,
+		})
+	}
+
+	// Tokens can only be revoked through the admin endpoint, so without it nothing is checked.
+	var revocationList *middleware.MemoryRevocationList
+	var revocations middleware.RevocationChecker
+	if c.AdminToken != "" {
+		revocationList = middleware.NewMemoryRevocationList(c.RevocationTTL)
+		revocations = revocationList
+	}
+
+	// Replay protection shares one nonce store across services.
+	nonceStore := middleware.NewMemoryNonceStore(int(c.Nonce.StoreSize))
+	requireNonce := func(s config.Service) fiber.Handler {
+		if !s.RequireNonce {
+			return next
+		}
+		return middleware.RequireNonce(nonceStore, c.Nonce.Window)
+	}
+
+	gatewayInstance := func(s config.Service) fiber.Handler {
+		if !s.InstanceHeader {
+			return next
+		}
+		return middleware.GatewayInstance(c.InstanceID)
+	}
+
+	globalLimiter := middleware.RateLimiter(c.RateLimitAlgorithm, int(c.RateLimitMax), c.RateLimitWindow)
+
+	// Authenticated users are additionally limited per user id, when configured.
+	userLimiter := next
+	if c.UserRateLimitMax > 0 {
+		userLimiter = middleware.UserRateLimiter(int(c.UserRateLimitMax), c.RateLimitWindow)
+	}
+
+	// In-flight requests are capped per user across all services.
+	var concurrencyLimiter *middleware.ConcurrencyLimiter
+	concurrencyLimit := next
+	if c.MaxConcurrentPerUser > 0 {
+		concurrencyLimiter = middleware.NewConcurrencyLimiter(int(c.MaxConcurrentPerUser))
+		concurrencyLimit = concurrencyLimiter.Handler()
+	}
+
+	// Feature flags are only looked up when a flags service is configured.
+	featureFlags := next
+	if c.FeatureFlags.URL != "" {
+		featureFlags = middleware.FeatureFlags(c.FeatureFlags.URL, c.FeatureFlags.TTL, c.FeatureFlags.Timeout)
+	}
+
+	// Static routes are owned by the gateway and registered first, so they never require auth.
+	for path, route := range c.StaticRoutes {
+		app.Get(path, middleware.Static(route.Body, route.ContentType, route.CacheControl))
+	}
 
 	// Routes
+	app.Options("/auth/*", middleware.Options(c.AuthService.ForwardOptions, authProxy))
+	app.Options("/templates/*", middleware.Options(c.TemplateService.ForwardOptions, templatesProxy))
+	app.Options("/pdf/*", middleware.Options(c.PDFService.ForwardOptions, pdfProxy))
+
 	app.All("/auth/*",
+		logVerbosity(c.AuthService.LogVerbosity),
+		gatewayInstance(c.AuthService),
+		middleware.UpgradeAllowlist(c.AuthService.AllowedUpgrades...),
+		requireNonce(c.AuthService),
+		concurrencyLimit,
 		globalLimiter,
+		microCache(c.AuthService),
 		authProxy,
 	)
 	app.Post("/templates/:id/preview",
-		middleware.RequireAuth(jwtObj),
-		limiter.New(limiter.Config{
-			Max:        1000,
-			Expiration: 1 * time.Minute,
-		}),
+		logVerbosity(c.PreviewLogVerbosity),
+		gatewayInstance(c.TemplateService),
+		middleware.UpgradeAllowlist(c.TemplateService.AllowedUpgrades...),
+		requireNonce(c.TemplateService),
+		middleware.RequireAuthWithRevocation(validators[c.TemplateService.JWTValidator], c.AuthTokenSource, c.TemplateService.UserIDHeader, revocations),
+		forwardClaims(c.TemplateService),
+		deleteRoles(c.TemplateService),
+		concurrencyLimit,
+		featureFlags,
+		middleware.RateLimiter(c.RateLimitAlgorithm, int(c.PreviewRateLimitMax), c.RateLimitWindow),
+		userLimiter,
+		microCache(c.TemplateService),
 		templatesProxy,
 	)
 	app.All("/templates/*",
-		middleware.RequireAuth(jwtObj),
+		logVerbosity(c.TemplateService.LogVerbosity),
+		gatewayInstance(c.TemplateService),
+		middleware.UpgradeAllowlist(c.TemplateService.AllowedUpgrades...),
+		requireNonce(c.TemplateService),
+		middleware.RequireAuthWithRevocation(validators[c.TemplateService.JWTValidator], c.AuthTokenSource, c.TemplateService.UserIDHeader, revocations),
+		forwardClaims(c.TemplateService),
+		deleteRoles(c.TemplateService),
+		concurrencyLimit,
+		featureFlags,
 		globalLimiter,
+		userLimiter,
+		microCache(c.TemplateService),
 		templatesProxy,
 	)
 	app.All("/pdf/*",
-		middleware.RequireAuth(jwtObj),
+		logVerbosity(c.PDFService.LogVerbosity),
+		gatewayInstance(c.PDFService),
+		middleware.UpgradeAllowlist(c.PDFService.AllowedUpgrades...),
+		requireNonce(c.PDFService),
+		middleware.RequireAuthWithRevocation(validators[c.PDFService.JWTValidator], c.AuthTokenSource, c.PDFService.UserIDHeader, revocations),
+		forwardClaims(c.PDFService),
+		deleteRoles(c.PDFService),
+		concurrencyLimit,
+		featureFlags,
 		globalLimiter,
+		userLimiter,
+		microCache(c.PDFService),
 		pdfProxy,
 	)
 
 	app.Get("/healthcheck", func(c *fiber.Ctx) error {
-		return c.SendString("api-gateway is alive")
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		status := fiber.Map{"status": "ok"}
+		if enabled, message := degraded.Status(); enabled {
+			status = fiber.Map{"status": "degraded", "message": message}
+		}
+		return c.JSON(status)
 	})
-	app.Get("/logout", func(ctx *fiber.Ctx) error {
-		ctx.Cookie(&fiber.Cookie{
-			Name:     "access_token",
-			Value:    "",
-			Expires:  time.Now().Add(-1 * time.Hour),
-			Secure:   c.CookieSecure,
-			HTTPOnly: true,
-			SameSite: "None",
+	app.Get("/readyz", middleware.Readiness(map[string][]string{
+		"auth":      c.AuthServiceURL,
+		"templates": c.TemplateServiceURL,
+		"pdf":       c.PDFServiceURL,
+	}, c.ReadinessTimeout))
+	if c.AdminToken != "" {
+		admin := app.Group("/admin", middleware.RequireAdminToken(c.AdminToken))
+		admin.Get("/degraded", degraded.AdminHandler(auditSink))
+		admin.Put("/degraded", degraded.AdminHandler(auditSink))
+		admin.Post("/revocations", revocationList.AdminHandler(auditSink))
+		if concurrencyLimiter != nil {
+			admin.Get("/concurrency", func(c *fiber.Ctx) error {
+				return c.JSON(concurrencyLimiter.InFlight())
+			})
+		}
+		if ipConcurrencyLimiter != nil {
+			admin.Get("/concurrency/ip", func(c *fiber.Ctx) error {
+				limit := c.QueryInt("limit", 10)
+				if limit < 1 {
+					limit = 1
+				}
+				return c.JSON(ipConcurrencyLimiter.Top(limit))
+			})
+		}
+	}
+	// The metrics reveal the routes, users' traffic and upstream errors, so scrapers must send
+	// the admin token; without one they are not served.
+	app.Get("/metrics",
+		middleware.RequireAdminToken(c.AdminToken),
+		adaptor.HTTPHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})),
+	)
+	app.Get("/logout", middleware.Logout(middleware.AuthCookieConfig{
+		Domain:   c.AuthCookie.Domain,
+		Path:     c.AuthCookie.Path,
+		SameSite: c.AuthCookie.SameSite,
+		Secure:   c.CookieSecure,
+	}))
+
+	// Requests that match no route, registered last.
+	app.Use(middleware.NotFound())
+
+	// Tooling waiting for READY_FILE must not mistake a file left by a previous run for readiness.
+	if c.ReadyFile != "" {
+		removeReadyFile(c.ReadyFile)
+		app.Hooks().OnListen(func(l fiber.ListenData) error {
+			return writeReadyFile(c.ReadyFile, l.Host+":"+l.Port)
 		})
-		return ctx.SendStatus(fiber.StatusOK)
-	})
+	}
 
 	// Channel to listen for OS signals
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt) // syscall.SIGINT, syscall.SIGTERM
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
 	// Goroutine to start the server
 	go func() {
@@ -120,8 +397,181 @@ func main() {
 	log.Info().Msg("Shutting down API Gateway...")
 
 	// Attempt to gracefully shut down the server
-	if err := app.ShutdownWithContext(context.Background()); err != nil {
+	if err := shutdown(app, c.ShutdownTimeout); err != nil {
 		log.Error().Err(err).Msg("Error during server shutdown")
 	}
+	// Requests still running after a timed out shutdown may emit further events, which the
+	// closed sink drops.
+	auditCtx, cancelAudit := context.WithTimeout(context.Background(), c.Audit.FlushTimeout)
+	if err := auditSink.Close(auditCtx); err != nil {
+		log.Error().Err(err).Msg("Error delivering the buffered audit events")
+	}
+	cancelAudit()
+	stopRotation()
+	stopHealthChecks()
+	// Flushes the spans of the drained requests.
+	if err := stopTracing(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Error flushing traces")
+	}
+	if c.ReadyFile != "" {
+		removeReadyFile(c.ReadyFile)
+	}
 	log.Info().Msg("API Gateway gracefully stopped")
 }
+
+// shutdown stops the server gracefully. The listener is closed first, so new connections
+// are refused and load balancers can reroute them, then in-flight requests are given until
+// timeout to complete; idle keep-alive connections are closed right away.
+//
+// Parameters:
+//   - app: The server to stop.
+//   - timeout: The time in-flight requests are given to complete.
+//
+// Returns:
+//   - error: An error if requests were still in flight at the timeout.
+func shutdown(app *fiber.App, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return app.ShutdownWithContext(ctx)
+}
+
+// writeReadyFile writes the listen address to the ready file. The file is written
+// under a temporary name and renamed, so a watcher never reads it half-written.
+//
+// Parameters:
+//   - path: The path of the ready file.
+//   - addr: The address the server is listening on.
+//
+// Returns:
+//   - error: An error if the file cannot be written.
+func writeReadyFile(path, addr string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(addr+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write ready file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write ready file: %w", err)
+	}
+	log.Info().Str("path", path).Msg("Ready file written")
+	return nil
+}
+
+// removeReadyFile removes the ready file, if any.
+func removeReadyFile(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Error().Err(err).Str("path", path).Msg("Failed to remove ready file")
+	}
+}
+
+// next is a no-op handler used in place of middleware that is disabled by configuration.
+func next(c *fiber.Ctx) error {
+	return c.Next()
+}
+
+// secretSource returns the source the JWT secret is refreshed from, or nil when the secret
+// is not rotated.
+func secretSource(r config.JWTRotation, limiter *middleware.DependencyLimiter) middleware.SecretSource {
+	switch {
+	case r.File != "":
+		return middleware.FileSecretSource(r.File)
+	case r.URL != "":
+		return middleware.HTTPSecretSource(r.URL, r.Timeout, limiter)
+	default:
+		return nil
+	}
+}
+
+// microCache returns the duplicate request micro-cache of a service, or next when it is disabled.
+func microCache(s config.Service) fiber.Handler {
+	if s.MicroCacheWindow <= 0 {
+		return next
+	}
+	return middleware.MicroCache(s.MicroCacheWindow)
+}
+
+// forwardClaims returns the middleware forwarding token claims to a service, or next when
+// it forwards none.
+func forwardClaims(s config.Service) fiber.Handler {
+	if len(s.ClaimHeaders) == 0 {
+		return next
+	}
+	return middleware.ForwardClaims(s.ClaimHeaders)
+}
+
+// deleteRoles returns the middleware restricting DELETE requests to a service to the
+// allowed roles, or next when any authenticated user may delete.
+func deleteRoles(s config.Service) fiber.Handler {
+	if len(s.DeleteRoles) == 0 {
+		return next
+	}
+	requireRole := middleware.RequireRole(s.DeleteRoles...)
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodDelete {
+			return c.Next()
+		}
+		return requireRole(c)
+	}
+}
+
+// logVerbosity returns the access log verbosity override of a route, or next when the route
+// is logged in full.
+func logVerbosity(verbosity string) fiber.Handler {
+	if verbosity == middleware.LogFull {
+		return next
+	}
+	return middleware.LogVerbosity(verbosity)
+}
+
+// proxyOptions returns the proxy options for the named service from its configuration
+// and the gateway-wide request timeouts of c. Its health checks stop once ctx is done.
+func proxyOptions(ctx context.Context, name string, s config.Service, c config.Config, m *metrics.Upstream) proxy.Options {
+	return proxy.Options{
+		Name:                name,
+		MaxResponseSize:     s.MaxResponseSize,
+		PathPrefix:          s.PathPrefix,
+		StripPrefix:         s.StripPrefix,
+		SSEKeepAlive:        s.SSEKeepAlive,
+		MaxHeaderSize:       int(s.MaxHeaderSize),
+		KeepAlive:           s.KeepAlive,
+		ExpectedContentType: s.ExpectedContentType,
+		ResponseTransform:   s.ResponseTransform,
+		RequestTransform:    s.RequestTransform,
+		Metrics:             m,
+		VerbatimHeaders:     s.VerbatimHeaders,
+		Fallbacks:           s.Fallbacks,
+		PropagateDeadline:   s.PropagateDeadline,
+		DeadlineOverhead:    s.DeadlineOverhead,
+		UserAgentSuffix:     s.UserAgentSuffix,
+		CollapseSlashes:     s.CollapseSlashes,
+		SigningSecret:       s.SigningSecret,
+		BreakerThreshold:    int(s.BreakerThreshold),
+		BreakerCooldown:     s.BreakerCooldown,
+		Retries:             int(s.Retries),
+		RetryDelay:          s.RetryDelay,
+		HealthCheckInterval: s.HealthCheckInterval,
+		HealthCheckPath:     s.HealthCheckPath,
+		UnhealthyThreshold:  int(s.UnhealthyThreshold),
+		HealthContext:       ctx,
+
+		// The service timeout bounds the wait for response headers, the request timeouts the whole exchange.
+		DialTimeout:           s.DialTimeout,
+		ResponseHeaderTimeout: s.Timeout,
+		RequestTimeout:        c.RequestTimeout,
+		RouteTimeouts:         c.RouteTimeouts,
+	}
+}
+
+// servicePrefixes returns the route prefixes of the services for which match reports true.
+func servicePrefixes(c config.Config, match func(config.Service) bool) []string {
+	var prefixes []string
+	if match(c.AuthService) {
+		prefixes = append(prefixes, "/auth/")
+	}
+	if match(c.TemplateService) {
+		prefixes = append(prefixes, "/templates/")
+	}
+	if match(c.PDFService) {
+		prefixes = append(prefixes, "/pdf/")
+	}
+	return prefixes
+}
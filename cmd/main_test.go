@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dashboard-platform/api-gateway/internal/proxy"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// startSlowApp serves an app whose /slow route blocks until release is closed, and returns
+// its address and a channel closed once a /slow request is in flight. When proxied is set,
+// the route proxies to an upstream that blocks instead.
+func startSlowApp(t *testing.T, release chan struct{}, proxied bool) (*fiber.App, string, chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	if proxied {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			_, _ = io.WriteString(w, "done")
+		}))
+		t.Cleanup(upstream.Close)
+		app.Get("/slow", proxy.New(upstream.URL, proxy.Options{Name: "test"}))
+	} else {
+		app.Get("/slow", func(c *fiber.Ctx) error {
+			close(started)
+			<-release
+			return c.SendString("done")
+		})
+	}
+	go func() { _ = app.Listener(ln) }()
+	return app, ln.Addr().String(), started
+}
+
+// TestShutdown tests that new connections are refused as soon as shutdown starts while
+// the in-flight request completes, proxied or not, and that shutdown gives up at the timeout.
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name    string // Name of the test case.
+		proxied bool   // Whether the in-flight request is proxied to an upstream.
+	}{
+		{name: "drain"},
+		{name: "drain proxied", proxied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testShutdownDrain(t, tt.proxied)
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		t.Cleanup(func() { close(release) })
+		app, addr, started := startSlowApp(t, release, false)
+
+		go func() {
+			if resp, err := http.Get("http://" + addr + "/slow"); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+
+		assert.ErrorIs(t, shutdown(app, 100*time.Millisecond), context.DeadlineExceeded)
+	})
+}
+
+// testShutdownDrain shuts the server down while a /slow request is in flight and checks
+// that new connections are refused and the in-flight request completes.
+func testShutdownDrain(t *testing.T, proxied bool) {
+	release := make(chan struct{})
+	app, addr, started := startSlowApp(t, release, proxied)
+
+	type result struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		inFlight <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- shutdown(app, 5*time.Second) }()
+
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	close(release)
+	r := <-inFlight
+	assert.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+	assert.NoError(t, <-stopped)
+}
@@ -0,0 +1,231 @@
+// Package audit delivers security relevant events to an external webhook (e.g. a SIEM).
+// Delivery is asynchronous and bounded: events are buffered in memory, retried a few
+// times, and dropped and counted when the webhook stays down, so auditing never blocks
+// request handling.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Event types emitted by the gateway.
+const (
+	TypeAuthFailure  = "auth_failure"  // A request was rejected for missing or invalid credentials.
+	TypeAccessDenied = "access_denied" // An authenticated or filtered request was refused.
+	TypeAdminAction  = "admin_action"  // An admin endpoint changed the state of the gateway.
+)
+
+const (
+	maxAttempts    = 3                      // Delivery attempts per event.
+	initialBackoff = 200 * time.Millisecond // Wait before the first retry, doubled on every further retry.
+	sendTimeout    = 5 * time.Second        // Timeout of a single delivery attempt.
+)
+
+// redacted replaces the values of sensitive details.
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are substrings of detail keys whose values are never sent.
+var sensitiveKeys = []string{"token", "secret", "password", "authorization", "cookie", "key"}
+
+// Event is a single audit event as posted to the webhook.
+type Event struct {
+	Type    string            `json:"type"`
+	Time    time.Time         `json:"time"`
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	IP      string            `json:"ip,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	UserID  string            `json:"user_id,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Sink posts events as JSON to a webhook from a background goroutine.
+// A nil *Sink is valid and discards events.
+type Sink struct {
+	url     string
+	client  *http.Client
+	events  chan Event
+	backoff time.Duration
+	dropped atomic.Int64
+	done    chan struct{}
+
+	mu     sync.RWMutex // Guards closed, so events is never sent on once closed.
+	closed bool
+
+	ctx    context.Context // Cancelled when Close gives up waiting, aborting the deliveries.
+	cancel context.CancelFunc
+}
+
+// NewSink starts a sink delivering events to url.
+//
+// Parameters:
+//   - url: The webhook endpoint.
+//   - buffer: The maximum number of events waiting for delivery.
+//
+// Returns:
+//   - *Sink: The running sink.
+func NewSink(url string, buffer int) *Sink {
+	s := &Sink{
+		url:     url,
+		client:  &http.Client{Timeout: sendTimeout},
+		events:  make(chan Event, buffer),
+		backoff: initialBackoff,
+		done:    make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s
+}
+
+// Emit queues e for delivery without blocking. Sensitive details are redacted and
+// a missing time is set. When the buffer is full, or the sink is closed, the event is
+// dropped and counted.
+//
+// Parameters:
+//   - e: The event to deliver.
+func (s *Sink) Emit(e Event) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Details = redact(e.Details)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.events <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events that were not delivered.
+//
+// Returns:
+//   - int64: The number of dropped events.
+func (s *Sink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close stops accepting events and waits until the buffered ones have been delivered or
+// dropped. When ctx is done first, the delivery in progress is aborted and the remaining
+// events are dropped. Events emitted after Close, e.g. by requests still running after a
+// timed out shutdown, are dropped.
+//
+// Parameters:
+//   - ctx: The context bounding the wait for the buffered events.
+//
+// Returns:
+//   - error: The error of ctx if the buffered events could not all be delivered in time.
+func (s *Sink) Close(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// run delivers events until the sink is closed.
+func (s *Sink) run() {
+	defer close(s.done)
+	defer s.cancel()
+	for e := range s.events {
+		// Once Close has given up, the remaining events are dropped without being tried.
+		if s.ctx.Err() != nil {
+			s.dropped.Add(1)
+			continue
+		}
+		if err := s.send(e); err != nil {
+			s.dropped.Add(1)
+			log.Warn().Err(err).Str("type", e.Type).Int64("dropped", s.Dropped()).Msg("Failed to deliver audit event")
+		}
+	}
+}
+
+// send posts e to the webhook, retrying with exponential backoff.
+func (s *Sink) send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		err = s.post(body)
+		if err == nil || attempt == maxAttempts {
+			return err
+		}
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt.
+func (s *Sink) post(body []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// redact returns a copy of details with the values of sensitive keys replaced.
+func redact(details map[string]string) map[string]string {
+	if len(details) == 0 {
+		return details
+	}
+	out := make(map[string]string, len(details))
+	for k, v := range details {
+		lower := strings.ToLower(k)
+		for _, s := range sensitiveKeys {
+			if strings.Contains(lower, s) {
+				v = redacted
+				break
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSink_Retry tests that an event is retried until the webhook accepts it and that
+// sensitive details are redacted in the delivered payload.
+func TestSink_Retry(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan Event, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		received <- e
+	}))
+	defer webhook.Close()
+
+	s := NewSink(webhook.URL, 10)
+	s.backoff = time.Millisecond
+	s.Emit(Event{Type: TypeAuthFailure, Path: "/templates", Details: map[string]string{
+		"reason":       "invalid token",
+		"access_token": "eyJhbGciOi",
+	}})
+	assert.NoError(t, s.Close(context.Background()))
+
+	select {
+	case e := <-received:
+		assert.Equal(t, TypeAuthFailure, e.Type)
+		assert.False(t, e.Time.IsZero())
+		assert.Equal(t, "invalid token", e.Details["reason"])
+		assert.Equal(t, redacted, e.Details["access_token"])
+	default:
+		t.Fatal("event was not delivered")
+	}
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Zero(t, s.Dropped())
+}
+
+// TestSink_DropsWhenDown tests that Emit never blocks and that events are dropped and
+// counted when the webhook stays down.
+func TestSink_DropsWhenDown(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+
+	s := NewSink(webhook.URL, 1)
+	s.backoff = time.Millisecond
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		s.Emit(Event{Type: TypeAuthFailure})
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	assert.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, int64(10), s.Dropped())
+}
+
+// TestSink_EmitAfterClose tests that events emitted after Close, e.g. by requests outliving
+// a timed out shutdown, are dropped instead of crashing the gateway.
+func TestSink_EmitAfterClose(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer webhook.Close()
+
+	s := NewSink(webhook.URL, 10)
+	assert.NoError(t, s.Close(context.Background()))
+
+	assert.NotPanics(t, func() { s.Emit(Event{Type: TypeAccessDenied}) })
+	assert.Equal(t, int64(1), s.Dropped())
+	assert.NoError(t, s.Close(context.Background()))
+}
+
+// TestSink_CloseTimeout tests that Close gives up once its context is done, aborting the
+// delivery in progress and dropping the events still buffered.
+func TestSink_CloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer webhook.Close()
+	defer close(release)
+
+	s := NewSink(webhook.URL, 10)
+	for i := 0; i < 5; i++ {
+		s.Emit(Event{Type: TypeAuthFailure})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, s.Close(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int64(5), s.Dropped())
+}
@@ -5,11 +5,22 @@
 package config
 
 import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dashboard-platform/api-gateway/internal/transform"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,33 +28,402 @@ import (
 // It contains environment-specific settings such as the environment name,
 // server port, JWT secret, and database URL.
 type Config struct {
-	Env                string // The current environment (e.g., "dev", "prod").
-	Port               string // The port on which the server will run.
-	FrontendURL        string // The URL of the frontend application.
-	AuthServiceURL     string // The URL of the authentication service.
-	TemplateServiceURL string // The URL of the dashboard service.
-	PDFServiceURL      string // The URL of the PDF service.
-	JWTSecret          []byte // The secret key used for signing JWT tokens.
-	CookieSecure       bool   // The secure flag for cookies (true for HTTPS, false for HTTP).
+	Env                string         // The current environment (e.g., "dev", "prod").
+	Port               string         // The port on which the server will run.
+	FrontendURL        string         // The URL of the frontend application.
+	AuthServiceURL     []string       // The URLs of the authentication service replicas.
+	TemplateServiceURL []string       // The URLs of the dashboard service replicas.
+	PDFServiceURL      []string       // The URLs of the PDF service replicas.
+	JWTSecret          []byte         // The secret key used for signing JWT tokens.
+	JWTPublicKey       *rsa.PublicKey // Public key verifying RS256, RS384 and RS512 tokens (nil when none is configured).
+	CookieSecure       bool           // The secure flag for cookies (true for HTTPS, false for HTTP).
+	AuthService        Service        // Proxy settings for the authentication service.
+	TemplateService    Service        // Proxy settings for the template service.
+	PDFService         Service        // Proxy settings for the PDF service.
+	AnonID             AnonID         // Settings of the anonymous id cookie.
+	RateLimitAlgorithm string         // The rate limiting algorithm ("fixed", "sliding" or "tokenbucket").
+	GatewayTimeFormat  string         // Format of the X-Gateway-Time header ("rfc3339" or "epoch_ms"); empty disables it.
+	FeatureFlags       FeatureFlags   // Settings of the feature flags lookup.
+	BlockedUserAgents  *regexp.Regexp // User agents rejected with 403 (nil when none are configured).
+	AllowedUserAgents  *regexp.Regexp // User agents never rejected, overriding BlockedUserAgents.
+	AuthTokenSource    string         // Where the access token is read from ("cookie_first", "header_first", "cookie_only", "header_only" or "any").
+	AuthCookie         AuthCookie     // Attributes of the auth cookies set by the auth service.
+	AllowedHosts       []string       // Host header values accepted by the gateway, "*.example.com" for subdomains (empty allows all).
+	Audit              Audit          // Settings of the audit event webhook.
+	OTLPEndpoint       string         // Base URL of the OpenTelemetry collector spans are exported to over OTLP/HTTP (empty disables tracing).
+	JWTAllowedAlgs     []string       // Signing algorithms accepted by the token validators (empty accepts HS256, HS384 and HS512).
+	JWTRequireExp      bool           // Whether tokens without an exp claim are rejected.
+	JWTMaxLifetime     time.Duration  // Maximum time until a token expires (0 means unlimited); implies JWTRequireExp.
+	JWTAudience        string         // Audience the aud claim of tokens must contain (empty skips the check).
+	JWTIssuer          string         // Issuer the iss claim of tokens must be (empty skips the check).
+	JWTLeeway          time.Duration  // Clock skew tolerated when checking the exp and nbf claims of tokens.
+
+	JWTValidators map[string][]byte // Secrets of the additional named token validators, selectable per service.
+	JWKS          JWKS              // Settings of the "jwks" token validator, selectable per service.
+	Dependencies  Dependencies      // Bound on the concurrent calls to the dependencies of the middleware.
+	JWTRotation   JWTRotation       // Settings of the background refresh of the JWT_SECRET validator's secret.
+	Nonce         Nonce             // Settings of the replay protection of services with RequireNonce.
+	InstanceID    string            // Id of this gateway instance in X-Gateway-Instance (the hostname by default).
+
+	RequestAttributes map[string]string // Sources of the request attributes extracted once per request, by attribute name.
+	AdminToken        string            // Bearer token of the admin endpoints; empty disables them.
+	RevocationTTL     time.Duration     // How long token ids revoked through the admin endpoint are remembered.
+
+	MaxConcurrentPerUser int64 // Maximum requests in flight per user, or per IP for anonymous clients (0 means unlimited).
+	MaxConcurrentPerIP   int64 // Maximum requests in flight per client IP, authenticated or not (0 means unlimited).
+
+	TrustedProxies []string // IPs or CIDR ranges of the proxies whose ClientIPHeader is trusted (empty uses the connection address).
+	ClientIPHeader string   // Header carrying the client IP set by the trusted proxies.
+
+	StaticRoutes map[string]StaticRoute // Responses served by the gateway itself without auth, by path.
+
+	DeprecatedRoutes map[string]DeprecatedRoute // Deprecation notices of the routes below each path prefix.
+
+	ReadyFile       string        // File written with the listen address once the server accepts connections (empty disables it).
+	ShutdownTimeout time.Duration // Time in-flight requests are given to complete on shutdown.
+
+	ReadinessTimeout time.Duration // Time the readiness probe waits to connect to each upstream replica.
+
+	RequestTimeout time.Duration            // Time a proxied request may take in total (0 means unlimited).
+	RouteTimeouts  map[string]time.Duration // Overrides of RequestTimeout for the paths below each prefix.
+
+	RateLimitMax        int64         // Requests allowed per client IP and window on most routes.
+	RateLimitWindow     time.Duration // Length of the rate limiting window.
+	PreviewRateLimitMax int64         // Requests allowed per client IP and window on the template preview route.
+	UserRateLimitMax    int64         // Requests allowed per user and window on the authenticated routes (0 means unlimited).
+	PreviewLogVerbosity string        // Access logging of the template preview route (the template service's by default).
+}
+
+// StaticRoute holds a static response served by the gateway, e.g. the frontend's runtime config.
+type StaticRoute struct {
+	Body         []byte // Response body.
+	ContentType  string // Content type of the body (application/json by default).
+	CacheControl string // Cache-Control header of the response (none by default).
+}
+
+// DeprecatedRoute holds the deprecation notice announced on the responses of deprecated routes.
+type DeprecatedRoute struct {
+	Deprecated time.Time // When the routes were deprecated.
+	Sunset     time.Time // When the routes stop being served (zero if not planned yet).
+	Link       string    // URL of the migration docs (empty for none).
+}
+
+// Nonce holds the settings of the replay protection.
+type Nonce struct {
+	Window    time.Duration // Maximum age of a request timestamp, and how long nonces are remembered.
+	StoreSize int64         // Maximum number of remembered nonces.
+}
+
+// Audit holds the settings of the optional audit event webhook.
+type Audit struct {
+	WebhookURL string // Endpoint audit events are posted to; empty disables auditing.
+	BufferSize int64  // Maximum number of events waiting for delivery before new ones are dropped.
+
+	FlushTimeout time.Duration // Time the buffered events are given to be delivered on shutdown.
+}
+
+// AuthCookie holds the attributes the auth service uses for the access and refresh token
+// cookies, so the gateway can clear them on logout.
+type AuthCookie struct {
+	Domain   string // Domain attribute of the cookies (empty for host-only).
+	Path     string // Path attribute of the cookies.
+	SameSite string // SameSite attribute of the cookies ("Lax", "Strict" or "None").
+}
+
+// FeatureFlags holds the settings of the optional per-user feature flags lookup.
+type FeatureFlags struct {
+	URL     string        // Endpoint of the flags service; empty disables the lookup.
+	TTL     time.Duration // How long the flags of a user are cached.
+	Timeout time.Duration // Maximum time spent waiting for the flags service.
+}
+
+// JWKS holds the settings of the optional token validator using the keys of a JSON Web Key Set.
+type JWKS struct {
+	URL                string        // Endpoint serving the key set; empty disables the validator.
+	TTL                time.Duration // How long fetched keys are used before the set is fetched again.
+	Timeout            time.Duration // Maximum time spent fetching the set.
+	MinRefreshInterval time.Duration // Minimum time between fetches caused by unknown key ids or failed fetches.
+}
+
+// JWTRotation holds the settings of the optional background refresh of the JWT secret,
+// which lets the secret be rotated without a restart.
+type JWTRotation struct {
+	File     string        // File the secret is read from, re-read when it changes; also provides the initial secret.
+	URL      string        // Endpoint of a secrets provider returning the secret as plain text.
+	Interval time.Duration // Time between two reads of the secret.
+	Grace    time.Duration // How long the previous secret stays valid after a rotation.
+	Timeout  time.Duration // Maximum time spent waiting for the secrets provider.
+}
+
+// Dependencies holds the bound on the concurrent calls to the dependencies of the
+// middleware, such as JWKS fetches.
+type Dependencies struct {
+	MaxConcurrent int64         // Maximum number of concurrent calls.
+	Wait          time.Duration // Maximum time a call waits for a slot before it fails.
+}
+
+// AnonID holds the settings of the opt-in anonymous id cookie issued to unauthenticated clients.
+type AnonID struct {
+	Enabled        bool          // Whether the anonymous id cookie is issued and forwarded.
+	TTL            time.Duration // Lifetime of the cookie.
+	CookieDomain   string        // Domain attribute of the cookie (empty for host-only).
+	CookieSameSite string        // SameSite attribute of the cookie ("Lax", "Strict" or "None").
+}
+
+// Service holds the per-upstream proxy settings.
+// Each field is read from an environment variable prefixed with the service name
+// (e.g. "PDF_SERVICE_MAX_RESPONSE_SIZE").
+type Service struct {
+	MaxResponseSize int64         // Maximum number of response bytes copied from the upstream (0 means unlimited).
+	ForwardOptions  bool          // Whether OPTIONS requests are forwarded to the upstream instead of answered by the gateway.
+	PathPrefix      string        // Path prepended to forwarded requests, for upstreams mounted below the root.
+	StripPrefix     string        // Path removed from the start of forwarded requests before PathPrefix is prepended.
+	AllowedUpgrades []string      // Upgrade protocols permitted on the service routes (e.g. "websocket"); others are rejected.
+	SSEKeepAlive    time.Duration // Idle time after which a keepalive comment is sent on event streams (0 uses the proxy default).
+	MaxHeaderSize   int64         // Maximum size in bytes of the headers forwarded to the upstream (0 means unlimited).
+
+	// ExpectedContentType is the media type successful responses of the service must have
+	// (e.g. "application/pdf"). Empty disables the check.
+	ExpectedContentType string
+
+	// ResponseTransform reshapes successful JSON responses of the service for legacy clients (nil disables it).
+	ResponseTransform *transform.Rules
+
+	// RequestTransform reshapes JSON request bodies sent to the service by legacy clients (nil disables it).
+	RequestTransform *transform.Rules
+
+	MicroCacheWindow time.Duration // How long identical GETs of the same user are answered from a micro-cache (0 disables it).
+	VerbatimHeaders  []string      // Response headers passed to clients with the upstream's exact name casing.
+	JWTValidator     string        // Name of the token validator of the service routes (empty for the JWT_SECRET one).
+	UpstreamCORS     bool          // Whether the upstream sets its own CORS headers; implies ForwardOptions.
+	Fallbacks        []string      // Backup upstream URLs (scheme and host only), tried in order by idempotent requests.
+	RequireNonce     bool          // Whether requests must carry a single-use X-Nonce and a fresh X-Timestamp.
+	KeepAlive        time.Duration // Interval of TCP keep-alive probes on upstream connections.
+	InstanceHeader   bool          // Whether responses carry the X-Gateway-Instance header.
+
+	PropagateDeadline bool          // Whether inbound X-Deadline and Grpc-Timeout deadlines are enforced and forwarded.
+	DeadlineOverhead  time.Duration // Time reserved for the gateway when shortening inbound deadlines.
+	UserAgentSuffix   string        // Appended to the forwarded User-Agent, e.g. "via api-gateway/1.2.3" (empty disables it).
+	CollapseSlashes   bool          // Whether runs of slashes in forwarded paths are collapsed into one.
+	Timeout           time.Duration // Time the upstream has to send its response headers once the request is sent.
+	DialTimeout       time.Duration // Time allowed for connecting to the upstream.
+	UserIDHeader      string        // Header the authenticated user id is forwarded in.
+	LogVerbosity      string        // Access logging of the service routes ("full", "errors-only" or "off").
+
+	// ClaimHeaders forwards token claims to the service, the header of each claim by claim
+	// name (e.g. "email" in X-User-Email). Empty forwards no claims.
+	ClaimHeaders map[string]string
+
+	// DeleteRoles are the token roles allowed to send DELETE requests to the service
+	// (e.g. "admin"). Empty allows every authenticated user.
+	DeleteRoles []string
+
+	// SigningSecret is shared with the service to sign the requests forwarded to it
+	// (nil forwards them unsigned).
+	SigningSecret []byte
+
+	BreakerThreshold int64         // Consecutive failed requests that open the circuit breaker of the service (0 disables it).
+	BreakerCooldown  time.Duration // Time an open circuit breaker rejects requests before a trial request is let through.
+	Retries          int64         // Times GET and HEAD requests are retried when the service cannot be connected to.
+	RetryDelay       time.Duration // Wait before the first retry, doubled before each following one.
+
+	HealthCheckInterval time.Duration // Interval of the health checks of the service replicas (0 disables them).
+	HealthCheckPath     string        // Path the service replicas are probed on.
+	UnhealthyThreshold  int64         // Consecutive failed health checks that take a replica out of rotation.
 }
 
 const (
-	envKey             = "ENV"                  // Environment variable key for the environment name.
-	portEnv            = "PORT"                 // Environment variable key for the server port.
-	frontEndKey        = "FRONTEND_URL"         // Environment variable key for the frontend URL.
-	authServiceKey     = "AUTH_SERVICE_URL"     // Environment variable key for the authentication service URL.
-	templateServiceKey = "TEMPLATE_SERVICE_URL" // Environment variable key for the dashboard service URL.
-	pdfServiceKey      = "PDF_SERVICE_URL"      // Environment variable key for the PDF service URL.
-	jwtSecretKey       = "JWT_SECRET"           // Environment variable key for the JWT secret.
-	cookieSecureKey    = "COOKIE_SECURE"        // Environment variable key for the secure flag of cookies.
-
-	defaultEnvKey = "dev" // Default environment name if none is provided.
+	envKey             = "ENV"                     // Environment variable key for the environment name.
+	portEnv            = "PORT"                    // Environment variable key for the server port.
+	frontEndKey        = "FRONTEND_URL"            // Environment variable key for the frontend URL.
+	authServiceKey     = "AUTH_SERVICE_URL"        // Environment variable key for the authentication service URL.
+	templateServiceKey = "TEMPLATE_SERVICE_URL"    // Environment variable key for the dashboard service URL.
+	pdfServiceKey      = "PDF_SERVICE_URL"         // Environment variable key for the PDF service URL.
+	jwtSecretKey       = "JWT_SECRET"              // Environment variable key for the JWT secret.
+	cookieSecureKey    = "COOKIE_SECURE"           // Environment variable key for the secure flag of cookies.
+	maxResponseSizeKey = "MAX_RESPONSE_SIZE"       // Environment variable key for the default upstream response size cap.
+	forwardOptionsKey  = "FORWARD_OPTIONS"         // Environment variable key suffix for forwarding OPTIONS requests to a service.
+	rateLimitAlgKey    = "RATE_LIMIT_ALGORITHM"    // Environment variable key for the rate limiting algorithm.
+	anonIDEnabledKey   = "ANON_ID_ENABLED"         // Environment variable key for enabling the anonymous id cookie.
+	anonIDTTLKey       = "ANON_ID_TTL"             // Environment variable key for the lifetime of the anonymous id cookie.
+	anonIDDomainKey    = "ANON_ID_COOKIE_DOMAIN"   // Environment variable key for the domain of the anonymous id cookie.
+	anonIDSameSiteKey  = "ANON_ID_COOKIE_SAMESITE" // Environment variable key for the SameSite attribute of the anonymous id cookie.
+
+	gatewayTimeKey   = "GATEWAY_TIME_HEADER" // Environment variable key for the format of the X-Gateway-Time header.
+	pathPrefixKey    = "PATH_PREFIX"         // Environment variable key suffix for the upstream path prefix of a service.
+	stripPrefixKey   = "STRIP_PREFIX"        // Environment variable key suffix for the path prefix removed before forwarding to a service.
+	upgradesKey      = "ALLOWED_UPGRADES"    // Environment variable key suffix for the permitted Upgrade protocols of a service.
+	sseKeepAliveKey  = "SSE_KEEPALIVE"       // Environment variable key suffix for the event stream keepalive interval of a service.
+	maxHeaderSizeKey = "MAX_HEADER_SIZE"     // Environment variable key suffix for the forwarded header size limit of a service.
+
+	featureFlagsURLKey     = "FEATURE_FLAGS_URL"     // Environment variable key for the feature flags service endpoint.
+	featureFlagsTTLKey     = "FEATURE_FLAGS_TTL"     // Environment variable key for the feature flags cache lifetime.
+	featureFlagsTimeoutKey = "FEATURE_FLAGS_TIMEOUT" // Environment variable key for the feature flags lookup timeout.
+
+	blockedUAKey = "BLOCKED_USER_AGENTS" // Environment variable key for the comma-separated user agent patterns to reject.
+	allowedUAKey = "ALLOWED_USER_AGENTS" // Environment variable key for the comma-separated user agent patterns never rejected.
+
+	expectedContentTypeKey = "EXPECTED_CONTENT_TYPE" // Environment variable key suffix for the expected response content type of a service.
+	responseTransformKey   = "RESPONSE_TRANSFORM"    // Environment variable key suffix for the JSON response transformation rules of a service.
+	requestTransformKey    = "REQUEST_TRANSFORM"     // Environment variable key suffix for the JSON request transformation rules of a service.
+	microCacheWindowKey    = "MICRO_CACHE_WINDOW"    // Environment variable key suffix for the duplicate request window of a service.
+	verbatimHeadersKey     = "VERBATIM_HEADERS"      // Environment variable key suffix for the response headers of a service whose casing is preserved.
+	jwtValidatorKey        = "JWT_VALIDATOR"         // Environment variable key suffix for the token validator of a service.
+	upstreamCORSKey        = "UPSTREAM_CORS"         // Environment variable key suffix for whether a service handles CORS itself.
+	fallbacksKey           = "FALLBACKS"             // Environment variable key suffix for the comma-separated backup URLs of a service.
+	requireNonceKey        = "REQUIRE_NONCE"         // Environment variable key suffix for enabling replay protection on a service.
+	collapseSlashesKey     = "COLLAPSE_SLASHES"      // Environment variable key suffix for collapsing duplicate slashes in forwarded paths of a service.
+	userIDHeaderKey        = "USER_ID_HEADER"        // Environment variable key suffix for the header a service expects the user id in.
+	defaultUserIDHeader    = "X-User-ID"             // Default header the user id is forwarded in.
+	logVerbosityKey        = "LOG_VERBOSITY"         // Environment variable key suffix for the access logging of a service.
+	claimHeadersKey        = "CLAIM_HEADERS"         // Environment variable key suffix for the comma-separated claim=header mapping of a service.
+	deleteRolesKey         = "DELETE_ROLES"          // Environment variable key suffix for the comma-separated roles allowed to delete on a service.
+	signingSecretKey       = "SIGNING_SECRET"        // Environment variable key suffix for the secret requests to a service are signed with.
+	breakerThresholdKey    = "BREAKER_THRESHOLD"     // Environment variable key suffix for the failures that open the circuit breaker of a service.
+	breakerCooldownKey     = "BREAKER_COOLDOWN"      // Environment variable key suffix for the time the circuit breaker of a service stays open.
+	defaultBreakerCooldown = 30 * time.Second        // Default time a circuit breaker stays open.
+	retriesKey             = "RETRIES"               // Environment variable key suffix for the connection retries of idempotent requests to a service.
+	retryDelayKey          = "RETRY_DELAY"           // Environment variable key suffix for the wait before the first retry of a service.
+	defaultRetryDelay      = 100 * time.Millisecond  // Default wait before the first retry.
+	healthCheckIntervalKey = "HEALTH_CHECK_INTERVAL" // Environment variable key suffix for the interval of the health checks of a service.
+	healthCheckPathKey     = "HEALTH_CHECK_PATH"     // Environment variable key suffix for the path the replicas of a service are probed on.
+	defaultHealthCheckPath = "/healthcheck"          // Default path replicas are probed on.
+	unhealthyThresholdKey  = "UNHEALTHY_THRESHOLD"   // Environment variable key suffix for the failed health checks that take a replica out of rotation.
+	defaultUnhealthy       = 3                       // Default failed health checks that take a replica out of rotation.
+	defaultLogVerbosity    = "full"                  // Default access logging of a service.
+	previewLogVerbosityKey = "PREVIEW_LOG_VERBOSITY" // Environment variable key for the access logging of the template preview route.
+
+	keepAliveKey     = "UPSTREAM_KEEPALIVE_INTERVAL" // Environment variable key (and per-service suffix) for the TCP keep-alive interval of upstream connections.
+	defaultKeepAlive = 15 * time.Second              // Default TCP keep-alive interval of upstream connections.
+
+	timeoutKey             = "TIMEOUT"       // Environment variable key suffix for the response header timeout of a service.
+	dialTimeoutKey         = "DIAL_TIMEOUT"  // Environment variable key suffix for the connect timeout of a service.
+	defaultUpstreamTimeout = 5 * time.Second // Default response header and connect timeouts of a service.
+
+	instanceHeaderKey = "GATEWAY_INSTANCE_HEADER" // Environment variable key (and per-service suffix) for enabling the X-Gateway-Instance header.
+	instanceIDKey     = "GATEWAY_INSTANCE_ID"     // Environment variable key for the id reported in X-Gateway-Instance.
+
+	propagateDeadlineKey    = "PROPAGATE_DEADLINE"  // Environment variable key (and per-service suffix) for enabling deadline propagation.
+	deadlineOverheadKey     = "DEADLINE_OVERHEAD"   // Environment variable key (and per-service suffix) for the gateway's share of inbound deadlines.
+	defaultDeadlineOverhead = 10 * time.Millisecond // Default gateway share of inbound deadlines.
+
+	userAgentSuffixKey = "USER_AGENT_SUFFIX" // Environment variable key (and per-service suffix) for the suffix of forwarded user agents.
+
+	authTokenSourceKey     = "AUTH_TOKEN_SOURCE" // Environment variable key for where the access token is read from.
+	defaultAuthTokenSource = "cookie_first"      // Default access token source, kept for compatibility.
+
+	authCookieDomainKey       = "AUTH_COOKIE_DOMAIN"   // Environment variable key for the domain of the auth cookies.
+	authCookiePathKey         = "AUTH_COOKIE_PATH"     // Environment variable key for the path of the auth cookies.
+	authCookieSameSiteKey     = "AUTH_COOKIE_SAMESITE" // Environment variable key for the SameSite attribute of the auth cookies.
+	defaultAuthCookiePath     = "/"                    // Default path of the auth cookies.
+	defaultAuthCookieSameSite = "None"                 // Default SameSite attribute of the auth cookies.
+
+	allowedHostsKey = "ALLOWED_HOSTS" // Environment variable key for the comma-separated Host header allowlist.
+
+	auditWebhookKey    = "AUDIT_WEBHOOK_URL" // Environment variable key for the audit event webhook.
+	auditBufferKey     = "AUDIT_BUFFER_SIZE" // Environment variable key for the number of buffered audit events.
+	defaultAuditBuffer = 1000                // Default number of buffered audit events.
+
+	auditFlushTimeoutKey     = "AUDIT_FLUSH_TIMEOUT" // Environment variable key for the time given to deliver the buffered audit events on shutdown.
+	defaultAuditFlushTimeout = 5 * time.Second       // Default time given to deliver the buffered audit events on shutdown.
+
+	otlpEndpointKey = "OTEL_EXPORTER_OTLP_ENDPOINT" // Environment variable key for the base URL of the OpenTelemetry collector.
+
+	jwtValidatorsKey = "JWT_VALIDATORS" // Environment variable key for the comma-separated names of additional token validators.
+
+	// The rotation keys do not start with JWT_SECRET_, which names the secrets of the additional validators.
+	jwtRotationFileKey      = "JWT_ROTATION_FILE"     // Environment variable key for the file the JWT secret is read from.
+	jwtRotationURLKey       = "JWT_ROTATION_URL"      // Environment variable key for the secrets provider the JWT secret is read from.
+	jwtRotationIntervalKey  = "JWT_ROTATION_INTERVAL" // Environment variable key for the time between two reads of the JWT secret.
+	jwtRotationGraceKey     = "JWT_ROTATION_GRACE"    // Environment variable key for how long the previous JWT secret stays valid.
+	jwtRotationTimeoutKey   = "JWT_ROTATION_TIMEOUT"  // Environment variable key for the timeout of the secrets provider.
+	defaultRotationInterval = time.Minute             // Default time between two reads of the JWT secret.
+	defaultRotationGrace    = 15 * time.Minute        // Default time the previous JWT secret stays valid.
+	defaultRotationTimeout  = 2 * time.Second         // Default timeout of the secrets provider.
+
+	jwksURLKey                = "JWKS_URL"                  // Environment variable key for the endpoint of the JSON Web Key Set.
+	jwksTTLKey                = "JWKS_TTL"                  // Environment variable key for how long fetched keys are used.
+	jwksTimeoutKey            = "JWKS_TIMEOUT"              // Environment variable key for the timeout of a key set fetch.
+	jwksMinRefreshKey         = "JWKS_MIN_REFRESH_INTERVAL" // Environment variable key for the minimum time between fetches of unknown keys.
+	jwksValidatorName         = "jwks"                      // Name of the JWKS token validator in <SERVICE>_JWT_VALIDATOR.
+	defaultJWKSTTL            = 5 * time.Minute             // Default time fetched keys are used.
+	defaultJWKSTimeout        = 2 * time.Second             // Default timeout of a key set fetch.
+	defaultJWKSMinRefresh     = 10 * time.Second            // Default minimum time between fetches of unknown keys.
+	dependencyMaxKey          = "DEPENDENCY_MAX_CONCURRENT" // Environment variable key for the maximum concurrent dependency calls.
+	dependencyWaitKey         = "DEPENDENCY_WAIT"           // Environment variable key for the time a dependency call waits for a slot.
+	defaultDependencyMax      = 16                          // Default maximum concurrent dependency calls.
+	defaultDependencyWaitTime = 200 * time.Millisecond      // Default time a dependency call waits for a slot.
+
+	requestAttributesKey = "REQUEST_ATTRIBUTES" // Environment variable key for the comma-separated name=source request attributes.
+	adminTokenKey        = "ADMIN_TOKEN"        // Environment variable key for the bearer token of the admin endpoints.
+	revocationTTLKey     = "REVOCATION_TTL"     // Environment variable key for how long revoked token ids are remembered.
+	defaultRevocationTTL = 24 * time.Hour       // Default time revoked token ids are remembered.
+	readyFileKey         = "READY_FILE"         // Environment variable key for the file written once the server is listening.
+
+	shutdownTimeoutKey     = "SHUTDOWN_TIMEOUT" // Environment variable key for the time in-flight requests get on shutdown.
+	defaultShutdownTimeout = 30 * time.Second   // Default time in-flight requests get on shutdown.
+	requestTimeoutKey      = "REQUEST_TIMEOUT"  // Environment variable key for the time a proxied request may take.
+	routeTimeoutsKey       = "ROUTE_TIMEOUTS"   // Environment variable key for the comma-separated prefix=timeout overrides.
+
+	readinessTimeoutKey     = "READINESS_TIMEOUT" // Environment variable key for the time the readiness probe waits to connect to an upstream.
+	defaultReadinessTimeout = time.Second         // Default time the readiness probe waits to connect to an upstream.
+
+	rateLimitMaxKey            = "RATE_LIMIT_MAX"         // Environment variable key for the requests allowed per window.
+	rateLimitWindowKey         = "RATE_LIMIT_WINDOW"      // Environment variable key for the length of the rate limiting window.
+	previewRateLimitMaxKey     = "PREVIEW_RATE_LIMIT_MAX" // Environment variable key for the requests allowed per window on the preview route.
+	userRateLimitMaxKey        = "USER_RATE_LIMIT_MAX"    // Environment variable key for the requests allowed per user and window.
+	defaultRateLimitMax        = 50                       // Default requests allowed per window.
+	defaultRateLimitWindow     = time.Minute              // Default length of the rate limiting window.
+	defaultPreviewRateLimitMax = 1000                     // Default requests allowed per window on the preview route.
+
+	maxConcurrentKey      = "MAX_CONCURRENT_PER_USER" // Environment variable key for the maximum requests in flight per user.
+	maxConcurrentPerIPKey = "MAX_CONCURRENT_PER_IP"   // Environment variable key for the maximum requests in flight per client IP.
+
+	trustedProxiesKey     = "TRUSTED_PROXIES"  // Environment variable key for the comma-separated trusted proxy IPs or CIDR ranges.
+	clientIPHeaderKey     = "CLIENT_IP_HEADER" // Environment variable key for the header carrying the client IP.
+	defaultClientIPHeader = "X-Forwarded-For"  // Default header carrying the client IP.
+
+	staticRoutesKey   = "STATIC_ROUTES"    // Environment variable key for the JSON object of static routes by path.
+	defaultStaticType = "application/json" // Default content type of static routes.
+
+	deprecatedRoutesKey = "DEPRECATED_ROUTES" // Environment variable key for the JSON object of deprecation notices by path prefix.
+
+	jwtAllowedAlgsKey = "JWT_ALLOWED_ALGS" // Environment variable key for the comma-separated accepted token signing algorithms.
+	jwtPublicKeyKey   = "JWT_PUBLIC_KEY"   // Environment variable key for the PEM encoded RSA public key of asymmetric tokens.
+	jwtRequireExpKey  = "JWT_REQUIRE_EXP"  // Environment variable key for rejecting tokens without expiry.
+	jwtMaxLifetimeKey = "JWT_MAX_LIFETIME" // Environment variable key for the maximum time until a token expires.
+	jwtAudienceKey    = "JWT_AUDIENCE"     // Environment variable key for the audience tokens must be issued for.
+	jwtIssuerKey      = "JWT_ISSUER"       // Environment variable key for the issuer tokens must be issued by.
+	jwtLeewayKey      = "JWT_LEEWAY"       // Environment variable key for the clock skew tolerated on token expiry.
+
+	nonceWindowKey        = "NONCE_WINDOW"     // Environment variable key for the replay protection window.
+	nonceStoreSizeKey     = "NONCE_STORE_SIZE" // Environment variable key for the maximum number of remembered nonces.
+	defaultNonceWindow    = 5 * time.Minute    // Default replay protection window.
+	defaultNonceStoreSize = 100000             // Default maximum number of remembered nonces.
+
+	authServicePrefix     = "AUTH_SERVICE"     // Prefix of the per-service variables for the authentication service.
+	templateServicePrefix = "TEMPLATE_SERVICE" // Prefix of the per-service variables for the template service.
+	pdfServicePrefix      = "PDF_SERVICE"      // Prefix of the per-service variables for the PDF service.
+
+	defaultEnvKey       = "dev"                  // Default environment name if none is provided.
+	prodEnv             = "prod"                 // Environment name of production, where cookies are always Secure.
+	defaultAnonIDTTL    = 365 * 24 * time.Hour   // Default lifetime of the anonymous id cookie.
+	defaultAnonSameSite = "Lax"                  // Default SameSite attribute of the anonymous id cookie.
+	defaultFlagsTTL     = 30 * time.Second       // Default lifetime of cached feature flags.
+	defaultFlagsTimeout = 200 * time.Millisecond // Default timeout of the feature flags lookup.
+	defaultRateLimitAlg = "fixed"                // Default rate limiting algorithm, kept for compatibility.
 )
 
+// headerNamePattern matches the header names accepted in configuration.
+var headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
 // Load retrieves the application configuration from environment variables.
 // It ensures that all required configuration values are set and returns an error
 // if any mandatory value is missing.
 //
+// When CONFIG_FILE names a YAML file, its values are read first and every environment
+// variable that is set overrides the file value of the same key.
+//
 // Returns:
 //   - Config: The loaded application configuration.
 //   - error: An error if any required configuration value is missing.
@@ -56,7 +436,14 @@ func Load() (Config, error) {
 
 	var c Config
 
-	c.Env = os.Getenv(envKey)
+	// File values first, environment overrides second; see getEnv.
+	values, err := readConfigFile(os.Getenv(configFileKey))
+	fileValues = values
+	if err != nil {
+		return Config{}, err
+	}
+
+	c.Env = getEnv(envKey, false)
 	if c.Env == "" {
 		c.Env = defaultEnvKey
 	}
@@ -70,28 +457,63 @@ func Load() (Config, error) {
 	if c.FrontendURL == "" {
 		return Config{}, errors.New("empty key: " + frontEndKey)
 	}
-
-	c.AuthServiceURL = getEnv(authServiceKey, true)
-	if c.AuthServiceURL == "" {
-		return Config{}, errors.New("empty key: " + authServiceKey)
+	// Several origins may be allowed, separated by commas.
+	for _, origin := range strings.Split(c.FrontendURL, ",") {
+		if origin = strings.TrimSpace(origin); origin != "*" {
+			if err := validateURL(frontEndKey, origin); err != nil {
+				return Config{}, err
+			}
+		}
 	}
 
-	c.TemplateServiceURL = getEnv(templateServiceKey, true)
-	if c.TemplateServiceURL == "" {
-		return Config{}, errors.New("empty key: " + templateServiceKey)
+	if c.AuthServiceURL, err = getServiceURLs(authServiceKey); err != nil {
+		return Config{}, err
 	}
-
-	c.PDFServiceURL = getEnv(pdfServiceKey, true)
-	if c.PDFServiceURL == "" {
-		return Config{}, errors.New("empty key: " + pdfServiceKey)
+	if c.TemplateServiceURL, err = getServiceURLs(templateServiceKey); err != nil {
+		return Config{}, err
+	}
+	if c.PDFServiceURL, err = getServiceURLs(pdfServiceKey); err != nil {
+		return Config{}, err
 	}
 
-	c.JWTSecret = []byte(getEnv(jwtSecretKey, true))
-	if len(c.JWTSecret) == 0 {
-		return Config{}, errors.New("empty key: " + jwtSecretKey)
+	// A secret file replaces JWT_SECRET, a secrets provider is only read once the gateway runs.
+	c.JWTRotation.File = getEnv(jwtRotationFileKey, false)
+	c.JWTRotation.URL = getEnv(jwtRotationURLKey, false)
+	switch {
+	case c.JWTRotation.File != "" && c.JWTRotation.URL != "":
+		return Config{}, fmt.Errorf("invalid configuration: %s and %s are mutually exclusive", jwtRotationFileKey, jwtRotationURLKey)
+	case c.JWTRotation.File != "":
+		data, err := os.ReadFile(c.JWTRotation.File)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read %s: %w", jwtRotationFileKey, err)
+		}
+		c.JWTSecret = bytes.TrimSpace(data)
+		if len(c.JWTSecret) == 0 {
+			return Config{}, errors.New("empty file: " + jwtRotationFileKey)
+		}
+	default:
+		if c.JWTRotation.URL != "" {
+			if err := validateURL(jwtRotationURLKey, c.JWTRotation.URL); err != nil {
+				return Config{}, err
+			}
+		}
+		c.JWTSecret = []byte(getEnv(jwtSecretKey, true))
+		if len(c.JWTSecret) == 0 {
+			return Config{}, errors.New("empty key: " + jwtSecretKey)
+		}
+	}
+	if c.JWTRotation.Interval, err = getDuration(jwtRotationIntervalKey, defaultRotationInterval); err != nil {
+		return Config{}, err
+	} else if c.JWTRotation.Interval <= 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", jwtRotationIntervalKey)
+	}
+	if c.JWTRotation.Grace, err = getDuration(jwtRotationGraceKey, defaultRotationGrace); err != nil {
+		return Config{}, err
+	}
+	if c.JWTRotation.Timeout, err = getDuration(jwtRotationTimeoutKey, defaultRotationTimeout); err != nil {
+		return Config{}, err
 	}
 
-	var err error
 	cookieSecureStr := getEnv(cookieSecureKey, true)
 	if cookieSecureStr == "" { // Check if getEnv returned empty because the key was missing
 		// This check assumes getEnv logs the error if required and missing,
@@ -102,12 +524,818 @@ func Load() (Config, error) {
 	if err != nil {
 		return Config{}, fmt.Errorf("invalid value for %s ('%s'): %w", cookieSecureKey, cookieSecureStr, err)
 	}
+	// Sessions must never travel over plain HTTP in production, whatever the setting says.
+	if c.Env == prodEnv && !c.CookieSecure {
+		log.Warn().Str("var", cookieSecureKey).Msg("Ignoring insecure cookies in production, cookies are always Secure")
+		c.CookieSecure = true
+	}
+
+	// The global response size cap is the default for every service and may be
+	// overridden per service, e.g. PDF_SERVICE_MAX_RESPONSE_SIZE=0 exempts the PDF routes.
+	maxResponseSize, err := getInt64(maxResponseSizeKey, 0)
+	if err != nil {
+		return Config{}, err
+	}
+	keepAlive, err := getDuration(keepAliveKey, defaultKeepAlive)
+	if err != nil {
+		return Config{}, err
+	}
+	instanceHeader, err := getBool(instanceHeaderKey, false)
+	if err != nil {
+		return Config{}, err
+	}
+	propagateDeadline, err := getBool(propagateDeadlineKey, false)
+	if err != nil {
+		return Config{}, err
+	}
+	deadlineOverhead, err := getDuration(deadlineOverheadKey, defaultDeadlineOverhead)
+	if err != nil {
+		return Config{}, err
+	}
+	defaults := Service{
+		MaxResponseSize:   maxResponseSize,
+		KeepAlive:         keepAlive,
+		InstanceHeader:    instanceHeader,
+		PropagateDeadline: propagateDeadline,
+		DeadlineOverhead:  deadlineOverhead,
+		UserAgentSuffix:   getEnv(userAgentSuffixKey, false),
+		Timeout:           defaultUpstreamTimeout,
+		DialTimeout:       defaultUpstreamTimeout,
+		UserIDHeader:      defaultUserIDHeader,
+		LogVerbosity:      defaultLogVerbosity,
+		BreakerCooldown:   defaultBreakerCooldown,
+		RetryDelay:        defaultRetryDelay,
+
+		HealthCheckPath:    defaultHealthCheckPath,
+		UnhealthyThreshold: defaultUnhealthy,
+	}
+
+	if c.AuthService, err = loadService(authServicePrefix, defaults); err != nil {
+		return Config{}, err
+	}
+	if c.TemplateService, err = loadService(templateServicePrefix, defaults); err != nil {
+		return Config{}, err
+	}
+	if c.PDFService, err = loadService(pdfServicePrefix, defaults); err != nil {
+		return Config{}, err
+	}
+
+	// Each additional validator reads its secret from JWT_SECRET_<NAME>.
+	c.JWTValidators = make(map[string][]byte)
+	for _, name := range getList(jwtValidatorsKey, nil) {
+		key := jwtSecretKey + "_" + strings.ToUpper(name)
+		secret := getEnv(key, true)
+		if secret == "" {
+			return Config{}, errors.New("empty key: " + key)
+		}
+		if name == jwksValidatorName {
+			return Config{}, fmt.Errorf("invalid value for %s ('%s'): reserved for %s", jwtValidatorsKey, name, jwksURLKey)
+		}
+		c.JWTValidators[name] = []byte(secret)
+	}
+
+	if c.JWKS.URL = getEnv(jwksURLKey, false); c.JWKS.URL != "" {
+		if err := validateURL(jwksURLKey, c.JWKS.URL); err != nil {
+			return Config{}, err
+		}
+	}
+	if c.JWKS.TTL, err = getDuration(jwksTTLKey, defaultJWKSTTL); err != nil {
+		return Config{}, err
+	}
+	if c.JWKS.Timeout, err = getDuration(jwksTimeoutKey, defaultJWKSTimeout); err != nil {
+		return Config{}, err
+	}
+	if c.JWKS.MinRefreshInterval, err = getDuration(jwksMinRefreshKey, defaultJWKSMinRefresh); err != nil {
+		return Config{}, err
+	}
+
+	for prefix, s := range map[string]Service{authServicePrefix: c.AuthService, templateServicePrefix: c.TemplateService, pdfServicePrefix: c.PDFService} {
+		if s.JWTValidator == jwksValidatorName {
+			if c.JWKS.URL == "" {
+				return Config{}, fmt.Errorf("invalid value for %s_%s ('%s'): requires %s", prefix, jwtValidatorKey, s.JWTValidator, jwksURLKey)
+			}
+			continue
+		}
+		if _, ok := c.JWTValidators[s.JWTValidator]; s.JWTValidator != "" && !ok {
+			return Config{}, fmt.Errorf("invalid value for %s_%s ('%s'): not listed in %s", prefix, jwtValidatorKey, s.JWTValidator, jwtValidatorsKey)
+		}
+	}
+
+	if publicKey := getEnv(jwtPublicKeyKey, false); publicKey != "" {
+		if c.JWTPublicKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(publicKey)); err != nil {
+			return Config{}, fmt.Errorf("invalid value for %s: %w", jwtPublicKeyKey, err)
+		}
+	}
+
+	// RSA algorithms can only be allowed when there is a public key to verify them with.
+	c.JWTAllowedAlgs = getList(jwtAllowedAlgsKey, nil)
+	for _, alg := range c.JWTAllowedAlgs {
+		switch alg {
+		case "HS256", "HS384", "HS512":
+		case "RS256", "RS384", "RS512":
+			if c.JWTPublicKey == nil {
+				return Config{}, fmt.Errorf("invalid value for %s ('%s'): requires %s", jwtAllowedAlgsKey, alg, jwtPublicKeyKey)
+			}
+		default:
+			return Config{}, fmt.Errorf("invalid value for %s ('%s'): must be HS256, HS384, HS512, RS256, RS384 or RS512", jwtAllowedAlgsKey, alg)
+		}
+	}
+
+	if c.JWTRequireExp, err = getBool(jwtRequireExpKey, false); err != nil {
+		return Config{}, err
+	}
+	if c.JWTMaxLifetime, err = getDuration(jwtMaxLifetimeKey, 0); err != nil {
+		return Config{}, err
+	}
+	c.JWTAudience = getEnv(jwtAudienceKey, false)
+	c.JWTIssuer = getEnv(jwtIssuerKey, false)
+	if c.JWTLeeway, err = getDuration(jwtLeewayKey, 0); err != nil {
+		return Config{}, err
+	} else if c.JWTLeeway < 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must not be negative", jwtLeewayKey)
+	}
+
+	c.RateLimitAlgorithm = getEnv(rateLimitAlgKey, false)
+	switch c.RateLimitAlgorithm {
+	case "":
+		c.RateLimitAlgorithm = defaultRateLimitAlg
+	case "fixed", "sliding", "tokenbucket":
+	default:
+		return Config{}, fmt.Errorf("invalid value for %s ('%s'): must be fixed, sliding or tokenbucket", rateLimitAlgKey, c.RateLimitAlgorithm)
+	}
+	if c.RateLimitMax, err = getInt64(rateLimitMaxKey, defaultRateLimitMax); err != nil {
+		return Config{}, err
+	} else if c.RateLimitMax == 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", rateLimitMaxKey)
+	}
+	if c.PreviewRateLimitMax, err = getInt64(previewRateLimitMaxKey, defaultPreviewRateLimitMax); err != nil {
+		return Config{}, err
+	} else if c.PreviewRateLimitMax == 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", previewRateLimitMaxKey)
+	}
+	if c.UserRateLimitMax, err = getInt64(userRateLimitMaxKey, 0); err != nil {
+		return Config{}, err
+	}
+	if c.PreviewLogVerbosity, err = getLogVerbosity(previewLogVerbosityKey, c.TemplateService.LogVerbosity); err != nil {
+		return Config{}, err
+	}
+	if c.RateLimitWindow, err = getDuration(rateLimitWindowKey, defaultRateLimitWindow); err != nil {
+		return Config{}, err
+	} else if c.RateLimitWindow <= 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", rateLimitWindowKey)
+	}
+
+	c.AuthTokenSource = getEnv(authTokenSourceKey, false)
+	switch c.AuthTokenSource {
+	case "":
+		c.AuthTokenSource = defaultAuthTokenSource
+	case "cookie_first", "header_first", "cookie_only", "header_only", "any":
+	default:
+		return Config{}, fmt.Errorf("invalid value for %s ('%s'): must be cookie_first, header_first, cookie_only, header_only or any", authTokenSourceKey, c.AuthTokenSource)
+	}
+
+	c.GatewayTimeFormat = getEnv(gatewayTimeKey, false)
+	switch c.GatewayTimeFormat {
+	case "", "rfc3339", "epoch_ms":
+	default:
+		return Config{}, fmt.Errorf("invalid value for %s ('%s'): must be rfc3339 or epoch_ms", gatewayTimeKey, c.GatewayTimeFormat)
+	}
+
+	c.FeatureFlags.URL = getEnv(featureFlagsURLKey, false)
+	if c.FeatureFlags.TTL, err = getDuration(featureFlagsTTLKey, defaultFlagsTTL); err != nil {
+		return Config{}, err
+	}
+	if c.FeatureFlags.Timeout, err = getDuration(featureFlagsTimeoutKey, defaultFlagsTimeout); err != nil {
+		return Config{}, err
+	}
+
+	if c.Dependencies.MaxConcurrent, err = getInt64(dependencyMaxKey, defaultDependencyMax); err != nil {
+		return Config{}, err
+	} else if c.Dependencies.MaxConcurrent <= 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", dependencyMaxKey)
+	}
+	if c.Dependencies.Wait, err = getDuration(dependencyWaitKey, defaultDependencyWaitTime); err != nil {
+		return Config{}, err
+	}
+
+	if c.BlockedUserAgents, err = getPatterns(blockedUAKey); err != nil {
+		return Config{}, err
+	}
+	if c.AllowedUserAgents, err = getPatterns(allowedUAKey); err != nil {
+		return Config{}, err
+	}
+
+	if c.AnonID.Enabled, err = getBool(anonIDEnabledKey, false); err != nil {
+		return Config{}, err
+	}
+	if c.AnonID.TTL, err = getDuration(anonIDTTLKey, defaultAnonIDTTL); err != nil {
+		return Config{}, err
+	}
+	c.AnonID.CookieDomain = getEnv(anonIDDomainKey, false)
+	c.AnonID.CookieSameSite = getEnv(anonIDSameSiteKey, false)
+	if c.AnonID.CookieSameSite == "" {
+		c.AnonID.CookieSameSite = defaultAnonSameSite
+	}
+
+	c.AllowedHosts = getList(allowedHostsKey, nil)
+
+	// Attributes are given as name=source, e.g. "tenant=header:X-Tenant-ID,client_ip=ip".
+	c.RequestAttributes = make(map[string]string)
+	for _, item := range getList(requestAttributesKey, nil) {
+		name, source, _ := strings.Cut(item, "=")
+		kind, arg, _ := strings.Cut(source, ":")
+		switch {
+		case name == "":
+		case kind == "header" || kind == "cookie" || kind == "query":
+			if arg != "" {
+				c.RequestAttributes[name] = source
+				continue
+			}
+		case source == "host" || source == "ip":
+			c.RequestAttributes[name] = source
+			continue
+		}
+		return Config{}, fmt.Errorf("invalid value for %s ('%s'): must be name=source with source header:<name>, cookie:<name>, query:<name>, host or ip", requestAttributesKey, item)
+	}
+
+	c.AdminToken = getEnv(adminTokenKey, false)
+	if c.RevocationTTL, err = getDuration(revocationTTLKey, defaultRevocationTTL); err != nil {
+		return Config{}, err
+	}
+	if c.RevocationTTL <= 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", revocationTTLKey)
+	}
+	c.ReadyFile = getEnv(readyFileKey, false)
+	if c.ShutdownTimeout, err = getDuration(shutdownTimeoutKey, defaultShutdownTimeout); err != nil {
+		return Config{}, err
+	}
+	if c.RequestTimeout, err = getDuration(requestTimeoutKey, 0); err != nil {
+		return Config{}, err
+	}
+	if c.ReadinessTimeout, err = getDuration(readinessTimeoutKey, defaultReadinessTimeout); err != nil {
+		return Config{}, err
+	}
+	if c.ReadinessTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", readinessTimeoutKey)
+	}
+	c.RouteTimeouts = make(map[string]time.Duration)
+	for _, item := range getList(routeTimeoutsKey, nil) {
+		prefix, value, _ := strings.Cut(item, "=")
+		timeout, err := time.ParseDuration(value)
+		if !strings.HasPrefix(prefix, "/") || err != nil || timeout <= 0 {
+			return Config{}, fmt.Errorf("invalid value for %s ('%s'): must be prefix=timeout with a path prefix and a positive duration", routeTimeoutsKey, item)
+		}
+		// Draining on shutdown is not extended for long routes, their requests are cut off.
+		if timeout > c.ShutdownTimeout {
+			log.Warn().
+				Str("route", prefix).
+				Dur("timeout", timeout).
+				Dur("shutdown_timeout", c.ShutdownTimeout).
+				Msgf("Route timeout exceeds %s, requests in flight at shutdown may be cut off", shutdownTimeoutKey)
+		}
+		c.RouteTimeouts[prefix] = timeout
+	}
+
+	if c.StaticRoutes, err = getStaticRoutes(staticRoutesKey); err != nil {
+		return Config{}, err
+	}
+	if c.DeprecatedRoutes, err = getDeprecatedRoutes(deprecatedRoutesKey); err != nil {
+		return Config{}, err
+	}
+
+	if c.MaxConcurrentPerUser, err = getInt64(maxConcurrentKey, 0); err != nil {
+		return Config{}, err
+	}
+	if c.MaxConcurrentPerIP, err = getInt64(maxConcurrentPerIPKey, 0); err != nil {
+		return Config{}, err
+	}
+
+	c.TrustedProxies = getList(trustedProxiesKey, nil)
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return Config{}, fmt.Errorf("invalid value for %s ('%s'): must be an IP or CIDR range", trustedProxiesKey, proxy)
+		}
+	}
+	c.ClientIPHeader = getEnv(clientIPHeaderKey, false)
+	if c.ClientIPHeader == "" {
+		c.ClientIPHeader = defaultClientIPHeader
+	}
+
+	c.InstanceID = getEnv(instanceIDKey, false)
+	if c.InstanceID == "" {
+		c.InstanceID, _ = os.Hostname()
+	}
+
+	if c.Nonce.Window, err = getDuration(nonceWindowKey, defaultNonceWindow); err != nil {
+		return Config{}, err
+	}
+	if c.Nonce.StoreSize, err = getInt64(nonceStoreSizeKey, defaultNonceStoreSize); err != nil {
+		return Config{}, err
+	}
+
+	c.Audit.WebhookURL = getEnv(auditWebhookKey, false)
+	if c.Audit.BufferSize, err = getInt64(auditBufferKey, defaultAuditBuffer); err != nil {
+		return Config{}, err
+	}
+	if c.Audit.FlushTimeout, err = getDuration(auditFlushTimeoutKey, defaultAuditFlushTimeout); err != nil {
+		return Config{}, err
+	} else if c.Audit.FlushTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid value for %s: must be positive", auditFlushTimeoutKey)
+	}
+
+	c.OTLPEndpoint = getEnv(otlpEndpointKey, false)
+	if c.OTLPEndpoint != "" {
+		if err := validateURL(otlpEndpointKey, c.OTLPEndpoint); err != nil {
+			return Config{}, err
+		}
+	}
+
+	c.AuthCookie.Domain = getEnv(authCookieDomainKey, false)
+	c.AuthCookie.Path = getEnv(authCookiePathKey, false)
+	if c.AuthCookie.Path == "" {
+		c.AuthCookie.Path = defaultAuthCookiePath
+	}
+	c.AuthCookie.SameSite = getEnv(authCookieSameSiteKey, false)
+	if c.AuthCookie.SameSite == "" {
+		c.AuthCookie.SameSite = defaultAuthCookieSameSite
+	}
 
 	return c, nil
 }
 
-// getEnv retrieves the value of an environment variable.
-// If the variable is not set and 'required' is true, it logs an error.
+// loadService reads the per-service settings for the service identified by prefix.
+// Settings that are not set for the service fall back to the given defaults.
+//
+// Parameters:
+//   - prefix: The environment variable prefix of the service (e.g. "PDF_SERVICE").
+//   - defaults: The values used for settings that are not set.
+//
+// Returns:
+//   - Service: The loaded service settings.
+//   - error: An error if any setting has an invalid value.
+func loadService(prefix string, defaults Service) (Service, error) {
+	s := defaults
+
+	var err error
+	s.MaxResponseSize, err = getInt64(prefix+"_"+maxResponseSizeKey, defaults.MaxResponseSize)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.ForwardOptions, err = getBool(prefix+"_"+forwardOptionsKey, defaults.ForwardOptions)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.PathPrefix = getEnv(prefix+"_"+pathPrefixKey, false)
+	if s.PathPrefix != "" && !strings.HasPrefix(s.PathPrefix, "/") {
+		return Service{}, fmt.Errorf("invalid value for %s_%s ('%s'): must start with '/'", prefix, pathPrefixKey, s.PathPrefix)
+	}
+	s.StripPrefix = getEnv(prefix+"_"+stripPrefixKey, false)
+	if s.StripPrefix != "" && !strings.HasPrefix(s.StripPrefix, "/") {
+		return Service{}, fmt.Errorf("invalid value for %s_%s ('%s'): must start with '/'", prefix, stripPrefixKey, s.StripPrefix)
+	}
+
+	s.AllowedUpgrades = getList(prefix+"_"+upgradesKey, defaults.AllowedUpgrades)
+
+	s.SSEKeepAlive, err = getDuration(prefix+"_"+sseKeepAliveKey, defaults.SSEKeepAlive)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.MaxHeaderSize, err = getInt64(prefix+"_"+maxHeaderSizeKey, defaults.MaxHeaderSize)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.ExpectedContentType = getEnv(prefix+"_"+expectedContentTypeKey, false)
+	if s.ExpectedContentType != "" {
+		if _, _, err := mime.ParseMediaType(s.ExpectedContentType); err != nil {
+			return Service{}, fmt.Errorf("invalid value for %s_%s ('%s'): %w", prefix, expectedContentTypeKey, s.ExpectedContentType, err)
+		}
+	}
+
+	if rules := getEnv(prefix+"_"+responseTransformKey, false); rules != "" {
+		if s.ResponseTransform, err = transform.Parse([]byte(rules)); err != nil {
+			return Service{}, fmt.Errorf("invalid value for %s_%s: %w", prefix, responseTransformKey, err)
+		}
+	}
+	if rules := getEnv(prefix+"_"+requestTransformKey, false); rules != "" {
+		if s.RequestTransform, err = transform.Parse([]byte(rules)); err != nil {
+			return Service{}, fmt.Errorf("invalid value for %s_%s: %w", prefix, requestTransformKey, err)
+		}
+	}
+
+	s.MicroCacheWindow, err = getDuration(prefix+"_"+microCacheWindowKey, defaults.MicroCacheWindow)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.VerbatimHeaders = getList(prefix+"_"+verbatimHeadersKey, defaults.VerbatimHeaders)
+	s.JWTValidator = getEnv(prefix+"_"+jwtValidatorKey, false)
+
+	s.UpstreamCORS, err = getBool(prefix+"_"+upstreamCORSKey, defaults.UpstreamCORS)
+	if err != nil {
+		return Service{}, err
+	}
+	// An upstream that handles CORS must also answer its own preflights.
+	if s.UpstreamCORS {
+		s.ForwardOptions = true
+	}
+
+	s.RequireNonce, err = getBool(prefix+"_"+requireNonceKey, defaults.RequireNonce)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.CollapseSlashes, err = getBool(prefix+"_"+collapseSlashesKey, defaults.CollapseSlashes)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.KeepAlive, err = getDuration(prefix+"_"+keepAliveKey, defaults.KeepAlive)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.Timeout, err = getDuration(prefix+"_"+timeoutKey, defaults.Timeout)
+	if err != nil {
+		return Service{}, err
+	}
+	s.DialTimeout, err = getDuration(prefix+"_"+dialTimeoutKey, defaults.DialTimeout)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.InstanceHeader, err = getBool(prefix+"_"+instanceHeaderKey, defaults.InstanceHeader)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.PropagateDeadline, err = getBool(prefix+"_"+propagateDeadlineKey, defaults.PropagateDeadline)
+	if err != nil {
+		return Service{}, err
+	}
+
+	s.DeadlineOverhead, err = getDuration(prefix+"_"+deadlineOverheadKey, defaults.DeadlineOverhead)
+	if err != nil {
+		return Service{}, err
+	}
+
+	if s.UserAgentSuffix = getEnv(prefix+"_"+userAgentSuffixKey, false); s.UserAgentSuffix == "" {
+		s.UserAgentSuffix = defaults.UserAgentSuffix
+	}
+
+	if s.UserIDHeader = getEnv(prefix+"_"+userIDHeaderKey, false); s.UserIDHeader == "" {
+		s.UserIDHeader = defaults.UserIDHeader
+	} else if !headerNamePattern.MatchString(s.UserIDHeader) {
+		return Service{}, fmt.Errorf("invalid value for %s_%s ('%s'): must be a header name", prefix, userIDHeaderKey, s.UserIDHeader)
+	}
+
+	if s.LogVerbosity, err = getLogVerbosity(prefix+"_"+logVerbosityKey, defaults.LogVerbosity); err != nil {
+		return Service{}, err
+	}
+
+	s.ClaimHeaders = make(map[string]string)
+	for _, item := range getList(prefix+"_"+claimHeadersKey, nil) {
+		claim, header, _ := strings.Cut(item, "=")
+		if claim == "" || !headerNamePattern.MatchString(header) {
+			return Service{}, fmt.Errorf("invalid value for %s_%s ('%s'): must be claim=header", prefix, claimHeadersKey, item)
+		}
+		s.ClaimHeaders[claim] = header
+	}
+
+	s.DeleteRoles = getList(prefix+"_"+deleteRolesKey, nil)
+	if secret := getEnv(prefix+"_"+signingSecretKey, false); secret != "" {
+		s.SigningSecret = []byte(secret)
+	}
+
+	if s.BreakerThreshold, err = getInt64(prefix+"_"+breakerThresholdKey, defaults.BreakerThreshold); err != nil {
+		return Service{}, err
+	}
+	if s.BreakerCooldown, err = getDuration(prefix+"_"+breakerCooldownKey, defaults.BreakerCooldown); err != nil {
+		return Service{}, err
+	}
+	if s.BreakerCooldown <= 0 {
+		return Service{}, fmt.Errorf("invalid value for %s_%s: must be positive", prefix, breakerCooldownKey)
+	}
+
+	if s.Retries, err = getInt64(prefix+"_"+retriesKey, defaults.Retries); err != nil {
+		return Service{}, err
+	}
+	if s.RetryDelay, err = getDuration(prefix+"_"+retryDelayKey, defaults.RetryDelay); err != nil {
+		return Service{}, err
+	}
+	if s.RetryDelay <= 0 {
+		return Service{}, fmt.Errorf("invalid value for %s_%s: must be positive", prefix, retryDelayKey)
+	}
+
+	if s.HealthCheckInterval, err = getDuration(prefix+"_"+healthCheckIntervalKey, defaults.HealthCheckInterval); err != nil {
+		return Service{}, err
+	}
+	s.HealthCheckPath = getEnv(prefix+"_"+healthCheckPathKey, false)
+	if s.HealthCheckPath == "" {
+		s.HealthCheckPath = defaults.HealthCheckPath
+	}
+	if !strings.HasPrefix(s.HealthCheckPath, "/") {
+		return Service{}, fmt.Errorf("invalid value for %s_%s: must start with '/'", prefix, healthCheckPathKey)
+	}
+	if s.UnhealthyThreshold, err = getInt64(prefix+"_"+unhealthyThresholdKey, defaults.UnhealthyThreshold); err != nil {
+		return Service{}, err
+	}
+	if s.UnhealthyThreshold <= 0 {
+		return Service{}, fmt.Errorf("invalid value for %s_%s: must be positive", prefix, unhealthyThresholdKey)
+	}
+
+	s.Fallbacks = getList(prefix+"_"+fallbacksKey, defaults.Fallbacks)
+	for _, fallback := range s.Fallbacks {
+		u, err := url.Parse(fallback)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return Service{}, fmt.Errorf("invalid value for %s_%s ('%s'): must be absolute URLs without a path", prefix, fallbacksKey, fallback)
+		}
+	}
+
+	return s, nil
+}
+
+// getLogVerbosity retrieves an optional access log verbosity from an environment variable.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//   - def: The value returned when the variable is not set.
+//
+// Returns:
+//   - string: The verbosity, "full", "errors-only" or "off".
+//   - error: An error if the value is not a known verbosity.
+func getLogVerbosity(key, def string) (string, error) {
+	switch val := getEnv(key, false); val {
+	case "":
+		return def, nil
+	case "full", "errors-only", "off":
+		return val, nil
+	default:
+		return "", fmt.Errorf("invalid value for %s ('%s'): must be full, errors-only or off", key, val)
+	}
+}
+
+// validateURL checks that value is an absolute http or https URL with a host.
+//
+// Parameters:
+//   - key: The name of the environment variable holding the URL, used in the error.
+//   - value: The URL to validate.
+//
+// Returns:
+//   - error: A descriptive error if the URL is invalid.
+func validateURL(key, value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s ('%s'): %w", key, value, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid value for %s ('%s'): scheme must be http or https", key, value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid value for %s ('%s'): missing host", key, value)
+	}
+	return nil
+}
+
+// getServiceURLs retrieves the required comma-separated URLs of the replicas of a service
+// from an environment variable. All replicas must serve the service below the same path.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//
+// Returns:
+//   - []string: The URLs of the replicas.
+//   - error: An error if the variable is not set or a URL is not valid.
+func getServiceURLs(key string) ([]string, error) {
+	if getEnv(key, true) == "" {
+		return nil, errors.New("empty key: " + key)
+	}
+	urls := getList(key, nil)
+	if len(urls) == 0 {
+		return nil, errors.New("empty key: " + key)
+	}
+	var path string
+	for i, raw := range urls {
+		if err := validateURL(key, raw); err != nil {
+			return nil, err
+		}
+		u, _ := url.Parse(raw)
+		if i == 0 {
+			path = strings.TrimSuffix(u.Path, "/")
+		} else if strings.TrimSuffix(u.Path, "/") != path {
+			return nil, fmt.Errorf("invalid value for %s ('%s'): replicas must have the same path", key, raw)
+		}
+	}
+	return urls, nil
+}
+
+// getBool retrieves an optional boolean from an environment variable.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//   - def: The value returned when the variable is not set.
+//
+// Returns:
+//   - bool: The parsed value, or def if the variable is not set.
+//   - error: An error if the value is not a valid boolean.
+func getBool(key string, def bool) (bool, error) {
+	str := getEnv(key, false)
+	if str == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s ('%s'): %w", key, str, err)
+	}
+	return val, nil
+}
+
+// getDuration retrieves an optional non-negative duration (e.g. "30s") from an environment variable.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//   - def: The value returned when the variable is not set.
+//
+// Returns:
+//   - time.Duration: The parsed value, or def if the variable is not set.
+//   - error: An error if the value is not a valid non-negative duration.
+func getDuration(key string, def time.Duration) (time.Duration, error) {
+	str := getEnv(key, false)
+	if str == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(str)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("invalid value for %s ('%s'): must be a non-negative duration", key, str)
+	}
+	return val, nil
+}
+
+// getList retrieves an optional comma-separated list from an environment variable.
+// Surrounding whitespace and empty items are dropped.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//   - def: The value returned when the variable is not set.
+//
+// Returns:
+//   - []string: The list items, or def if the variable is not set.
+func getList(key string, def []string) []string {
+	str := getEnv(key, false)
+	if str == "" {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getPatterns retrieves an optional comma-separated list of regular expressions from an
+// environment variable and compiles them into a single case-insensitive pattern matching any of them.
+// Plain substrings are valid patterns.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//
+// Returns:
+//   - *regexp.Regexp: The combined pattern, or nil if the variable is not set.
+//   - error: An error if any pattern is invalid.
+func getPatterns(key string) (*regexp.Regexp, error) {
+	items := getList(key, nil)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	for i, item := range items {
+		if _, err := regexp.Compile(item); err != nil {
+			return nil, fmt.Errorf("invalid value for %s ('%s'): %w", key, item, err)
+		}
+		items[i] = "(?:" + item + ")"
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(items, "|")), nil
+}
+
+// getStaticRoutes retrieves optional static routes from an environment variable holding a
+// JSON object of routes by path, e.g. {"/config.json": {"body": {"apiUrl": "/api"}, "cache_control": "max-age=60"}}.
+// A body is a JSON value, except that strings are served as plain text for other content types.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//
+// Returns:
+//   - map[string]StaticRoute: The routes by path, or nil if the variable is not set.
+//   - error: An error if the value is not valid.
+func getStaticRoutes(key string) (map[string]StaticRoute, error) {
+	str := getEnv(key, false)
+	if str == "" {
+		return nil, nil
+	}
+
+	var raw map[string]struct {
+		Body         json.RawMessage `json:"body"`
+		ContentType  string          `json:"content_type"`
+		CacheControl string          `json:"cache_control"`
+	}
+	if err := json.Unmarshal([]byte(str), &raw); err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+
+	routes := make(map[string]StaticRoute, len(raw))
+	for path, r := range raw {
+		if !strings.HasPrefix(path, "/") || len(r.Body) == 0 {
+			return nil, fmt.Errorf("invalid value for %s ('%s'): paths must start with '/' and have a body", key, path)
+		}
+		route := StaticRoute{Body: r.Body, ContentType: r.ContentType, CacheControl: r.CacheControl}
+		if route.ContentType == "" {
+			route.ContentType = defaultStaticType
+		}
+		var text string
+		if mediaType, _, _ := mime.ParseMediaType(route.ContentType); mediaType != defaultStaticType && json.Unmarshal(r.Body, &text) == nil {
+			route.Body = []byte(text)
+		}
+		routes[path] = route
+	}
+	return routes, nil
+}
+
+// getDeprecatedRoutes retrieves optional deprecation notices from an environment variable
+// holding a JSON object of notices by path prefix, e.g.
+// {"/templates/v1": {"deprecated": "2026-06-01T00:00:00Z", "sunset": "2027-01-01T00:00:00Z", "link": "https://docs.example.com/v2"}}.
+// The deprecation time is required; the sunset, which must follow it, and the link are optional.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//
+// Returns:
+//   - map[string]DeprecatedRoute: The notices by path prefix, or nil if the variable is not set.
+//   - error: An error if the value is not valid.
+func getDeprecatedRoutes(key string) (map[string]DeprecatedRoute, error) {
+	str := getEnv(key, false)
+	if str == "" {
+		return nil, nil
+	}
+
+	var raw map[string]struct {
+		Deprecated time.Time  `json:"deprecated"`
+		Sunset     *time.Time `json:"sunset"`
+		Link       string     `json:"link"`
+	}
+	if err := json.Unmarshal([]byte(str), &raw); err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+
+	routes := make(map[string]DeprecatedRoute, len(raw))
+	for prefix, r := range raw {
+		if !strings.HasPrefix(prefix, "/") || r.Deprecated.IsZero() {
+			return nil, fmt.Errorf("invalid value for %s ('%s'): prefixes must start with '/' and have a deprecation time", key, prefix)
+		}
+		route := DeprecatedRoute{Deprecated: r.Deprecated, Link: r.Link}
+		if r.Sunset != nil {
+			if !r.Sunset.After(r.Deprecated) {
+				return nil, fmt.Errorf("invalid value for %s ('%s'): the sunset must follow the deprecation", key, prefix)
+			}
+			route.Sunset = *r.Sunset
+		}
+		if route.Link != "" {
+			u, err := url.Parse(route.Link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(route.Link, "<> ") {
+				return nil, fmt.Errorf("invalid value for %s ('%s'): the link must be an absolute HTTP URL", key, prefix)
+			}
+		}
+		routes[prefix] = route
+	}
+	return routes, nil
+}
+
+// getInt64 retrieves an optional non-negative integer from an environment variable.
+//
+// Parameters:
+//   - key: The name of the environment variable to retrieve.
+//   - def: The value returned when the variable is not set.
+//
+// Returns:
+//   - int64: The parsed value, or def if the variable is not set.
+//   - error: An error if the value is not a non-negative integer.
+func getInt64(key string, def int64) (int64, error) {
+	str := getEnv(key, false)
+	if str == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("invalid value for %s ('%s'): must be a non-negative integer", key, str)
+	}
+	return val, nil
+}
+
+// getEnv retrieves the value of an environment variable, or of the configuration file
+// when the variable is not set. If neither is set and 'required' is true, it logs an error.
 //
 // Parameters:
 //   - key: The name of the environment variable to retrieve.
@@ -116,7 +1344,10 @@ func Load() (Config, error) {
 // Returns:
 //   - string: The value of the environment variable, or an empty string if not set.
 func getEnv(key string, required bool) string {
-	val := os.Getenv(key)
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		val = fileValues[key]
+	}
 	if val == "" && required {
 		// Use the globally configured logger from the main package or logger package.
 		// Avoid reconfiguring the logger here.
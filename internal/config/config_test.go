@@ -5,8 +5,15 @@
 package config
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -139,6 +146,8 @@ func TestLoad(t *testing.T) {
 					// For test cases that include cookieSecureKey and are not the "invalid" case,
 					// set a valid boolean string (e.g., "true") to ensure strconv.ParseBool succeeds.
 					envMap[cookieSecureKey] = "true"
+				} else if strings.HasSuffix(envName, "_URL") {
+					envMap[envName] = "http://test"
 				} else {
 					envMap[envName] = "test"
 				}
@@ -189,3 +198,907 @@ func TestLoad(t *testing.T) {
 		})
 	}
 }
+
+// TestLoad_InvalidURLs tests that malformed service and frontend URLs fail the load with
+// an error naming the offending variable.
+func TestLoad_InvalidURLs(t *testing.T) {
+	tests := []struct {
+		key   string // Variable set to the malformed URL.
+		value string // Malformed URL.
+	}{
+		{key: authServiceKey, value: "htttp://auth"},
+		{key: authServiceKey, value: "auth:8080"},
+		{key: templateServiceKey, value: "template"},
+		{key: templateServiceKey, value: "ftp://template"},
+		{key: pdfServiceKey, value: "http://"},
+		{key: pdfServiceKey, value: "http://pdf:port"},
+		{key: frontEndKey, value: "localhost:3000"},
+		{key: frontEndKey, value: "http://localhost:3000, app.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+" "+tt.value, func(t *testing.T) {
+			setRequiredEnvs(t)
+			t.Setenv(tt.key, tt.value)
+
+			_, err := Load()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.key)
+			}
+		})
+	}
+
+	setRequiredEnvs(t)
+	t.Setenv(frontEndKey, "http://localhost:3000, https://app.example.com")
+	_, err := Load()
+	assert.NoError(t, err)
+}
+
+// setRequiredEnvs sets every required environment variable to a valid value for the duration of the test.
+func setRequiredEnvs(t *testing.T) {
+	t.Helper()
+	t.Setenv(portEnv, ":8080")
+	t.Setenv(frontEndKey, "http://localhost:3000")
+	t.Setenv(authServiceKey, "http://auth")
+	t.Setenv(templateServiceKey, "http://template")
+	t.Setenv(pdfServiceKey, "http://pdf")
+	t.Setenv(jwtSecretKey, "secret")
+	t.Setenv(cookieSecureKey, "false")
+}
+
+// TestLoad_ServiceURLs tests that service URLs are parsed into the list of replicas, that a
+// single URL still works, and that replicas must be valid URLs with the same path.
+func TestLoad_ServiceURLs(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv(templateServiceKey, "http://template-1:8080/api, http://template-2:8080/api/,http://template-3:8080/api")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://template-1:8080/api", "http://template-2:8080/api/", "http://template-3:8080/api"}, cfg.TemplateServiceURL)
+	assert.Equal(t, []string{"http://pdf"}, cfg.PDFServiceURL)
+
+	for _, invalid := range []string{" , ", "http://template-1,template-2", "http://template-1/api,http://template-2/v2"} {
+		t.Setenv(templateServiceKey, invalid)
+		_, err = Load()
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestLoad_MaxResponseSize tests that the global response size cap is applied to every
+// service and can be overridden per service.
+func TestLoad_MaxResponseSize(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv(maxResponseSizeKey, "1024")
+	t.Setenv(pdfServicePrefix+"_"+maxResponseSizeKey, "0")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), cfg.AuthService.MaxResponseSize)
+	assert.Equal(t, int64(1024), cfg.TemplateService.MaxResponseSize)
+	assert.Equal(t, int64(0), cfg.PDFService.MaxResponseSize)
+
+	t.Setenv(maxResponseSizeKey, "-1")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_RateLimitAlgorithm tests that the rate limiting algorithm defaults to the fixed
+// window and that unknown algorithms are rejected.
+func TestLoad_RateLimitAlgorithm(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "fixed", cfg.RateLimitAlgorithm)
+
+	t.Setenv(rateLimitAlgKey, "tokenbucket")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "tokenbucket", cfg.RateLimitAlgorithm)
+
+	t.Setenv(rateLimitAlgKey, "leaky")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_AuthTokenSource tests that the token source defaults to cookie_first and that
+// unknown sources are rejected.
+func TestLoad_AuthTokenSource(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "cookie_first", cfg.AuthTokenSource)
+
+	t.Setenv(authTokenSourceKey, "any")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "any", cfg.AuthTokenSource)
+
+	t.Setenv(authTokenSourceKey, "query")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_JWTValidators tests that named validators read their secret from JWT_SECRET_<NAME>
+// and that services can only select listed validators.
+func TestLoad_JWTValidators(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv(jwtValidatorsKey, "partners")
+	t.Setenv("JWT_SECRET_PARTNERS", "partner-secret")
+	t.Setenv("PDF_SERVICE_JWT_VALIDATOR", "partners")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("partner-secret"), cfg.JWTValidators["partners"])
+	assert.Equal(t, "partners", cfg.PDFService.JWTValidator)
+	assert.Empty(t, cfg.TemplateService.JWTValidator)
+
+	t.Setenv("TEMPLATE_SERVICE_JWT_VALIDATOR", "unknown")
+	_, err = Load()
+	assert.Error(t, err)
+
+	t.Setenv("TEMPLATE_SERVICE_JWT_VALIDATOR", "")
+	t.Setenv("JWT_SECRET_PARTNERS", "")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_JWTAllowedAlgs tests that only HMAC algorithms can be allowed.
+func TestLoad_JWTAllowedAlgs(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.JWTAllowedAlgs)
+
+	t.Setenv(jwtAllowedAlgsKey, "HS256, HS512")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HS256", "HS512"}, cfg.JWTAllowedAlgs)
+
+	for _, invalid := range []string{"none", "RS256", "hs256"} {
+		t.Setenv(jwtAllowedAlgsKey, invalid)
+		_, err = Load()
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestLoad_JWTRotation tests that a secret file replaces JWT_SECRET, that a secrets provider
+// keeps it required, and that both sources cannot be combined.
+func TestLoad_JWTRotation(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, JWTRotation{Interval: time.Minute, Grace: 15 * time.Minute, Timeout: 2 * time.Second}, cfg.JWTRotation)
+
+	path := filepath.Join(t.TempDir(), "jwt_secret")
+	assert.NoError(t, os.WriteFile(path, []byte("file-secret\n"), 0o600))
+	t.Setenv(jwtRotationFileKey, path)
+	t.Setenv(jwtSecretKey, "")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("file-secret"), cfg.JWTSecret)
+	assert.Equal(t, path, cfg.JWTRotation.File)
+
+	t.Setenv(jwtRotationURLKey, "https://secrets.internal/jwt")
+	_, err = Load()
+	assert.Error(t, err, "file and provider")
+
+	t.Setenv(jwtRotationFileKey, "")
+	_, err = Load()
+	assert.Error(t, err, "provider without JWT_SECRET")
+
+	t.Setenv(jwtSecretKey, "secret")
+	t.Setenv(jwtRotationGraceKey, "1h")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://secrets.internal/jwt", cfg.JWTRotation.URL)
+	assert.Equal(t, time.Hour, cfg.JWTRotation.Grace)
+
+	t.Setenv(jwtRotationFileKey, filepath.Join(t.TempDir(), "missing"))
+	t.Setenv(jwtRotationURLKey, "")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_JWTAudienceIssuer tests that the expected audience and issuer are optional.
+func TestLoad_JWTAudienceIssuer(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.JWTAudience)
+	assert.Empty(t, cfg.JWTIssuer)
+
+	t.Setenv(jwtAudienceKey, "api-gateway")
+	t.Setenv(jwtIssuerKey, "https://auth.example.com")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "api-gateway", cfg.JWTAudience)
+	assert.Equal(t, "https://auth.example.com", cfg.JWTIssuer)
+}
+
+// TestLoad_JWTLeeway tests that the leeway defaults to zero and must not be negative.
+func TestLoad_JWTLeeway(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Zero(t, cfg.JWTLeeway)
+
+	t.Setenv(jwtLeewayKey, "5s")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, cfg.JWTLeeway)
+
+	t.Setenv(jwtLeewayKey, "-5s")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_RouteTimeouts tests that route timeouts are parsed and that invalid prefixes and
+// durations are rejected.
+func TestLoad_RouteTimeouts(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Zero(t, cfg.RequestTimeout)
+	assert.Empty(t, cfg.RouteTimeouts)
+
+	t.Setenv(requestTimeoutKey, "30s")
+	t.Setenv(routeTimeoutsKey, "/pdf/bulk=5m, /templates/export=90s")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.RequestTimeout)
+	assert.Equal(t, map[string]time.Duration{"/pdf/bulk": 5 * time.Minute, "/templates/export": 90 * time.Second}, cfg.RouteTimeouts)
+
+	for _, invalid := range []string{"pdf/bulk=5m", "/pdf/bulk", "/pdf/bulk=soon", "/pdf/bulk=-1s", "/pdf/bulk=0"} {
+		t.Setenv(routeTimeoutsKey, invalid)
+		_, err = Load()
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestLoad_JWKS tests the JWKS settings, that services can only select the jwks validator
+// when JWKS_URL is set, and the bound on concurrent dependency calls.
+func TestLoad_JWKS(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, JWKS{TTL: 5 * time.Minute, Timeout: 2 * time.Second, MinRefreshInterval: 10 * time.Second}, cfg.JWKS)
+	assert.Equal(t, Dependencies{MaxConcurrent: 16, Wait: 200 * time.Millisecond}, cfg.Dependencies)
+
+	t.Setenv("PDF_SERVICE_"+jwtValidatorKey, "jwks")
+	_, err = Load()
+	assert.Error(t, err)
+
+	t.Setenv(jwksURLKey, "https://auth.example.com/.well-known/jwks.json")
+	t.Setenv(jwksTTLKey, "1m")
+	t.Setenv(dependencyMaxKey, "4")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://auth.example.com/.well-known/jwks.json", cfg.JWKS.URL)
+	assert.Equal(t, time.Minute, cfg.JWKS.TTL)
+	assert.Equal(t, "jwks", cfg.PDFService.JWTValidator)
+	assert.Equal(t, int64(4), cfg.Dependencies.MaxConcurrent)
+
+	t.Setenv(dependencyMaxKey, "0")
+	_, err = Load()
+	assert.Error(t, err)
+	t.Setenv(dependencyMaxKey, "4")
+
+	t.Setenv(jwtValidatorsKey, "jwks")
+	t.Setenv(jwtSecretKey+"_JWKS", "secret")
+	_, err = Load()
+	assert.Error(t, err, "reserved validator name")
+	t.Setenv(jwtValidatorsKey, "")
+
+	t.Setenv(jwksURLKey, "ftp://auth")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_JWTPublicKey tests that the RSA public key is parsed from PEM and enables the
+// RSA algorithms.
+func TestLoad_JWTPublicKey(t *testing.T) {
+	setRequiredEnvs(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+
+	t.Setenv(jwtPublicKeyKey, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	t.Setenv(jwtAllowedAlgsKey, "RS256")
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(cfg.JWTPublicKey))
+	assert.Equal(t, []string{"RS256"}, cfg.JWTAllowedAlgs)
+
+	t.Setenv(jwtPublicKeyKey, "not a key")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_UpstreamCORS tests that a service handling CORS itself also gets its OPTIONS
+// requests forwarded.
+func TestLoad_UpstreamCORS(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("AUTH_SERVICE_UPSTREAM_CORS", "true")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.True(t, cfg.AuthService.UpstreamCORS)
+	assert.True(t, cfg.AuthService.ForwardOptions)
+	assert.False(t, cfg.PDFService.ForwardOptions)
+}
+
+// TestLoad_Fallbacks tests that fallback URLs are read per service and must be absolute
+// URLs without a path.
+func TestLoad_Fallbacks(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("AUTH_SERVICE_FALLBACKS", "http://auth-eu, http://auth-us:8080")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://auth-eu", "http://auth-us:8080"}, cfg.AuthService.Fallbacks)
+	assert.Empty(t, cfg.PDFService.Fallbacks)
+
+	t.Setenv("AUTH_SERVICE_FALLBACKS", "auth-eu")
+	_, err = Load()
+	assert.Error(t, err)
+
+	t.Setenv("AUTH_SERVICE_FALLBACKS", "http://auth-eu/api")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_KeepAlive tests that the global keep-alive interval applies to every service
+// and can be overridden per service.
+func TestLoad_KeepAlive(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Second, cfg.AuthService.KeepAlive)
+
+	t.Setenv(keepAliveKey, "30s")
+	t.Setenv("PDF_SERVICE_"+keepAliveKey, "5s")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.TemplateService.KeepAlive)
+	assert.Equal(t, 5*time.Second, cfg.PDFService.KeepAlive)
+}
+
+// TestLoad_CookieSecure tests that cookies are always Secure in production while other
+// environments honor COOKIE_SECURE.
+func TestLoad_CookieSecure(t *testing.T) {
+	tests := []struct {
+		env          string // Value of ENV.
+		cookieSecure string // Value of COOKIE_SECURE.
+		want         bool   // Expected CookieSecure.
+	}{
+		{env: "prod", cookieSecure: "false", want: true},
+		{env: "prod", cookieSecure: "true", want: true},
+		{env: "dev", cookieSecure: "false", want: false},
+		{env: "dev", cookieSecure: "true", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env+" "+tt.cookieSecure, func(t *testing.T) {
+			setRequiredEnvs(t)
+			t.Setenv(envKey, tt.env)
+			t.Setenv(cookieSecureKey, tt.cookieSecure)
+
+			cfg, err := Load()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, cfg.CookieSecure)
+		})
+	}
+}
+
+// TestLoad_RateLimit tests that the rate limits default to 50 and 1000 requests per minute
+// with no per-user limit, that the window is parsed as a duration and that invalid values
+// are rejected.
+func TestLoad_RateLimit(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), cfg.RateLimitMax)
+	assert.Equal(t, int64(1000), cfg.PreviewRateLimitMax)
+	assert.Equal(t, time.Minute, cfg.RateLimitWindow)
+	assert.Equal(t, int64(0), cfg.UserRateLimitMax)
+
+	t.Setenv(rateLimitMaxKey, "200")
+	t.Setenv(previewRateLimitMaxKey, "5000")
+	t.Setenv(userRateLimitMaxKey, "20")
+	t.Setenv(rateLimitWindowKey, "30s")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), cfg.RateLimitMax)
+	assert.Equal(t, int64(5000), cfg.PreviewRateLimitMax)
+	assert.Equal(t, 30*time.Second, cfg.RateLimitWindow)
+	assert.Equal(t, int64(20), cfg.UserRateLimitMax)
+
+	for key, value := range map[string]string{rateLimitMaxKey: "0", previewRateLimitMaxKey: "-1", userRateLimitMaxKey: "-1", rateLimitWindowKey: "0s"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			_, err := Load()
+			assert.Error(t, err)
+		})
+	}
+	t.Setenv(rateLimitWindowKey, "60")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_ShutdownTimeout tests that in-flight requests get 30s on shutdown by default.
+func TestLoad_ShutdownTimeout(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.ShutdownTimeout)
+
+	t.Setenv(shutdownTimeoutKey, "5s")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, cfg.ShutdownTimeout)
+}
+
+// TestLoad_AuditFlushTimeout tests that the buffered audit events get 5s on shutdown by
+// default and that non-positive values are rejected.
+func TestLoad_AuditFlushTimeout(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, cfg.Audit.FlushTimeout)
+
+	t.Setenv(auditFlushTimeoutKey, "2s")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, cfg.Audit.FlushTimeout)
+
+	t.Setenv(auditFlushTimeoutKey, "0s")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_Timeout tests that upstream timeouts default to 5s and are set per service.
+func TestLoad_Timeout(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("PDF_SERVICE_"+timeoutKey, "45s")
+	t.Setenv("AUTH_SERVICE_"+dialTimeoutKey, "1s")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Second, cfg.PDFService.Timeout)
+	assert.Equal(t, 5*time.Second, cfg.PDFService.DialTimeout)
+	assert.Equal(t, 5*time.Second, cfg.AuthService.Timeout)
+	assert.Equal(t, time.Second, cfg.AuthService.DialTimeout)
+
+	t.Setenv("PDF_SERVICE_"+timeoutKey, "slow")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_UserIDHeader tests that the user id header defaults to X-User-ID, is set per
+// service and must be a valid header name.
+func TestLoad_UserIDHeader(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("PDF_SERVICE_"+userIDHeaderKey, "X-Authenticated-User-Id")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "X-User-ID", cfg.TemplateService.UserIDHeader)
+	assert.Equal(t, "X-Authenticated-User-Id", cfg.PDFService.UserIDHeader)
+
+	t.Setenv("PDF_SERVICE_"+userIDHeaderKey, "X-User Id")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_LogVerbosity tests that access log verbosities default to full, that the preview
+// route follows the template service unless set, and that unknown values are rejected.
+func TestLoad_LogVerbosity(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "full", cfg.AuthService.LogVerbosity)
+	assert.Equal(t, "full", cfg.PreviewLogVerbosity)
+
+	t.Setenv("TEMPLATE_SERVICE_"+logVerbosityKey, "errors-only")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "errors-only", cfg.TemplateService.LogVerbosity)
+	assert.Equal(t, "errors-only", cfg.PreviewLogVerbosity)
+
+	t.Setenv(previewLogVerbosityKey, "off")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "off", cfg.PreviewLogVerbosity)
+
+	t.Setenv("PDF_SERVICE_"+logVerbosityKey, "verbose")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_ClaimHeaders tests that claim header mappings are parsed per service and that
+// invalid entries are rejected.
+func TestLoad_ClaimHeaders(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("TEMPLATE_SERVICE_"+claimHeadersKey, "email=X-User-Email, role=X-User-Role")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"email": "X-User-Email", "role": "X-User-Role"}, cfg.TemplateService.ClaimHeaders)
+	assert.Empty(t, cfg.PDFService.ClaimHeaders)
+
+	for _, invalid := range []string{"email", "=X-User-Email", "email=X User Email"} {
+		t.Setenv("PDF_SERVICE_"+claimHeadersKey, invalid)
+		_, err = Load()
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestLoad_RequestAttributes tests that request attributes are parsed and invalid sources rejected.
+func TestLoad_RequestAttributes(t *testing.T) {
+	setRequiredEnvs(t)
+
+	t.Setenv(requestAttributesKey, "tenant=header:X-Tenant-ID, client_ip=ip,host=host")
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant": "header:X-Tenant-ID", "client_ip": "ip", "host": "host"}, cfg.RequestAttributes)
+
+	for _, invalid := range []string{"tenant", "tenant=header:", "=ip", "plan=claim:plan"} {
+		t.Setenv(requestAttributesKey, invalid)
+		_, err = Load()
+		assert.Error(t, err, invalid)
+	}
+}
+
+// writeConfigFile writes a YAML configuration file and points CONFIG_FILE at it.
+func writeConfigFile(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configFileKey, path)
+}
+
+// TestLoad_ConfigFile tests that the configuration is loaded from the file alone, from the
+// environment alone, and from both with environment variables overriding file values.
+func TestLoad_ConfigFile(t *testing.T) {
+	const file = `
+PORT: ":9090"
+FRONTEND_URL: http://localhost:3000
+AUTH_SERVICE_URL: http://auth
+TEMPLATE_SERVICE_URL: http://template
+PDF_SERVICE_URL: http://pdf
+JWT_SECRET: file-secret
+COOKIE_SECURE: false
+MAX_RESPONSE_SIZE: 1024
+ALLOWED_HOSTS: [api.example.com, "*.example.com"]
+STATIC_ROUTES:
+  /config.json:
+    body: {apiUrl: /api}
+`
+
+	t.Run("file only", func(t *testing.T) {
+		writeConfigFile(t, file)
+		cfg, err := Load()
+		assert.NoError(t, err)
+		assert.Equal(t, ":9090", cfg.Port)
+		assert.Equal(t, []byte("file-secret"), cfg.JWTSecret)
+		assert.False(t, cfg.CookieSecure)
+		assert.Equal(t, int64(1024), cfg.PDFService.MaxResponseSize)
+		assert.Equal(t, []string{"api.example.com", "*.example.com"}, cfg.AllowedHosts)
+		assert.JSONEq(t, `{"apiUrl":"/api"}`, string(cfg.StaticRoutes["/config.json"].Body))
+	})
+
+	t.Run("env only", func(t *testing.T) {
+		setRequiredEnvs(t)
+		cfg, err := Load()
+		assert.NoError(t, err)
+		assert.Equal(t, ":8080", cfg.Port)
+		assert.Equal(t, []byte("secret"), cfg.JWTSecret)
+		assert.Equal(t, int64(0), cfg.PDFService.MaxResponseSize)
+	})
+
+	t.Run("env overrides file", func(t *testing.T) {
+		writeConfigFile(t, file)
+		t.Setenv(jwtSecretKey, "env-secret")
+		t.Setenv(maxResponseSizeKey, "")
+		cfg, err := Load()
+		assert.NoError(t, err)
+		assert.Equal(t, ":9090", cfg.Port)
+		assert.Equal(t, []byte("env-secret"), cfg.JWTSecret)
+		assert.Equal(t, int64(0), cfg.PDFService.MaxResponseSize)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		for _, content := range []string{"- not a mapping", "ALLOWED_HOSTS: [[nested]]"} {
+			writeConfigFile(t, content)
+			_, err := Load()
+			assert.Error(t, err, content)
+		}
+
+		t.Setenv(configFileKey, filepath.Join(t.TempDir(), "missing.yaml"))
+		_, err := Load()
+		assert.Error(t, err)
+	})
+}
+
+// TestLoad_TrustedProxies tests that trusted proxies accept IPs and CIDR ranges only,
+// and that the client IP header defaults to X-Forwarded-For.
+func TestLoad_TrustedProxies(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.TrustedProxies)
+	assert.Equal(t, "X-Forwarded-For", cfg.ClientIPHeader)
+
+	t.Setenv(trustedProxiesKey, "10.0.0.0/8, 192.168.1.1,::1")
+	t.Setenv(clientIPHeaderKey, "X-Real-IP")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1", "::1"}, cfg.TrustedProxies)
+	assert.Equal(t, "X-Real-IP", cfg.ClientIPHeader)
+
+	t.Setenv(trustedProxiesKey, "10.0.0.0/8,proxy.internal")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_PropagateDeadline tests the global deadline settings and their per-service overrides.
+func TestLoad_PropagateDeadline(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.False(t, cfg.AuthService.PropagateDeadline)
+	assert.Equal(t, 10*time.Millisecond, cfg.AuthService.DeadlineOverhead)
+
+	t.Setenv(propagateDeadlineKey, "true")
+	t.Setenv(deadlineOverheadKey, "50ms")
+	t.Setenv(pdfServicePrefix+"_"+propagateDeadlineKey, "false")
+	t.Setenv(pdfServicePrefix+"_"+deadlineOverheadKey, "1s")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.True(t, cfg.TemplateService.PropagateDeadline)
+	assert.Equal(t, 50*time.Millisecond, cfg.TemplateService.DeadlineOverhead)
+	assert.False(t, cfg.PDFService.PropagateDeadline)
+	assert.Equal(t, time.Second, cfg.PDFService.DeadlineOverhead)
+}
+
+// TestLoad_StaticRoutes tests that static routes are parsed with their defaults and invalid ones rejected.
+func TestLoad_StaticRoutes(t *testing.T) {
+	setRequiredEnvs(t)
+
+	t.Setenv(staticRoutesKey, `{"/config.json": {"body": {"apiUrl": "/api"}, "cache_control": "max-age=60"},
+		"/robots.txt": {"body": "User-agent: *", "content_type": "text/plain"}}`)
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, StaticRoute{Body: []byte(`{"apiUrl": "/api"}`), ContentType: "application/json", CacheControl: "max-age=60"}, cfg.StaticRoutes["/config.json"])
+	assert.Equal(t, StaticRoute{Body: []byte("User-agent: *"), ContentType: "text/plain"}, cfg.StaticRoutes["/robots.txt"])
+
+	for _, invalid := range []string{`{"config.json": {"body": {}}}`, `{"/config.json": {}}`, `[]`} {
+		t.Setenv(staticRoutesKey, invalid)
+		_, err = Load()
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestLoad_DeleteRoles tests that the roles allowed to delete are parsed per service.
+func TestLoad_DeleteRoles(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("TEMPLATE_SERVICE_"+deleteRolesKey, "admin, owner")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "owner"}, cfg.TemplateService.DeleteRoles)
+	assert.Empty(t, cfg.PDFService.DeleteRoles)
+}
+
+// TestLoad_SigningSecret tests that request signing is configured per service.
+func TestLoad_SigningSecret(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("PDF_SERVICE_"+signingSecretKey, "shared-secret")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("shared-secret"), cfg.PDFService.SigningSecret)
+	assert.Nil(t, cfg.TemplateService.SigningSecret)
+}
+
+// TestLoad_RevocationTTL tests the default and validation of the revocation TTL.
+func TestLoad_RevocationTTL(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRevocationTTL, cfg.RevocationTTL)
+
+	t.Setenv(revocationTTLKey, "8h")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 8*time.Hour, cfg.RevocationTTL)
+
+	t.Setenv(revocationTTLKey, "0s")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_ReadinessTimeout tests the default and validation of the readiness probe timeout.
+func TestLoad_ReadinessTimeout(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultReadinessTimeout, cfg.ReadinessTimeout)
+
+	t.Setenv(readinessTimeoutKey, "250ms")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, cfg.ReadinessTimeout)
+
+	t.Setenv(readinessTimeoutKey, "0s")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_Breaker tests the defaults and validation of the circuit breaker settings.
+func TestLoad_Breaker(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("PDF_SERVICE_"+breakerThresholdKey, "5")
+	t.Setenv("PDF_SERVICE_"+breakerCooldownKey, "10s")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), cfg.PDFService.BreakerThreshold)
+	assert.Equal(t, 10*time.Second, cfg.PDFService.BreakerCooldown)
+	assert.Equal(t, int64(0), cfg.TemplateService.BreakerThreshold)
+	assert.Equal(t, defaultBreakerCooldown, cfg.TemplateService.BreakerCooldown)
+
+	t.Setenv("PDF_SERVICE_"+breakerCooldownKey, "0s")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_Retries tests the defaults and validation of the retry settings.
+func TestLoad_Retries(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("TEMPLATE_SERVICE_"+retriesKey, "3")
+	t.Setenv("TEMPLATE_SERVICE_"+retryDelayKey, "50ms")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), cfg.TemplateService.Retries)
+	assert.Equal(t, 50*time.Millisecond, cfg.TemplateService.RetryDelay)
+	assert.Equal(t, int64(0), cfg.PDFService.Retries)
+	assert.Equal(t, defaultRetryDelay, cfg.PDFService.RetryDelay)
+
+	t.Setenv("TEMPLATE_SERVICE_"+retryDelayKey, "0s")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_OTLPEndpoint tests that tracing is off by default and that the collector
+// endpoint must be an absolute http(s) URL.
+func TestLoad_OTLPEndpoint(t *testing.T) {
+	setRequiredEnvs(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.OTLPEndpoint)
+
+	t.Setenv(otlpEndpointKey, "http://otel-collector:4318")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://otel-collector:4318", cfg.OTLPEndpoint)
+
+	t.Setenv(otlpEndpointKey, "otel-collector:4318")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_StripPrefix tests that the prefix stripped before forwarding is configured per
+// service and must start with a slash.
+func TestLoad_StripPrefix(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("TEMPLATE_SERVICE_"+stripPrefixKey, "/templates")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "/templates", cfg.TemplateService.StripPrefix)
+	assert.Empty(t, cfg.PDFService.StripPrefix)
+
+	t.Setenv("TEMPLATE_SERVICE_"+stripPrefixKey, "templates")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_HealthChecks tests that the health checks of a service are configured per service,
+// and that relative probe paths and a zero threshold are rejected.
+func TestLoad_HealthChecks(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv("TEMPLATE_SERVICE_"+healthCheckIntervalKey, "5s")
+	t.Setenv("TEMPLATE_SERVICE_"+healthCheckPathKey, "/ready")
+	t.Setenv("TEMPLATE_SERVICE_"+unhealthyThresholdKey, "2")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, cfg.TemplateService.HealthCheckInterval)
+	assert.Equal(t, "/ready", cfg.TemplateService.HealthCheckPath)
+	assert.Equal(t, int64(2), cfg.TemplateService.UnhealthyThreshold)
+	assert.Equal(t, time.Duration(0), cfg.PDFService.HealthCheckInterval)
+	assert.Equal(t, defaultHealthCheckPath, cfg.PDFService.HealthCheckPath)
+	assert.Equal(t, int64(defaultUnhealthy), cfg.PDFService.UnhealthyThreshold)
+
+	t.Setenv("TEMPLATE_SERVICE_"+healthCheckPathKey, "ready")
+	_, err = Load()
+	assert.Error(t, err)
+
+	t.Setenv("TEMPLATE_SERVICE_"+healthCheckPathKey, "/ready")
+	t.Setenv("TEMPLATE_SERVICE_"+unhealthyThresholdKey, "0")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+// TestLoad_DeprecatedRoutes tests that deprecation notices are parsed and that notices
+// without a deprecation time, with an earlier sunset or with a relative link are rejected.
+func TestLoad_DeprecatedRoutes(t *testing.T) {
+	setRequiredEnvs(t)
+
+	t.Setenv(deprecatedRoutesKey, `{"/templates/v1": {"deprecated": "2026-06-01T00:00:00Z", "sunset": "2027-01-01T00:00:00Z", "link": "https://docs.example.com/v2"},
+		"/pdf/v1": {"deprecated": "2026-06-01T00:00:00Z"}}`)
+	cfg, err := Load()
+	assert.NoError(t, err)
+	deprecated := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, DeprecatedRoute{
+		Deprecated: deprecated,
+		Sunset:     time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		Link:       "https://docs.example.com/v2",
+	}, cfg.DeprecatedRoutes["/templates/v1"])
+	assert.Equal(t, DeprecatedRoute{Deprecated: deprecated}, cfg.DeprecatedRoutes["/pdf/v1"])
+
+	for _, invalid := range []string{
+		`{"templates/v1": {"deprecated": "2026-06-01T00:00:00Z"}}`,
+		`{"/templates/v1": {"sunset": "2027-01-01T00:00:00Z"}}`,
+		`{"/templates/v1": {"deprecated": "2026-06-01T00:00:00Z", "sunset": "2026-01-01T00:00:00Z"}}`,
+		`{"/templates/v1": {"deprecated": "2026-06-01T00:00:00Z", "link": "/docs/v2"}}`,
+		`{"/templates/v1": {"deprecated": "June 2026"}}`,
+	} {
+		t.Setenv(deprecatedRoutesKey, invalid)
+		_, err = Load()
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestLoad_UserAgentPatterns tests that user agent patterns are combined into a single
+// case-insensitive pattern and that invalid patterns are rejected.
+func TestLoad_UserAgentPatterns(t *testing.T) {
+	setRequiredEnvs(t)
+	t.Setenv(blockedUAKey, "scraper, bot/\\d+")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Nil(t, cfg.AllowedUserAgents)
+	if assert.NotNil(t, cfg.BlockedUserAgents) {
+		assert.True(t, cfg.BlockedUserAgents.MatchString("Big SCRAPER"))
+		assert.True(t, cfg.BlockedUserAgents.MatchString("bot/2"))
+		assert.False(t, cfg.BlockedUserAgents.MatchString("Mozilla/5.0"))
+	}
+
+	t.Setenv(blockedUAKey, "bad(")
+	_, err = Load()
+	assert.Error(t, err)
+}
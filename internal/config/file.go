@@ -0,0 +1,94 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileKey is the environment variable key for the path of the optional YAML configuration file.
+const configFileKey = "CONFIG_FILE"
+
+// fileValues holds the values of the configuration file by environment variable key.
+// It is set by Load before any other value is read, and is nil when no file is used.
+var fileValues map[string]string
+
+// readConfigFile reads the YAML configuration file at path. The file is a mapping of
+// environment variable keys to values, so every setting can be moved between the file and
+// the environment unchanged:
+//
+//	PORT: ":8080"
+//	AUTH_SERVICE_URL: http://auth:8080
+//	ALLOWED_HOSTS: [api.example.com, "*.example.com"]
+//	STATIC_ROUTES:
+//	  /config.json: {body: {apiUrl: /api}}
+//
+// Scalars are taken as written, lists of scalars are joined with commas and mappings are
+// encoded as JSON, matching the formats of the corresponding environment variables.
+//
+// Parameters:
+//   - path: The path of the configuration file; empty when no file is used.
+//
+// Returns:
+//   - map[string]string: The values of the file by environment variable key, or nil without a file.
+//   - error: An error if the file cannot be read or is not a mapping of keys to values.
+func readConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", configFileKey, err)
+	}
+
+	var nodes map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("invalid %s %s: %w", configFileKey, path, err)
+	}
+
+	values := make(map[string]string, len(nodes))
+	for key, node := range nodes {
+		value, err := nodeValue(&node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s: %s: %w", configFileKey, path, key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// nodeValue returns the value of node in the format of an environment variable.
+func nodeValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("line %d: list items must be scalars", item.Line)
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	case yaml.MappingNode:
+		var v any
+		if err := node.Decode(&v); err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("line %d: unsupported value", node.Line)
+	}
+}
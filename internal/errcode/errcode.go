@@ -0,0 +1,83 @@
+// Package errcode defines the machine-readable codes of errors generated by the gateway.
+// Every gateway error response has the shape {"error": "<message>", "code": "<CODE>"}.
+// Codes are part of the public API: they may be added, but never renamed or removed.
+// Responses passed through from upstreams are not rewritten and carry no code.
+package errcode
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes of gateway-generated responses.
+const (
+	BadRequest          = "BAD_REQUEST"          // 400: The request is malformed.
+	InvalidHost         = "INVALID_HOST"         // 400: The Host header is missing or not allowed.
+	UpgradeNotAllowed   = "UPGRADE_NOT_ALLOWED"  // 400: The requested protocol upgrade is not permitted on the route.
+	NonceRequired       = "NONCE_REQUIRED"       // 400: X-Nonce or X-Timestamp is missing or malformed.
+	AuthRequired        = "AUTH_REQUIRED"        // 401: No access token was sent.
+	TokenInvalid        = "TOKEN_INVALID"        // 401: The access token is malformed or its signature does not verify.
+	TokenExpired        = "TOKEN_EXPIRED"        // 401: The access token has expired.
+	TokenRevoked        = "TOKEN_REVOKED"        // 401: The access token has been revoked.
+	StaleRequest        = "STALE_REQUEST"        // 401: X-Timestamp is outside the accepted window.
+	ReplayedRequest     = "REPLAYED_REQUEST"     // 401: X-Nonce has been used before.
+	Forbidden           = "FORBIDDEN"            // 403: The client is not allowed to make the request.
+	RouteNotFound       = "ROUTE_NOT_FOUND"      // 404: No gateway route matches the request.
+	RateLimited         = "RATE_LIMITED"         // 429: The client exceeded its rate limit.
+	ConcurrencyLimited  = "CONCURRENCY_LIMITED"  // 429: The client has too many requests in flight.
+	HeadersTooLarge     = "HEADERS_TOO_LARGE"    // 431: The request headers exceed the upstream limit.
+	InternalError       = "INTERNAL_ERROR"       // 500: The gateway failed unexpectedly.
+	UpstreamUnavailable = "UPSTREAM_UNAVAILABLE" // 502: The upstream could not be reached or returned an invalid response.
+	Unavailable         = "UNAVAILABLE"          // 503: The gateway cannot serve the request right now.
+	UpstreamTimeout     = "UPSTREAM_TIMEOUT"     // 504: The upstream did not respond in time.
+)
+
+// JSON sends a gateway error response. Errors are never cached, so that caches and browsers
+// do not keep serving them once the cause is gone.
+//
+// Parameters:
+//   - c: The request context.
+//   - status: The HTTP status code.
+//   - code: One of the error codes.
+//   - message: The human-readable error message.
+//
+// Returns:
+//   - error: The error of writing the response.
+func JSON(c *fiber.Ctx, status int, code, message string) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(status).JSON(fiber.Map{
+		"error": message,
+		"code":  code,
+	})
+}
+
+// FromStatus returns the generic code of a status, for errors that have no specific code.
+//
+// Parameters:
+//   - status: The HTTP status code.
+//
+// Returns:
+//   - string: The error code.
+func FromStatus(status int) string {
+	switch status {
+	case fiber.StatusUnauthorized:
+		return AuthRequired
+	case fiber.StatusForbidden:
+		return Forbidden
+	case fiber.StatusNotFound:
+		return RouteNotFound
+	case fiber.StatusTooManyRequests:
+		return RateLimited
+	case fiber.StatusRequestHeaderFieldsTooLarge:
+		return HeadersTooLarge
+	case fiber.StatusBadGateway:
+		return UpstreamUnavailable
+	case fiber.StatusServiceUnavailable:
+		return Unavailable
+	case fiber.StatusGatewayTimeout:
+		return UpstreamTimeout
+	}
+	if status >= 400 && status < 500 {
+		return BadRequest
+	}
+	return InternalError
+}
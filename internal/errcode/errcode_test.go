@@ -0,0 +1,49 @@
+package errcode
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestJSON tests that errors are rendered with the status, message and code, and are not cached.
+func TestJSON(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return JSON(c, fiber.StatusTooManyRequests, RateLimited, "too many requests")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get(fiber.HeaderCacheControl))
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"error":"too many requests","code":"RATE_LIMITED"}`, string(body))
+}
+
+// TestFromStatus tests the generic codes of statuses.
+func TestFromStatus(t *testing.T) {
+	tests := []struct {
+		status int    // The HTTP status code.
+		want   string // Expected error code.
+	}{
+		{status: fiber.StatusBadRequest, want: BadRequest},
+		{status: fiber.StatusMethodNotAllowed, want: BadRequest},
+		{status: fiber.StatusUnauthorized, want: AuthRequired},
+		{status: fiber.StatusForbidden, want: Forbidden},
+		{status: fiber.StatusNotFound, want: RouteNotFound},
+		{status: fiber.StatusTooManyRequests, want: RateLimited},
+		{status: fiber.StatusInternalServerError, want: InternalError},
+		{status: fiber.StatusBadGateway, want: UpstreamUnavailable},
+		{status: fiber.StatusServiceUnavailable, want: Unavailable},
+		{status: fiber.StatusGatewayTimeout, want: UpstreamTimeout},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, FromStatus(tt.status), "status %d", tt.status)
+	}
+}
@@ -0,0 +1,151 @@
+// Package metrics provides the Prometheus collectors of the gateway.
+// Collectors are registered on an injectable registry so tests can scrape them in isolation.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Upstream holds the collectors describing responses received from upstream services.
+// A nil *Upstream is valid and records nothing.
+type Upstream struct {
+	responses *prometheus.CounterVec
+	truncated *prometheus.CounterVec
+	oversized *prometheus.CounterVec
+}
+
+// NewUpstream creates the upstream collectors and registers them on reg.
+//
+// Parameters:
+//   - reg: The registry the collectors are registered on.
+//
+// Returns:
+//   - *Upstream: The registered collectors.
+func NewUpstream(reg prometheus.Registerer) *Upstream {
+	u := &Upstream{
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upstream_responses_total",
+			Help: "Responses received from upstream services by service and status class.",
+		}, []string{"service", "status_class"}),
+		truncated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upstream_truncated_responses_total",
+			Help: "Upstream responses whose body ended prematurely, by service.",
+		}, []string{"service"}),
+		oversized: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upstream_oversized_responses_total",
+			Help: "Upstream responses cut off for exceeding the maximum response size, by service.",
+		}, []string{"service"}),
+	}
+	reg.MustRegister(u.responses, u.truncated, u.oversized)
+	return u
+}
+
+// ObserveResponse counts a response with the given status code received from service.
+//
+// Parameters:
+//   - service: The name of the upstream service.
+//   - status: The status code returned by the upstream.
+func (u *Upstream) ObserveResponse(service string, status int) {
+	if u == nil {
+		return
+	}
+	u.responses.WithLabelValues(service, StatusClass(status)).Inc()
+}
+
+// ObserveTruncated counts a response from service whose body ended prematurely.
+//
+// Parameters:
+//   - service: The name of the upstream service.
+func (u *Upstream) ObserveTruncated(service string) {
+	if u == nil {
+		return
+	}
+	u.truncated.WithLabelValues(service).Inc()
+}
+
+// ObserveOversized counts a response from service that exceeded the maximum response size.
+//
+// Parameters:
+//   - service: The name of the upstream service.
+func (u *Upstream) ObserveOversized(service string) {
+	if u == nil {
+		return
+	}
+	u.oversized.WithLabelValues(service).Inc()
+}
+
+// HTTP holds the collectors describing the requests served by the gateway.
+// A nil *HTTP is valid and records nothing.
+type HTTP struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// NewHTTP creates the request collectors and registers them on reg.
+//
+// Parameters:
+//   - reg: The registry the collectors are registered on.
+//
+// Returns:
+//   - *HTTP: The registered collectors.
+func NewHTTP(reg prometheus.Registerer) *HTTP {
+	h := &HTTP{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Requests served by the gateway, by method, matched route and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to serve requests, by method and matched route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Requests currently being served by the gateway.",
+		}),
+	}
+	reg.MustRegister(h.requests, h.duration, h.inFlight)
+	return h
+}
+
+// Started counts a request that is now being served. It must be followed by a call to Finished.
+func (h *HTTP) Started() {
+	if h == nil {
+		return
+	}
+	h.inFlight.Inc()
+}
+
+// Finished records a request that has been served.
+//
+// Parameters:
+//   - method: The request method.
+//   - route: The path of the route that matched the request, e.g. "/templates/:id".
+//   - status: The status code of the response.
+//   - duration: The time taken to serve the request.
+func (h *HTTP) Finished(method, route string, status int, duration time.Duration) {
+	if h == nil {
+		return
+	}
+	h.inFlight.Dec()
+	h.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	h.duration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// StatusClass returns the class of a status code, e.g. "5xx" for 503.
+//
+// Parameters:
+//   - status: The status code.
+//
+// Returns:
+//   - string: The status class, or "unknown" for codes outside 100-599.
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUpstream_ObserveResponse tests that responses are counted by service and status class.
+func TestUpstream_ObserveResponse(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	u := NewUpstream(reg)
+
+	u.ObserveResponse("auth", 200)
+	u.ObserveResponse("auth", 204)
+	u.ObserveResponse("auth", 503)
+	u.ObserveResponse("pdf", 404)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(u.responses.WithLabelValues("auth", "2xx")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(u.responses.WithLabelValues("auth", "5xx")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(u.responses.WithLabelValues("pdf", "4xx")))
+
+	u.ObserveTruncated("pdf")
+	assert.Equal(t, 1.0, testutil.ToFloat64(u.truncated.WithLabelValues("pdf")))
+
+	u.ObserveOversized("templates")
+	assert.Equal(t, 1.0, testutil.ToFloat64(u.oversized.WithLabelValues("templates")))
+
+	// A nil collector set is a no-op.
+	var none *Upstream
+	none.ObserveResponse("auth", 200)
+	none.ObserveTruncated("auth")
+	none.ObserveOversized("auth")
+}
+
+// TestHTTP_Finished tests that requests are counted by method, route and status, that their
+// durations are observed, and that the in-flight gauge follows started and finished requests.
+func TestHTTP_Finished(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := NewHTTP(reg)
+
+	h.Started()
+	h.Started()
+	assert.Equal(t, 2.0, testutil.ToFloat64(h.inFlight))
+
+	h.Finished("GET", "/templates/:id", 200, 20*time.Millisecond)
+	h.Finished("GET", "/templates/:id", 404, 10*time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(h.inFlight))
+	assert.Equal(t, 1.0, testutil.ToFloat64(h.requests.WithLabelValues("GET", "/templates/:id", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(h.requests.WithLabelValues("GET", "/templates/:id", "404")))
+	assert.Equal(t, 1, testutil.CollectAndCount(h.duration))
+
+	// A nil collector set is a no-op.
+	var none *HTTP
+	none.Started()
+	none.Finished("GET", "/", 200, time.Millisecond)
+}
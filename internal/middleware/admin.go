@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireAdminToken is a middleware that guards the admin endpoints. Requests must send the
+// token as "Authorization: Bearer <token>"; others are rejected with 401.
+//
+// Parameters:
+//   - token: The admin token.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RequireAdminToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sent, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			return errcode.JSON(c, fiber.StatusUnauthorized, errcode.AuthRequired, "admin token required")
+		}
+		return c.Next()
+	}
+}
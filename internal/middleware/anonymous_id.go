@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// AnonIDCookie is the name of the cookie holding the anonymous id.
+const AnonIDCookie = "anon_id"
+
+// AnonIDConfig holds the attributes of the anonymous id cookie.
+type AnonIDConfig struct {
+	TTL      time.Duration // Lifetime of the cookie.
+	Domain   string        // Domain attribute of the cookie (empty for host-only).
+	SameSite string        // SameSite attribute of the cookie.
+	Secure   bool          // Secure attribute of the cookie.
+}
+
+// AnonymousID is a middleware that gives every client a stable anonymous id.
+// It reuses the id from the anon_id cookie or issues a new UUID cookie, stores it in
+// the context under "anon_id" and forwards it to upstreams as X-Anon-ID.
+// The anonymous id is sent in addition to X-User-ID for authenticated requests.
+//
+// Parameters:
+//   - cfg: The attributes of the issued cookie.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func AnonymousID(cfg AnonIDConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		anonID := c.Cookies(AnonIDCookie)
+		if _, err := uuid.Parse(anonID); err != nil {
+			anonID = uuid.NewString()
+			c.Cookie(&fiber.Cookie{
+				Name:     AnonIDCookie,
+				Value:    anonID,
+				Expires:  time.Now().Add(cfg.TTL),
+				Domain:   cfg.Domain,
+				Path:     "/",
+				Secure:   cfg.Secure,
+				HTTPOnly: true,
+				SameSite: cfg.SameSite,
+			})
+		}
+
+		c.Locals("anon_id", anonID)
+
+		// Overwrite any client supplied value so upstreams can trust the header.
+		c.Request().Header.Set("X-Anon-ID", anonID)
+
+		return c.Next()
+	}
+}
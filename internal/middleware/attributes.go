@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// AttributesKey is the c.Locals key of the map of request attributes set by Enrich.
+const AttributesKey = "attributes"
+
+// Enrich is a middleware that extracts request attributes once, so later middleware
+// reads them with Attribute instead of parsing the request again. Each attribute is
+// named and has a source:
+//   - "header:<name>": The value of a request header.
+//   - "cookie:<name>": The value of a cookie.
+//   - "query:<name>": The value of a query parameter.
+//   - "host": The Host header without port.
+//   - "ip": The client IP.
+//
+// Attributes with unknown sources or empty values are not set.
+//
+// Parameters:
+//   - attributes: The sources of the attributes by name (e.g. "tenant": "header:X-Tenant-ID").
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func Enrich(attributes map[string]string) fiber.Handler {
+	extractors := make(map[string]func(*fiber.Ctx) string, len(attributes))
+	for name, source := range attributes {
+		if extract := attributeExtractor(source); extract != nil {
+			extractors[name] = extract
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		values := make(map[string]string, len(extractors))
+		for name, extract := range extractors {
+			if v := extract(c); v != "" {
+				// Fasthttp reuses its buffers, so the values must not alias them.
+				values[name] = utils.CopyString(v)
+			}
+		}
+		c.Locals(AttributesKey, values)
+		return c.Next()
+	}
+}
+
+// Attribute returns the value of the named request attribute set by Enrich, or "" if it is not set.
+//
+// Parameters:
+//   - c: The request context.
+//   - name: The name of the attribute.
+//
+// Returns:
+//   - string: The value of the attribute.
+func Attribute(c *fiber.Ctx, name string) string {
+	values, _ := c.Locals(AttributesKey).(map[string]string)
+	return values[name]
+}
+
+// attributeExtractor returns the function reading an attribute from source, or nil if source is unknown.
+func attributeExtractor(source string) func(*fiber.Ctx) string {
+	kind, arg, _ := strings.Cut(source, ":")
+	switch kind {
+	case "header":
+		return func(c *fiber.Ctx) string { return c.Get(arg) }
+	case "cookie":
+		return func(c *fiber.Ctx) string { return c.Cookies(arg) }
+	case "query":
+		return func(c *fiber.Ctx) string { return c.Query(arg) }
+	case "host":
+		return func(c *fiber.Ctx) string {
+			host := string(c.Request().Host())
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return host
+		}
+	case "ip":
+		return func(c *fiber.Ctx) string { return c.IP() }
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"github.com/dashboard-platform/api-gateway/internal/audit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// AuditSink is an interface that defines a method for emitting audit events.
+type AuditSink interface {
+	Emit(e audit.Event)
+}
+
+// AuditAuthFailures is a middleware that emits an audit event for every request answered
+// with 401 (auth failure) or 403 (access denied), whether by the gateway or an upstream.
+// Only the path is recorded, never the query string or credentials.
+//
+// Parameters:
+//   - sink: The destination of the audit events.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func AuditAuthFailures(sink AuditSink) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+
+		var eventType string
+		switch status {
+		case fiber.StatusUnauthorized:
+			eventType = audit.TypeAuthFailure
+		case fiber.StatusForbidden:
+			eventType = audit.TypeAccessDenied
+		default:
+			return err
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		sink.Emit(audit.Event{
+			Type:   eventType,
+			Method: c.Method(),
+			Path:   c.Path(),
+			IP:     c.IP(),
+			Status: status,
+			UserID: userID,
+			Details: map[string]string{
+				"user_agent": c.Get(fiber.HeaderUserAgent),
+			},
+		})
+		return err
+	}
+}
+
+// auditAdminAction emits an admin_action audit event for the admin request c, naming the
+// action taken and its details. A nil sink discards the event.
+func auditAdminAction(c *fiber.Ctx, sink AuditSink, action string, details map[string]string) {
+	if sink == nil {
+		return
+	}
+	all := map[string]string{"action": action}
+	for k, v := range details {
+		all[k] = v
+	}
+	// Copied as fasthttp reuses its buffers, while the sink delivers the event later.
+	sink.Emit(audit.Event{
+		Type:    audit.TypeAdminAction,
+		Method:  c.Method(),
+		Path:    utils.CopyString(c.Path()),
+		IP:      utils.CopyString(c.IP()),
+		Status:  c.Response().StatusCode(),
+		Details: all,
+	})
+}
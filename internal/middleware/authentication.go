@@ -3,18 +3,41 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
 )
 
+// Token sources supported by RequireAuthFrom.
+const (
+	TokenSourceCookieFirst = "cookie_first" // Use the cookie when present, otherwise the Authorization header.
+	TokenSourceHeaderFirst = "header_first" // Use the Authorization header when present, otherwise the cookie.
+	TokenSourceCookieOnly  = "cookie_only"  // Only accept the cookie.
+	TokenSourceHeaderOnly  = "header_only"  // Only accept the Authorization header.
+	TokenSourceAny         = "any"          // Try the cookie, then fall through to the header if it is invalid.
+)
+
+// DefaultUserIDHeader is the header the authenticated user id is forwarded in by default.
+const DefaultUserIDHeader = "X-User-ID"
+
 // JWTValidator is an interface that defines a method for validating JWT tokens.
 type JWTValidator interface {
 	ValidateJWT(token string) (string, error)
 }
 
+// ClaimsValidator is implemented by validators that can also return all claims of a valid
+// token. RequireAuth stores the claims of such validators for ForwardClaims.
+type ClaimsValidator interface {
+	JWTValidator
+	ValidateClaims(token string) (map[string]any, error)
+}
+
 // RequireAuth is a middleware that enforces authentication for protected routes.
 // It validates the JWT token from the request and sets the user ID in the context.
+// When both are sent, the access_token cookie takes precedence over the Authorization header.
 //
 // Parameters:
 //   - jwt: An implementation of the JWTValidator interface for token validation.
@@ -22,34 +45,147 @@ type JWTValidator interface {
 // Returns:
 //   - fiber.Handler: The middleware handler function.
 func RequireAuth(jwt JWTValidator) fiber.Handler {
+	return RequireAuthFrom(jwt, TokenSourceCookieFirst)
+}
+
+// RequireAuthFrom is like RequireAuth but reads the token from the given source.
+// Unknown sources fall back to TokenSourceCookieFirst.
+//
+// Parameters:
+//   - jwt: An implementation of the JWTValidator interface for token validation.
+//   - source: One of the TokenSource constants.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RequireAuthFrom(jwt JWTValidator, source string) fiber.Handler {
+	return RequireAuthWithHeader(jwt, source, DefaultUserIDHeader)
+}
+
+// RequireAuthWithHeader is like RequireAuthFrom but forwards the user id in the given header,
+// for upstreams that expect another name than X-User-ID. A client-sent X-User-ID is then
+// removed, so it cannot be mistaken for the authenticated user.
+//
+// Parameters:
+//   - jwt: An implementation of the JWTValidator interface for token validation.
+//   - source: One of the TokenSource constants.
+//   - header: The name of the header the user id is forwarded in.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RequireAuthWithHeader(jwt JWTValidator, source, header string) fiber.Handler {
+	return RequireAuthWithRevocation(jwt, source, header, nil)
+}
+
+// RequireAuthWithRevocation is like RequireAuthWithHeader but also rejects tokens whose jti
+// claim the checker reports as revoked. Tokens without a jti claim cannot be revoked. When
+// the checker fails, requests fail closed with 503. A nil checker disables the check.
+//
+// Parameters:
+//   - jwt: An implementation of the JWTValidator interface for token validation.
+//   - source: One of the TokenSource constants.
+//   - header: The name of the header the user id is forwarded in.
+//   - checker: Reports whether a token id was revoked (nil for none).
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RequireAuthWithRevocation(jwt JWTValidator, source, header string, checker RevocationChecker) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		token := c.Cookies("access_token")
-		if token == "" {
-			authHeader := c.Get("Authorization")
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				token = strings.TrimPrefix(authHeader, "Bearer ")
-			}
+		tokens := requestTokens(c, source)
+		if len(tokens) == 0 {
+			return errcode.JSON(c, fiber.StatusUnauthorized, errcode.AuthRequired, "authentication required")
 		}
 
-		if token == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "authentication required",
-			})
+		var (
+			userID string
+			claims map[string]any
+			err    error
+		)
+		for _, token := range tokens {
+			if userID, claims, err = validate(jwt, token); err == nil {
+				break
+			}
 		}
-
-		userID, err := jwt.ValidateJWT(token)
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "invalid or expired token",
-			})
+			code := errcode.TokenInvalid
+			if errors.Is(err, ErrTokenExpired) {
+				code = errcode.TokenExpired
+			}
+			return errcode.JSON(c, fiber.StatusUnauthorized, code, "invalid or expired token")
+		}
+
+		if tokenID, _ := claims["jti"].(string); checker != nil && tokenID != "" {
+			revoked, err := checker.IsRevoked(tokenID)
+			if err != nil {
+				log.Error().Err(err).Str("path", c.Path()).Msg("Failed to check token revocation")
+				return errcode.JSON(c, fiber.StatusServiceUnavailable, errcode.Unavailable, "cannot verify token")
+			}
+			if revoked {
+				return errcode.JSON(c, fiber.StatusUnauthorized, errcode.TokenRevoked, "token revoked")
+			}
 		}
 
 		// Inject user ID into context
 		c.Locals("user_id", userID)
+		if claims != nil {
+			c.Locals("claims", claims)
+		}
 
 		// Inject into forwarded headers
-		c.Request().Header.Set("X-User-ID", userID)
+		if header != DefaultUserIDHeader {
+			c.Request().Header.Del(DefaultUserIDHeader)
+		}
+		c.Request().Header.Set(header, userID)
 
 		return c.Next()
 	}
 }
+
+// validate validates token with v and returns its subject, and its claims when v is a
+// ClaimsValidator.
+func validate(v JWTValidator, token string) (string, map[string]any, error) {
+	cv, ok := v.(ClaimsValidator)
+	if !ok {
+		userID, err := v.ValidateJWT(token)
+		return userID, nil, err
+	}
+	claims, err := cv.ValidateClaims(token)
+	if err != nil {
+		return "", nil, err
+	}
+	userID, _ := claims["sub"].(string)
+	return userID, claims, nil
+}
+
+// requestTokens returns the tokens of the request to validate, in order, according to source.
+func requestTokens(c *fiber.Ctx, source string) []string {
+	cookie := c.Cookies("access_token")
+	header := ""
+	if authHeader := c.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		header = strings.TrimPrefix(authHeader, "Bearer ")
+	}
+
+	var candidates []string
+	switch source {
+	case TokenSourceHeaderFirst:
+		candidates = []string{header, cookie}
+	case TokenSourceCookieOnly:
+		candidates = []string{cookie}
+	case TokenSourceHeaderOnly:
+		candidates = []string{header}
+	default: // TokenSourceCookieFirst and TokenSourceAny
+		candidates = []string{cookie, header}
+	}
+
+	var tokens []string
+	for _, token := range candidates {
+		if token == "" {
+			continue
+		}
+		tokens = append(tokens, token)
+		// Only TokenSourceAny falls through to the next token when the first one is invalid.
+		if source != TokenSourceAny {
+			break
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// ForwardClaims is a middleware that forwards claims of the authenticated user's token to
+// upstreams in request headers, e.g. the email claim in X-User-Email. Strings are forwarded
+// as is, numbers and booleans in their JSON form, and lists of those joined with commas.
+// Claims that are missing, objects, or would not be a single line are not forwarded.
+// It must run after RequireAuth; client supplied headers of the mapping are always dropped.
+//
+// Parameters:
+//   - headers: The header each claim is forwarded in, by claim name.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func ForwardClaims(headers map[string]string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, header := range headers {
+			c.Request().Header.Del(header)
+		}
+
+		claims, _ := c.Locals("claims").(map[string]any)
+		for claim, header := range headers {
+			v, ok := claims[claim]
+			if !ok {
+				continue
+			}
+			value, ok := claimValue(v)
+			if !ok {
+				log.Warn().Str("claim", claim).Str("path", c.Path()).Msg("Claim not forwarded, it is not a single-line value")
+				continue
+			}
+			c.Request().Header.Set(header, value)
+		}
+
+		return c.Next()
+	}
+}
+
+// claimValue returns the header value of a claim, and false when the claim is not a
+// scalar or list of scalars, or contains control characters that could inject headers.
+func claimValue(v any) (string, bool) {
+	var value string
+	switch v := v.(type) {
+	case string:
+		value = v
+	case float64, bool, json.Number:
+		data, _ := json.Marshal(v)
+		value = string(data)
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.([]any); ok {
+				return "", false
+			}
+			s, ok := claimValue(item)
+			if !ok {
+				return "", false
+			}
+			items = append(items, s)
+		}
+		value = strings.Join(items, ",")
+	default:
+		return "", false
+	}
+
+	if strings.ContainsFunc(value, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return "", false
+	}
+	return value, true
+}
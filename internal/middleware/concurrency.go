@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ConcurrencyLimiter caps the number of requests each client has in flight at once,
+// independently of the request rate. Authenticated clients are counted per user id and
+// anonymous clients per IP, unless the limiter was created by NewIPConcurrencyLimiter.
+// One limiter may be shared by several routes.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	max      int
+	inFlight map[string]int
+	key      func(c *fiber.Ctx) string
+}
+
+// ClientInFlight is the number of requests a client has in flight.
+type ClientInFlight struct {
+	Client   string `json:"client"`    // The client, "user:<id>" or "ip:<address>".
+	InFlight int    `json:"in_flight"` // The number of requests in flight.
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter allowing max requests in flight per client.
+//
+// Parameters:
+//   - max: The maximum number of concurrent requests of a client.
+//
+// Returns:
+//   - *ConcurrencyLimiter: The limiter.
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		max:      max,
+		inFlight: make(map[string]int),
+		key:      clientKey,
+	}
+}
+
+// NewIPConcurrencyLimiter returns a ConcurrencyLimiter allowing max requests in flight per
+// client IP, whether or not the client is authenticated. The IP is the one reported by
+// fiber, i.e. taken from the proxy header only for requests of trusted proxies.
+//
+// Parameters:
+//   - max: The maximum number of concurrent requests of an IP.
+//
+// Returns:
+//   - *ConcurrencyLimiter: The limiter.
+func NewIPConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		max:      max,
+		inFlight: make(map[string]int),
+		key:      func(c *fiber.Ctx) string { return "ip:" + c.IP() },
+	}
+}
+
+// Handler returns a middleware that rejects requests of clients that already have the
+// maximum number of requests in flight with 429. Unless counting per IP, it must run after
+// RequireAuth for requests to be counted per user. A request stops counting when the handlers after it have returned,
+// even if they panicked; event streams keep being sent after that.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func (l *ConcurrencyLimiter) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := l.key(c)
+		if !l.acquire(key) {
+			return errcode.JSON(c, fiber.StatusTooManyRequests, errcode.ConcurrencyLimited, "too many concurrent requests")
+		}
+		defer l.release(key)
+
+		return c.Next()
+	}
+}
+
+// InFlight returns the number of requests in flight by client, keyed "user:<id>" or "ip:<address>".
+//
+// Returns:
+//   - map[string]int: The clients with requests in flight.
+func (l *ConcurrencyLimiter) InFlight() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inFlight := make(map[string]int, len(l.inFlight))
+	for key, n := range l.inFlight {
+		inFlight[key] = n
+	}
+	return inFlight
+}
+
+// Top returns the n clients with the most requests in flight, most first.
+//
+// Parameters:
+//   - n: The maximum number of clients returned.
+//
+// Returns:
+//   - []ClientInFlight: The clients with the most requests in flight.
+func (l *ConcurrencyLimiter) Top(n int) []ClientInFlight {
+	l.mu.Lock()
+	top := make([]ClientInFlight, 0, len(l.inFlight))
+	for key, inFlight := range l.inFlight {
+		top = append(top, ClientInFlight{Client: key, InFlight: inFlight})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].InFlight != top[j].InFlight {
+			return top[i].InFlight > top[j].InFlight
+		}
+		return top[i].Client < top[j].Client
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// clientKey returns the key of the user of the request, or of its IP when it is anonymous.
+func clientKey(c *fiber.Ctx) string {
+	if userID, ok := c.Locals("user_id").(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + c.IP()
+}
+
+// acquire counts a request of key in flight and reports whether it is within the limit.
+func (l *ConcurrencyLimiter) acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[key] >= l.max {
+		return false
+	}
+	l.inFlight[key]++
+	return true
+}
+
+// release counts a request of key as done. Keys without requests in flight are dropped.
+func (l *ConcurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[key]--; l.inFlight[key] <= 0 {
+		delete(l.inFlight, key)
+	}
+}
+
+// ErrDependencyBusy is returned by DependencyLimiter.Acquire when no call slot frees up in time.
+var ErrDependencyBusy = errors.New("too many concurrent dependency calls")
+
+// DependencyLimiter bounds the number of concurrent calls the gateway makes to the
+// dependencies of its middleware, such as JWKS fetches, so that a burst of requests after
+// a cache expiry cannot open hundreds of connections. One limiter may be shared by several
+// dependencies; a nil limiter does not limit calls.
+type DependencyLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// NewDependencyLimiter returns a DependencyLimiter allowing max concurrent calls. Calls
+// beyond the bound wait up to wait for a slot and then fail with ErrDependencyBusy.
+//
+// Parameters:
+//   - max: The maximum number of concurrent dependency calls.
+//   - wait: The maximum time a call waits for a slot.
+//
+// Returns:
+//   - *DependencyLimiter: The limiter.
+func NewDependencyLimiter(max int, wait time.Duration) *DependencyLimiter {
+	return &DependencyLimiter{slots: make(chan struct{}, max), wait: wait}
+}
+
+// Acquire takes a call slot, waiting up to the limiter's wait for one to free up.
+// The returned function releases the slot and must be called once the call is done.
+//
+// Parameters:
+//   - ctx: The context of the call; waiting stops when it is done.
+//
+// Returns:
+//   - func(): Releases the slot.
+//   - error: ErrDependencyBusy if no slot freed up in time, or the error of ctx.
+func (l *DependencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrDependencyBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DegradedMode holds whether the service is announced as degraded, e.g. during an incident.
+// It is kept in memory, so it must be set on every gateway instance.
+type DegradedMode struct {
+	mu      sync.RWMutex
+	enabled bool
+	message string
+}
+
+// degradedStatus is the JSON representation of a DegradedMode.
+type degradedStatus struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// Set turns degraded mode on or off with an optional message for clients.
+func (d *DegradedMode) Set(enabled bool, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.enabled = enabled
+	d.message = message
+	if !enabled {
+		d.message = ""
+	}
+}
+
+// Status returns whether degraded mode is on and its message.
+func (d *DegradedMode) Status() (enabled bool, message string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.enabled, d.message
+}
+
+// Handler returns a middleware that adds an X-Service-Status: degraded header, and an
+// X-Service-Status-Message header when a message is set, to every response while degraded
+// mode is on. Responses are otherwise unchanged.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func (d *DegradedMode) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		if enabled, message := d.Status(); enabled {
+			c.Set("X-Service-Status", "degraded")
+			if message != "" {
+				c.Set("X-Service-Status-Message", message)
+			}
+		}
+		return err
+	}
+}
+
+// AdminHandler returns the admin endpoint of degraded mode. GET returns the current state
+// and PUT replaces it with a JSON body like {"enabled": true, "message": "PDF exports are delayed"},
+// emitting an audit event with the new state.
+//
+// Parameters:
+//   - sink: The destination of the audit events (nil disables them).
+//
+// Returns:
+//   - fiber.Handler: The handler function.
+func (d *DegradedMode) AdminHandler(sink AuditSink) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodPut {
+			var status degradedStatus
+			if err := c.BodyParser(&status); err != nil || !headerSafe(status.Message) {
+				return errcode.JSON(c, fiber.StatusBadRequest, errcode.BadRequest, "invalid degraded mode")
+			}
+			d.Set(status.Enabled, status.Message)
+		}
+
+		enabled, message := d.Status()
+		if err := c.JSON(degradedStatus{Enabled: enabled, Message: message}); err != nil {
+			return err
+		}
+		if c.Method() == fiber.MethodPut {
+			auditAdminAction(c, sink, "degraded_mode", map[string]string{
+				"enabled": strconv.FormatBool(enabled),
+				"message": message,
+			})
+		}
+		return nil
+	}
+}
+
+// headerSafe reports whether s can be sent as a header value: printable ASCII only.
+func headerSafe(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeprecationNotice describes the deprecation of the routes below a path prefix.
+type DeprecationNotice struct {
+	Deprecated time.Time // When the routes were deprecated.
+	Sunset     time.Time // When the routes stop being served (zero if not planned yet).
+	Link       string    // URL of the migration docs (empty for none).
+}
+
+// Deprecation is a middleware that announces deprecated routes (RFC 9745, RFC 8594). Responses
+// to paths below a prefix in notices get a Deprecation header, a Sunset header when the
+// sunset is planned, and a Link header with rel="deprecation" pointing to the migration docs.
+// The longest matching prefix wins. Like GatewayTime, it should be registered early so that
+// error responses carry the headers too.
+//
+// Parameters:
+//   - notices: The deprecation notices by path prefix.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func Deprecation(notices map[string]DeprecationNotice) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		notice, ok := deprecationNotice(notices, c.Path())
+		if !ok {
+			return err
+		}
+		c.Set("Deprecation", "@"+strconv.FormatInt(notice.Deprecated.Unix(), 10))
+		if !notice.Sunset.IsZero() {
+			c.Set("Sunset", notice.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if notice.Link != "" {
+			// Appended, as upstreams may send links of their own.
+			c.Append(fiber.HeaderLink, "<"+notice.Link+`>; rel="deprecation"; type="text/html"`)
+		}
+
+		return err
+	}
+}
+
+// deprecationNotice returns the notice of the longest prefix of notices that path is below.
+func deprecationNotice(notices map[string]DeprecationNotice, path string) (DeprecationNotice, bool) {
+	var notice DeprecationNotice
+	matched := ""
+	for prefix, n := range notices {
+		if len(prefix) > len(matched) && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			notice, matched = n, prefix
+		}
+	}
+	return notice, matched != ""
+}
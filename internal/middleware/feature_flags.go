@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// maxFlagsSize bounds the size of a flags service response.
+const maxFlagsSize = 4 << 10
+
+// flagsEntry is a cached flags lookup for a single user.
+type flagsEntry struct {
+	value   string    // Compact JSON flags, empty when the lookup failed.
+	expires time.Time // Time after which the entry is refreshed.
+}
+
+// FeatureFlags is a middleware that forwards the authenticated user's feature flags to
+// upstreams as a compact JSON X-Feature-Flags header. Flags are fetched from url with the
+// user id in the X-User-ID header and cached per user for ttl. Lookups fail open: when the
+// flags service is slow or down the request is forwarded without the header.
+// It must run after RequireAuth; a client supplied X-Feature-Flags header is always dropped.
+//
+// Parameters:
+//   - url: The endpoint of the flags service.
+//   - ttl: How long the flags of a user are cached.
+//   - timeout: The maximum time spent waiting for the flags service.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func FeatureFlags(url string, ttl, timeout time.Duration) fiber.Handler {
+	var (
+		mu     sync.Mutex
+		cache  = make(map[string]flagsEntry)
+		client = &http.Client{Timeout: timeout}
+	)
+
+	return func(c *fiber.Ctx) error {
+		c.Request().Header.Del("X-Feature-Flags")
+
+		userID, _ := c.Locals("user_id").(string)
+		if userID == "" {
+			return c.Next()
+		}
+
+		now := time.Now()
+		mu.Lock()
+		entry, ok := cache[userID]
+		if ok && now.After(entry.expires) {
+			ok = false
+		}
+		// Drop expired entries once the cache has grown, so it stays bounded by active users.
+		if !ok && len(cache) > 10000 {
+			for k, e := range cache {
+				if now.After(e.expires) {
+					delete(cache, k)
+				}
+			}
+		}
+		mu.Unlock()
+
+		if !ok {
+			value, err := fetchFlags(c.UserContext(), client, url, userID)
+			if err != nil {
+				log.Warn().Err(err).Str("user_id", userID).Msg("Failed to fetch feature flags")
+			}
+			// Failures are cached as well so an outage does not add latency to every request.
+			entry = flagsEntry{value: value, expires: now.Add(ttl)}
+			mu.Lock()
+			cache[userID] = entry
+			mu.Unlock()
+		}
+
+		if entry.value != "" {
+			c.Request().Header.Set("X-Feature-Flags", entry.value)
+		}
+
+		return c.Next()
+	}
+}
+
+// fetchFlags requests the flags of userID from the flags service and returns them as compact JSON.
+func fetchFlags(ctx context.Context, client *http.Client, url, userID string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-User-ID", userID)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("flags service returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFlagsSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxFlagsSize {
+		return "", errors.New("flags response too large")
+	}
+
+	// Compacting validates the JSON and guarantees a single-line header value.
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Formats accepted by GatewayTime.
+const (
+	GatewayTimeRFC3339 = "rfc3339"  // RFC 3339 timestamp in UTC with millisecond precision.
+	GatewayTimeEpochMS = "epoch_ms" // Milliseconds since the Unix epoch.
+)
+
+// GatewayTime is a middleware that adds an X-Gateway-Time header carrying the gateway's
+// clock at response time, giving clients an authoritative time reference.
+// It should be registered first so that every response, including errors, carries the header.
+//
+// Parameters:
+//   - format: Either GatewayTimeRFC3339 or GatewayTimeEpochMS.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func GatewayTime(format string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		now := time.Now().UTC()
+		if format == GatewayTimeEpochMS {
+			c.Set("X-Gateway-Time", strconv.FormatInt(now.UnixMilli(), 10))
+		} else {
+			c.Set("X-Gateway-Time", now.Format("2006-01-02T15:04:05.000Z07:00"))
+		}
+
+		return err
+	}
+}
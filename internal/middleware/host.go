@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// HostAllowlist is a middleware that rejects requests whose Host header is missing or not
+// in hosts with 400, guarding against Host header cache poisoning and routing attacks.
+// Hosts are matched case-insensitively and without port. An entry "*.example.com"
+// matches any subdomain of example.com but not example.com itself. An empty list allows all hosts.
+//
+// Parameters:
+//   - hosts: The allowed Host values.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func HostAllowlist(hosts []string) fiber.Handler {
+	allowed := make([]string, len(hosts))
+	for i, h := range hosts {
+		allowed[i] = strings.ToLower(h)
+	}
+
+	return func(c *fiber.Ctx) error {
+		if len(allowed) == 0 {
+			return c.Next()
+		}
+
+		host := strings.ToLower(string(c.Request().Host()))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host != "" && hostAllowed(host, allowed) {
+			return c.Next()
+		}
+
+		log.Warn().
+			Str("host", host).
+			Str("ip", c.IP()).
+			Str("path", c.Path()).
+			Msg("Rejected request with disallowed host")
+
+		return errcode.JSON(c, fiber.StatusBadRequest, errcode.InvalidHost, "invalid host")
+	}
+}
+
+// hostAllowed reports whether host matches one of the allowed hosts.
+func hostAllowed(host string, allowed []string) bool {
+	for _, a := range allowed {
+		if suffix, ok := strings.CutPrefix(a, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == a {
+			return true
+		}
+	}
+	return false
+}
@@ -4,12 +4,40 @@ import (
 	"errors"
 	"time"
 
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
 )
 
+// Access log verbosities accepted by LogVerbosity.
+const (
+	LogFull       = "full"        // Log every request.
+	LogErrorsOnly = "errors-only" // Log failed requests only, those with an error or a status of 400 or more.
+	LogOff        = "off"         // Log no requests.
+)
+
+// logVerbosityKey is the key of the access log verbosity of the route in the request locals.
+const logVerbosityKey = "log_verbosity"
+
+// LogVerbosity returns a middleware that sets the access log verbosity of the routes it is
+// registered on, for RequestLogger. Requests rejected before reaching the route are logged in full.
+//
+// Parameters:
+//   - verbosity: One of LogFull, LogErrorsOnly or LogOff.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func LogVerbosity(verbosity string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(logVerbosityKey, verbosity)
+		return c.Next()
+	}
+}
+
 // RequestLogger logs details about incoming HTTP requests and their responses.
-// It logs the method, path, status, latency, and user ID (if available).
+// It logs the method, path, status, latency (also as latency_ms), response size in bytes,
+// and user ID and request ID (if available), subject to the verbosity set by LogVerbosity
+// on the route. The size of streamed responses is only logged when they declare it.
 //
 // Parameters:
 //   - logger: A zerolog.Logger instance for logging.
@@ -36,8 +64,22 @@ func RequestLogger(logger zerolog.Logger) fiber.Handler {
 			}
 		}
 
+		failed := err != nil || status >= 400
+		switch c.Locals(logVerbosityKey) {
+		case LogOff:
+			return renderError(c, err, status, msg)
+		case LogErrorsOnly:
+			if !failed {
+				return nil
+			}
+		}
+
+		// Rendered before logging so that the size of error responses is known.
+		renderErr := renderError(c, err, status, msg)
+		latency := stop.Sub(start)
+
 		event := logger.Info()
-		if err != nil || status >= 400 {
+		if failed {
 			event = logger.Error()
 		}
 
@@ -50,16 +92,48 @@ func RequestLogger(logger zerolog.Logger) fiber.Handler {
 			event = event.Str("user_id", userIDStr)
 		}
 
+		if requestID, ok := c.Locals("request_id").(string); ok && requestID != "" {
+			event = event.Str("request_id", requestID)
+		}
+
+		if attributes, ok := c.Locals(AttributesKey).(map[string]string); ok && len(attributes) > 0 {
+			dict := zerolog.Dict()
+			for name, value := range attributes {
+				dict = dict.Str(name, value)
+			}
+			event = event.Dict("attributes", dict)
+		}
+
 		event.
 			Str("method", c.Method()).
 			Str("path", c.Path()).
 			Int("status", status).
-			Dur("latency", stop.Sub(start)).
-			Str("ip", c.IP()).
-			Msg(msg)
+			Dur("latency", latency).
+			Float64("latency_ms", float64(latency.Microseconds())/1000).
+			Str("ip", c.IP())
+		if size, ok := responseSize(c); ok {
+			event = event.Int("bytes", size)
+		}
+		event.Msg(msg)
+
+		return renderErr
+	}
+}
+
+// responseSize returns the size in bytes of the response body, and false when it is an
+// unsized stream. A streamed body is never read, as that would block until it ends.
+func responseSize(c *fiber.Ctx) (int, bool) {
+	if !c.Response().IsBodyStream() {
+		return len(c.Response().Body()), true
+	}
+	size := c.Response().Header.ContentLength()
+	return size, size >= 0
+}
 
-		// Propagate the error if one occurred. Fiber's default error handler
-		// or a subsequent error handling middleware will manage the response.
-		return err
+// renderError renders err as JSON so every error response has the same shape.
+func renderError(c *fiber.Ctx, err error, status int, msg string) error {
+	if err != nil {
+		return errcode.JSON(c, status, errcode.FromStatus(status), msg)
 	}
+	return nil
 }
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// GatewayInstance is a middleware that adds an X-Gateway-Instance header naming the gateway
+// instance that served the request, so client reports can be matched to that instance's logs.
+// It overwrites any header of the same name sent by the upstream.
+//
+// Parameters:
+//   - id: The instance id, e.g. the pod hostname.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func GatewayInstance(id string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		c.Set("X-Gateway-Instance", id)
+		return err
+	}
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// maxJWKSSize bounds the size of a JWKS response.
+const maxJWKSSize = 64 << 10
+
+// JWKSConfig holds the settings of a JWKSValidator.
+type JWKSConfig struct {
+	URL                string             // Endpoint serving the JSON Web Key Set.
+	TTL                time.Duration      // How long fetched keys are used before the set is fetched again.
+	Timeout            time.Duration      // Maximum time spent fetching the set.
+	MinRefreshInterval time.Duration      // Minimum time between fetches caused by unknown key ids or failed fetches.
+	Limiter            *DependencyLimiter // Bounds concurrent fetches together with other dependency calls (nil for no bound).
+	Algs               []string           // Accepted signing algorithms; RS256, RS384 and RS512 when empty.
+	RequireExp         bool               // Whether tokens without an exp claim are rejected.
+	MaxLifetime        time.Duration      // Maximum time until a token expires, 0 for no limit; implies RequireExp.
+	Leeway             time.Duration      // Clock skew tolerated when checking the exp and nbf claims.
+	Audience           string             // Audience the aud claim must contain (empty skips the check).
+	Issuer             string             // Issuer the iss claim must be (empty skips the check).
+}
+
+// JWKSValidator validates RSA signed tokens with the key of the token's kid header, taken
+// from a JSON Web Key Set fetched from a URL. Keys are cached for the TTL and the set is
+// fetched again when it expires or a token names an unknown key, so rotated keys are picked
+// up without a restart. Concurrent requests share a single fetch, and the cached keys keep
+// being used while the endpoint is unavailable. It is safe for concurrent use.
+type JWKSValidator struct {
+	cfg    JWKSConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time     // Time of the last successful fetch.
+	attempted time.Time     // Time of the last fetch, successful or not.
+	fetching  chan struct{} // Closed when the fetch in progress is done; nil when none is.
+}
+
+// NewJWKSValidator returns a JWKSValidator for the settings in cfg. The set is fetched on
+// the first validation.
+//
+// Parameters:
+//   - cfg: The settings of the validator.
+//
+// Returns:
+//   - *JWKSValidator: The validator.
+func NewJWKSValidator(cfg JWKSConfig) *JWKSValidator {
+	if len(cfg.Algs) == 0 {
+		cfg.Algs = defaultRSAAlgs
+	}
+	return &JWKSValidator{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// ValidateJWT validates tokenStr with the key named by its kid header and returns its subject.
+func (v *JWKSValidator) ValidateJWT(tokenStr string) (string, error) {
+	claims, err := v.ValidateClaims(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	return claims["sub"].(string), nil
+}
+
+// ValidateClaims validates tokenStr like ValidateJWT and returns all its claims.
+func (v *JWKSValidator) ValidateClaims(tokenStr string) (map[string]any, error) {
+	return validateToken(tokenStr, v.cfg.Algs, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errInvalidToken
+		}
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errInvalidToken
+		}
+		return v.key(kid)
+	}, v.cfg.RequireExp, v.cfg.MaxLifetime, claimOptions(v.cfg.Leeway, v.cfg.Audience, v.cfg.Issuer)...)
+}
+
+// key returns the public key with the id kid, fetching the set first when it has expired
+// or does not contain the key.
+func (v *JWKSValidator) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	now := time.Now()
+	fresh := !v.fetched.IsZero() && now.Sub(v.fetched) < v.cfg.TTL
+	if ok && fresh {
+		v.mu.Unlock()
+		return key, nil
+	}
+
+	// Unknown key ids must not let clients make the gateway hammer the endpoint.
+	if !v.attempted.IsZero() && now.Sub(v.attempted) < v.cfg.MinRefreshInterval {
+		v.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		return nil, errInvalidToken
+	}
+
+	// A fetch in progress is waited for instead of starting another one.
+	if done := v.fetching; done != nil {
+		v.mu.Unlock()
+		<-done
+		v.mu.Lock()
+		key, ok = v.keys[kid]
+		v.mu.Unlock()
+		if !ok {
+			return nil, errInvalidToken
+		}
+		return key, nil
+	}
+
+	done := make(chan struct{})
+	v.fetching = done
+	v.attempted = now
+	v.mu.Unlock()
+
+	keys, err := v.fetch()
+
+	v.mu.Lock()
+	if err != nil {
+		log.Warn().Err(err).Str("url", v.cfg.URL).Msg("Failed to fetch JWKS")
+	} else {
+		v.keys = keys
+		v.fetched = time.Now()
+	}
+	key, ok = v.keys[kid]
+	v.fetching = nil
+	close(done)
+	v.mu.Unlock()
+
+	if !ok {
+		return nil, errInvalidToken
+	}
+	return key, nil
+}
+
+// fetch requests the key set and returns its RSA signing keys by key id.
+func (v *JWKSValidator) fetch() (map[string]*rsa.PublicKey, error) {
+	ctx := context.Background()
+	if v.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.cfg.Timeout)
+		defer cancel()
+	}
+
+	release, err := v.cfg.Limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxJWKSSize {
+		return nil, errors.New("JWKS response too large")
+	}
+	return parseJWKS(body)
+}
+
+// parseJWKS returns the RSA signing keys of a JSON Web Key Set by key id. Keys of other
+// types or uses are skipped.
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent of key %q", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
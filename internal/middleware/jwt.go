@@ -1,39 +1,161 @@
 package middleware
 
 import (
+	"crypto/rsa"
 	"errors"
+	"slices"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// ErrTokenExpired is returned by JWTObj for tokens that are valid but expired.
+var ErrTokenExpired = errors.New("token expired")
+
+// ErrAlgorithmNotAllowed is returned by JWTObj for tokens signed with an algorithm that is not allowed.
+var ErrAlgorithmNotAllowed = errors.New("token algorithm not allowed")
+
+// ErrTokenNoExpiry is returned by JWTObj for tokens without an exp claim when one is required.
+var ErrTokenNoExpiry = errors.New("token has no expiry")
+
+// ErrTokenLifetimeTooLong is returned by JWTObj for tokens that expire further in the future than allowed.
+var ErrTokenLifetimeTooLong = errors.New("token lifetime too long")
+
+// errInvalidToken is returned by the token validators for tokens that are invalid for any other reason.
+var errInvalidToken = errors.New("invalid token")
+
+// defaultAlgs are the algorithms accepted when JWTObj.Algs is empty: those of an HMAC secret.
+var defaultAlgs = []string{"HS256", "HS384", "HS512"}
+
+// defaultRSAAlgs are the algorithms additionally accepted when JWTObj.Algs is empty and a
+// public key is set.
+var defaultRSAAlgs = []string{"RS256", "RS384", "RS512"}
+
+// JWTObj validates tokens signed with an HMAC secret, an RSA key pair, or both. The key is
+// chosen by the kind of the token's algorithm, so a public key is never used as an HMAC secret.
 type JWTObj struct {
-	Secret []byte
+	Secret      []byte
+	PublicKey   *rsa.PublicKey // Public key of RS256, RS384 and RS512 tokens (nil rejects them).
+	Algs        []string       // Accepted signing algorithms (e.g. "HS256"); those of the configured keys when empty.
+	RequireExp  bool           // Whether tokens without an exp claim are rejected.
+	MaxLifetime time.Duration  // Maximum time until a token expires, 0 for no limit; implies RequireExp.
+	Leeway      time.Duration  // Clock skew tolerated when checking the exp and nbf claims.
+
+	Secrets *RotatingSecret // Rotating HMAC secrets, used instead of Secret when set.
+
+	ExpectedAudience string // Audience the aud claim must contain (empty skips the check).
+	ExpectedIssuer   string // Issuer the iss claim must be (empty skips the check).
 }
 
 func (j *JWTObj) ValidateJWT(tokenStr string) (string, error) {
-	errToken := errors.New("invalid token")
+	claims, err := j.ValidateClaims(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	return claims["sub"].(string), nil
+}
 
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the signing method is HMAC.
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errToken
+// ValidateClaims validates tokenStr like ValidateJWT and returns all its claims.
+func (j *JWTObj) ValidateClaims(tokenStr string) (map[string]any, error) {
+	algs := j.Algs
+	if len(algs) == 0 {
+		algs = defaultAlgs
+		if j.PublicKey != nil {
+			algs = append(slices.Clip(defaultAlgs), defaultRSAAlgs...)
 		}
-		return j.Secret, nil
-	})
+	}
+
+	opts := claimOptions(j.Leeway, j.ExpectedAudience, j.ExpectedIssuer)
 
+	return validateToken(tokenStr, algs, func(token *jwt.Token) (interface{}, error) {
+		// Select the key by the kind of signing method, never by the key available.
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if j.Secrets != nil {
+				// During a rotation the token may be signed with either secret.
+				var keys jwt.VerificationKeySet
+				for _, secret := range j.Secrets.Secrets() {
+					keys.Keys = append(keys.Keys, secret)
+				}
+				return keys, nil
+			}
+			if len(j.Secret) == 0 {
+				return nil, errInvalidToken
+			}
+			return j.Secret, nil
+		case *jwt.SigningMethodRSA:
+			if j.PublicKey == nil {
+				return nil, errInvalidToken
+			}
+			return j.PublicKey, nil
+		default:
+			return nil, errInvalidToken
+		}
+	}, j.RequireExp, j.MaxLifetime, opts...)
+}
+
+// claimOptions returns the parser options checking the time, audience and issuer claims.
+// The leeway absorbs clock skew between the issuer and the gateway. Tokens without the
+// expected claims are rejected like those with other values; an empty audience or issuer
+// skips its check.
+func claimOptions(leeway time.Duration, audience, issuer string) []jwt.ParserOption {
+	opts := []jwt.ParserOption{jwt.WithLeeway(leeway)}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	return opts
+}
+
+// validateToken parses and verifies tokenStr with the key returned by keyFunc, checks its
+// expiry and subject, and returns its claims. It is shared by the token validators.
+//
+// Parameters:
+//   - tokenStr: The token to validate.
+//   - algs: The accepted signing algorithms.
+//   - keyFunc: Returns the key verifying the token.
+//   - requireExp: Whether tokens without an exp claim are rejected.
+//   - maxLifetime: Maximum time until the token expires, 0 for no limit; implies requireExp.
+//   - opts: Additional claim checks of the parser, e.g. jwt.WithAudience.
+//
+// Returns:
+//   - map[string]any: The claims of the token, with a non-empty string sub claim.
+//   - error: An error if the token is not valid.
+func validateToken(tokenStr string, algs []string, keyFunc jwt.Keyfunc, requireExp bool, maxLifetime time.Duration, opts ...jwt.ParserOption) (map[string]any, error) {
+	token, err := jwt.Parse(tokenStr, keyFunc, append(opts, jwt.WithValidMethods(algs))...)
+
+	// Tell algorithm confusion attempts (e.g. "none") apart from other invalid tokens.
+	if token != nil && token.Method != nil && !slices.Contains(algs, token.Method.Alg()) {
+		return nil, ErrAlgorithmNotAllowed
+	}
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
 	if err != nil || !token.Valid {
-		return "", errToken
+		return nil, errInvalidToken
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", errToken
+		return nil, errInvalidToken
+	}
+
+	// Tokens of misconfigured issuers may never expire.
+	if requireExp || maxLifetime > 0 {
+		exp, err := claims.GetExpirationTime()
+		if err != nil || exp == nil {
+			return nil, ErrTokenNoExpiry
+		}
+		if maxLifetime > 0 && time.Until(exp.Time) > maxLifetime {
+			return nil, ErrTokenLifetimeTooLong
+		}
 	}
 
-	sub, ok := claims["sub"].(string)
-	if !ok || sub == "" {
-		return "", errToken
+	if sub, ok := claims["sub"].(string); !ok || sub == "" {
+		return nil, errInvalidToken
 	}
 
-	return sub, nil
+	return claims, nil
 }
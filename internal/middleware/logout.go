@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RefreshTokenCookie is the name of the cookie holding the refresh token.
+const RefreshTokenCookie = "refresh_token"
+
+// AuthCookieConfig holds the attributes of the auth cookies. Browsers only remove a
+// cookie when the clearing cookie has the same name, domain and path.
+type AuthCookieConfig struct {
+	Domain   string // Domain attribute of the cookies (empty for host-only).
+	Path     string // Path attribute of the cookies.
+	SameSite string // SameSite attribute of the cookies.
+	Secure   bool   // Secure attribute of the cookies.
+}
+
+// Logout returns a handler that clears the access token cookie, and the refresh token
+// cookie when the client sent one, by setting them with an expiry in the past.
+// It responds with 204 No Content.
+//
+// Parameters:
+//   - cfg: The attributes the auth cookies were set with.
+//
+// Returns:
+//   - fiber.Handler: The handler function.
+func Logout(cfg AuthCookieConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		names := []string{"access_token"}
+		if c.Cookies(RefreshTokenCookie) != "" {
+			names = append(names, RefreshTokenCookie)
+		}
+
+		for _, name := range names {
+			c.Cookie(&fiber.Cookie{
+				Name:     name,
+				Value:    "",
+				Domain:   cfg.Domain,
+				Path:     cfg.Path,
+				Expires:  time.Unix(0, 0),
+				Secure:   cfg.Secure,
+				HTTPOnly: true,
+				SameSite: cfg.SameSite,
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
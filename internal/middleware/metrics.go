@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dashboard-platform/api-gateway/internal/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Metrics is a middleware that records every request in m: the request count by method,
+// matched route and status, the request duration and the requests in flight. The route is
+// the path the route was registered with (e.g. "/templates/:id"), so that the labels stay
+// bounded; requests no route matched are recorded under "/", the path of the not-found
+// handler. Streamed responses are recorded once their headers are sent.
+//
+// Parameters:
+//   - m: The request collectors.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func Metrics(m *metrics.HTTP) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		m.Started()
+
+		err := c.Next()
+
+		// An error not rendered yet is counted with the status it will be rendered with.
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+		}
+		// The method is copied as fasthttp reuses its buffer, while the labels are kept.
+		m.Finished(utils.CopyString(c.Method()), c.Route().Path, status, time.Since(start))
+		return err
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// microCacheEntry is a cached response.
+type microCacheEntry struct {
+	status  int
+	headers [][2]string
+	body    []byte
+	expires time.Time
+}
+
+// MicroCache is a middleware that absorbs rapid-fire duplicate GET requests (double-clicks,
+// re-renders) by replaying the previous response for the same user and URL for a short window.
+// Only successful, non-streamed responses without cookies or "no-store" are cached, nor those
+// the handler marked with Vary, as the key does not include the request headers they vary by.
+// Only the headers written below the middleware are cached, so that headers set by outer
+// middleware (e.g. CORS) are not sent twice on a hit.
+// Cached responses carry an "X-Micro-Cache: hit" header. It must run after RequireAuth so
+// authenticated users get their own entries; anonymous requests are keyed by client IP. Requests
+// with credentials but no authenticated user (e.g. on routes without RequireAuth) are never
+// cached, as users sharing an IP would get each other's responses.
+//
+// Parameters:
+//   - window: How long a response is replayed.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func MicroCache(window time.Duration) fiber.Handler {
+	var (
+		mu        sync.Mutex
+		entries   = make(map[string]*microCacheEntry)
+		lastSweep = time.Now()
+	)
+
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodGet {
+			return c.Next()
+		}
+
+		owner, _ := c.Locals("user_id").(string)
+		if owner == "" {
+			if len(c.Request().Header.Peek(fiber.HeaderAuthorization)) > 0 || len(c.Request().Header.Peek(fiber.HeaderCookie)) > 0 {
+				return c.Next()
+			}
+			owner = "ip:" + c.IP()
+		}
+		key := owner + " " + string(c.Request().RequestURI())
+
+		now := time.Now()
+		mu.Lock()
+		if now.Sub(lastSweep) > window {
+			for k, e := range entries {
+				if now.After(e.expires) {
+					delete(entries, k)
+				}
+			}
+			lastSweep = now
+		}
+		entry, ok := entries[key]
+		mu.Unlock()
+
+		if ok && now.Before(entry.expires) {
+			// Set replaces a value an outer middleware wrote for the same header, and Add keeps
+			// the further values of multi-value headers.
+			replayed := make(map[string]bool, len(entry.headers))
+			for _, h := range entry.headers {
+				if replayed[h[0]] {
+					c.Response().Header.Add(h[0], h[1])
+				} else {
+					c.Response().Header.Set(h[0], h[1])
+					replayed[h[0]] = true
+				}
+			}
+			c.Set("X-Micro-Cache", "hit")
+			return c.Status(entry.status).Send(entry.body)
+		}
+
+		// Headers already written by outer middleware, which write them again on a hit.
+		outer := make(map[[2]string]int)
+		c.Response().Header.VisitAll(func(k, v []byte) {
+			outer[[2]string{string(k), string(v)}]++
+		})
+		outerVary := vary(&c.Response().Header)
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		resp := c.Response()
+		if resp.StatusCode() != fiber.StatusOK || resp.IsBodyStream() ||
+			len(resp.Header.Peek(fiber.HeaderSetCookie)) > 0 || vary(&resp.Header) != outerVary ||
+			strings.Contains(string(resp.Header.Peek(fiber.HeaderCacheControl)), "no-store") {
+			return nil
+		}
+
+		entry = &microCacheEntry{
+			status:  resp.StatusCode(),
+			body:    append([]byte(nil), resp.Body()...),
+			expires: time.Now().Add(window),
+		}
+		resp.Header.VisitAll(func(k, v []byte) {
+			h := [2]string{string(k), string(v)}
+			if h[0] == fiber.HeaderContentLength || h[0] == fiber.HeaderDate {
+				return
+			}
+			if outer[h] > 0 {
+				outer[h]--
+				return
+			}
+			entry.headers = append(entry.headers, h)
+		})
+
+		mu.Lock()
+		entries[key] = entry
+		mu.Unlock()
+
+		return nil
+	}
+}
+
+// vary returns the values of the Vary headers of a response, joined. Outer middleware such
+// as CORS may have set one already, varying by request headers they handle themselves.
+func vary(h *fasthttp.ResponseHeader) string {
+	var values []string
+	h.VisitAll(func(k, v []byte) {
+		if string(k) == fiber.HeaderVary {
+			values = append(values, string(v))
+		}
+	})
+	return strings.Join(values, ", ")
+}
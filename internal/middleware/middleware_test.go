@@ -1,17 +1,52 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/dashboard-platform/api-gateway/internal/audit"
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/dashboard-platform/api-gateway/internal/metrics"
+	"github.com/dashboard-platform/api-gateway/internal/proxy"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/helmet"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // TestRequestLogger tests the RequestLogger middleware
@@ -57,6 +92,7 @@ func TestRequestLogger_FiberError(t *testing.T) {
 	err = json.Unmarshal(body, &result)
 	assert.NoError(t, err)
 	assert.Equal(t, "Bad Request", result["error"])
+	assert.Equal(t, errcode.BadRequest, result["code"])
 }
 
 // TestRequestLogger_GenericError tests if the logger correctly logs a generic error response.
@@ -80,6 +116,7 @@ func TestRequestLogger_GenericError(t *testing.T) {
 	err = json.Unmarshal(body, &result)
 	assert.NoError(t, err)
 	assert.Equal(t, "Internal Server Error", result["error"])
+	assert.Equal(t, errcode.InternalError, result["code"])
 }
 
 // TestRequestLogger_WithUserID tests if the logger correctly logs the user ID from the context.
@@ -103,6 +140,148 @@ func TestRequestLogger_WithUserID(t *testing.T) {
 	assert.True(t, strings.Contains(logOutput, `"user_id":"12345"`), "Expected log to contain user_id")
 }
 
+// TestRequestID tests that a valid client request id is passed through, that a missing or
+// invalid one is replaced by a generated UUIDv4, and that the id is forwarded, echoed on
+// successful and failed responses and logged.
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string // Name of the test case.
+		sent     string // X-Request-ID sent by the client (empty for none).
+		path     string // The requested path.
+		passthru bool   // Whether the sent id is expected to be kept.
+	}{
+		{name: "passthrough", sent: "edge-7f9c2a", path: "/", passthru: true},
+		{name: "generated", path: "/"},
+		{name: "too long", sent: strings.Repeat("a", 129), path: "/"},
+		{name: "control characters", sent: "id\x01", path: "/"},
+		{name: "error response", sent: "edge-7f9c2a", path: "/error", passthru: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			app := fiber.New()
+			app.Use(RequestID(), RequestLogger(zerolog.New(&logBuf)))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(c.Get("X-Request-ID"))
+			})
+			app.Get("/error", func(c *fiber.Ctx) error {
+				return fiber.ErrBadGateway
+			})
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.sent != "" {
+				req.Header.Set("X-Request-ID", tt.sent)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			got := resp.Header.Get("X-Request-ID")
+			if tt.passthru {
+				assert.Equal(t, tt.sent, got)
+			} else {
+				id, err := uuid.Parse(got)
+				assert.NoError(t, err)
+				assert.Equal(t, uuid.Version(4), id.Version())
+			}
+			if resp.StatusCode == fiber.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, got, string(body), "Expected the id to be forwarded")
+			}
+
+			var entry map[string]any
+			assert.NoError(t, json.Unmarshal(logBuf.Bytes(), &entry))
+			assert.Equal(t, got, entry["request_id"])
+		})
+	}
+}
+
+// TestRequestLogger_LatencyAndSize tests that the latency in milliseconds and the response
+// size are logged, including for rendered errors, and that unsized streams have no size.
+func TestRequestLogger_LatencyAndSize(t *testing.T) {
+	tests := []struct {
+		name  string // Name of the test case.
+		path  string // The requested path.
+		sized bool   // Whether the response size is expected to be logged.
+	}{
+		{name: "body", path: "/", sized: true},
+		{name: "error response", path: "/error", sized: true},
+		{name: "unsized stream", path: "/stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			app := fiber.New()
+			app.Use(RequestLogger(zerolog.New(&logBuf)))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString("Hello, World!")
+			})
+			app.Get("/error", func(c *fiber.Ctx) error {
+				return fiber.ErrBadGateway
+			})
+			app.Get("/stream", func(c *fiber.Ctx) error {
+				c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+					_, _ = w.WriteString("data: 1\n\n")
+				})
+				return nil
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			assert.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			var entry map[string]any
+			assert.NoError(t, json.Unmarshal(logBuf.Bytes(), &entry))
+			latency, ok := entry["latency_ms"].(float64)
+			assert.True(t, ok, "Expected latency_ms to be logged")
+			assert.GreaterOrEqual(t, latency, 0.0)
+			assert.Contains(t, entry, "latency", "Expected the existing latency field to be kept")
+			if tt.sized {
+				assert.Equal(t, float64(len(body)), entry["bytes"])
+			} else {
+				assert.NotContains(t, entry, "bytes")
+			}
+		})
+	}
+}
+
+// TestRequestLogger_Verbosity tests that routes log every request, only failed ones or none
+// according to their verbosity, while errors are rendered regardless.
+func TestRequestLogger_Verbosity(t *testing.T) {
+	tests := []struct {
+		verbosity string // Verbosity of the route.
+		status    int    // Status returned by the route.
+		wantLog   bool   // Whether the request is logged.
+	}{
+		{verbosity: LogFull, status: fiber.StatusOK, wantLog: true},
+		{verbosity: LogFull, status: fiber.StatusInternalServerError, wantLog: true},
+		{verbosity: LogErrorsOnly, status: fiber.StatusOK, wantLog: false},
+		{verbosity: LogErrorsOnly, status: fiber.StatusInternalServerError, wantLog: true},
+		{verbosity: LogOff, status: fiber.StatusInternalServerError, wantLog: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.verbosity+" "+strconv.Itoa(tt.status), func(t *testing.T) {
+			var logBuf bytes.Buffer
+			app := fiber.New()
+			app.Use(RequestLogger(zerolog.New(&logBuf)))
+			app.Get("/", LogVerbosity(tt.verbosity), func(c *fiber.Ctx) error {
+				if tt.status >= fiber.StatusInternalServerError {
+					return fiber.NewError(tt.status, "render failed")
+				}
+				return c.SendStatus(tt.status)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.wantLog, strings.Contains(logBuf.String(), `"path":"/"`))
+		})
+	}
+}
+
 // FakeJWT is a fake implementation of the JWTValidator interface for testing.
 // It simulates token validation: if the token is "valid-token", it returns "user123"; otherwise, it returns an error.
 type FakeJWT struct{}
@@ -142,6 +321,7 @@ func TestRequireAuth_NoToken(t *testing.T) {
 	result, err := parseJSONBody(bodyBytes)
 	assert.NoError(t, err)
 	assert.Equal(t, "authentication required", result["error"])
+	assert.Equal(t, errcode.AuthRequired, result["code"])
 }
 
 // TestRequireAuth_InvalidToken tests the scenario where an invalid token is provided via cookie.
@@ -165,6 +345,35 @@ func TestRequireAuth_InvalidToken(t *testing.T) {
 	result, err := parseJSONBody(bodyBytes)
 	assert.NoError(t, err)
 	assert.Equal(t, "invalid or expired token", result["error"])
+	assert.Equal(t, errcode.TokenInvalid, result["code"])
+}
+
+// TestRequireAuth_ExpiredToken tests that expired tokens are told apart from invalid ones by their code.
+func TestRequireAuth_ExpiredToken(t *testing.T) {
+	app := fiber.New()
+	app.Use(RequireAuth(&JWTObj{Secret: []byte("secret")}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("Success")
+	})
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user123",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	result, err := parseJSONBody(bodyBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, "invalid or expired token", result["error"])
+	assert.Equal(t, errcode.TokenExpired, result["code"])
 }
 
 // TestRequireAuth_ValidTokenFromCookie tests a valid token provided via cookie.
@@ -238,3 +447,2246 @@ func TestRequireAuth_CookiePrecedence(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "invalid or expired token", result["error"])
 }
+
+// TestRequireAuthFrom_TokenSource tests how each token source picks between the cookie and
+// the Authorization header.
+func TestRequireAuthFrom_TokenSource(t *testing.T) {
+	tests := []struct {
+		name       string // Name of the test case.
+		source     string // Token source under test.
+		cookie     string // access_token cookie sent by the client.
+		header     string // Bearer token sent by the client.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "cookie_first invalid cookie", source: TokenSourceCookieFirst, cookie: "invalid-token", header: "valid-token", wantStatus: fiber.StatusUnauthorized},
+		{name: "header_first valid header", source: TokenSourceHeaderFirst, cookie: "invalid-token", header: "valid-token", wantStatus: fiber.StatusOK},
+		{name: "header_first falls back to cookie", source: TokenSourceHeaderFirst, cookie: "valid-token", wantStatus: fiber.StatusOK},
+		{name: "cookie_only ignores header", source: TokenSourceCookieOnly, header: "valid-token", wantStatus: fiber.StatusUnauthorized},
+		{name: "header_only ignores cookie", source: TokenSourceHeaderOnly, cookie: "valid-token", wantStatus: fiber.StatusUnauthorized},
+		{name: "any falls through to header", source: TokenSourceAny, cookie: "invalid-token", header: "valid-token", wantStatus: fiber.StatusOK},
+		{name: "any rejects two invalid tokens", source: TokenSourceAny, cookie: "invalid-token", header: "other-token", wantStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(RequireAuthFrom(&FakeJWT{}, tt.source))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(c.Locals("user_id").(string))
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.cookie != "" {
+				req.Header.Set("Cookie", "access_token="+tt.cookie)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.header)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestJWTObj_AllowedAlgs tests that only tokens signed with an allowed algorithm are accepted
+// and that others are rejected with ErrAlgorithmNotAllowed.
+func TestJWTObj_AllowedAlgs(t *testing.T) {
+	secret := []byte("secret")
+	claims := jwt.MapClaims{"sub": "user123"}
+
+	sign := func(method jwt.SigningMethod, alg string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if alg != "" {
+			token.Header["alg"] = alg
+		}
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	tests := []struct {
+		name    string   // Name of the test case.
+		algs    []string // Allowed algorithms.
+		token   string   // Token validated.
+		wantErr error    // Expected error, nil for success.
+	}{
+		{name: "default HS256", token: sign(jwt.SigningMethodHS256, "", secret)},
+		{name: "default HS512", token: sign(jwt.SigningMethodHS512, "", secret)},
+		{name: "alg none", token: sign(jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType), wantErr: ErrAlgorithmNotAllowed},
+		{name: "HMAC signature claiming RS256", token: sign(jwt.SigningMethodHS256, "RS256", secret), wantErr: ErrAlgorithmNotAllowed},
+		{name: "allowed HS256", algs: []string{"HS256"}, token: sign(jwt.SigningMethodHS256, "", secret)},
+		{name: "mismatched HS512", algs: []string{"HS256"}, token: sign(jwt.SigningMethodHS512, "", secret), wantErr: ErrAlgorithmNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := (&JWTObj{Secret: secret, Algs: tt.algs}).ValidateJWT(tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user123", userID)
+		})
+	}
+}
+
+// TestJWTObj_RS256 tests that RS256 tokens are verified with the public key, and that the
+// public key can never be used as an HMAC secret.
+func TestJWTObj_RS256(t *testing.T) {
+	secret := []byte("secret")
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	sign := func(method jwt.SigningMethod, key interface{}) string {
+		token, err := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "user123"}).SignedString(key)
+		assert.NoError(t, err)
+		return token
+	}
+
+	tests := []struct {
+		name      string         // Name of the test case.
+		publicKey *rsa.PublicKey // Public key of the validator.
+		algs      []string       // Allowed algorithms.
+		token     string         // Token validated.
+		wantErr   bool           // Whether validation fails.
+	}{
+		{name: "RS256", publicKey: &privateKey.PublicKey, token: sign(jwt.SigningMethodRS256, privateKey)},
+		{name: "RS512", publicKey: &privateKey.PublicKey, token: sign(jwt.SigningMethodRS512, privateKey)},
+		{name: "HS256 next to a public key", publicKey: &privateKey.PublicKey, token: sign(jwt.SigningMethodHS256, secret)},
+		{name: "other private key", publicKey: &privateKey.PublicKey, token: sign(jwt.SigningMethodRS256, otherKey), wantErr: true},
+		{name: "no public key", token: sign(jwt.SigningMethodRS256, privateKey), wantErr: true},
+		{name: "public key as HMAC secret", publicKey: &privateKey.PublicKey, token: sign(jwt.SigningMethodHS256, publicPEM), wantErr: true},
+		{name: "RS256 only", publicKey: &privateKey.PublicKey, algs: []string{"RS256"}, token: sign(jwt.SigningMethodHS256, secret), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := (&JWTObj{Secret: secret, PublicKey: tt.publicKey, Algs: tt.algs}).ValidateJWT(tt.token)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user123", userID)
+		})
+	}
+}
+
+// jwksServer serves the public keys in keys as a JSON Web Key Set, replaced by storing a
+// new map, and counts the fetches.
+func jwksServer(t *testing.T, keys *atomic.Value, fetches *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		// Slow enough for concurrent validations to overlap with the fetch.
+		time.Sleep(20 * time.Millisecond)
+		var set []map[string]string
+		for kid, key := range keys.Load().(map[string]*rsa.PublicKey) {
+			set = append(set, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": set})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestJWKSValidator tests that tokens are verified with the key named by their kid, that
+// keys are cached, and that rotated keys are fetched once when a token names an unknown key.
+func TestJWKSValidator(t *testing.T) {
+	key1, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	key2, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	sign := func(kid string, key *rsa.PrivateKey) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "user123"})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	var keys atomic.Value
+	var fetches atomic.Int32
+	keys.Store(map[string]*rsa.PublicKey{"k1": &key1.PublicKey})
+	server := jwksServer(t, &keys, &fetches)
+	v := NewJWKSValidator(JWKSConfig{URL: server.URL, TTL: time.Hour, Timeout: time.Second})
+
+	userID, err := v.ValidateJWT(sign("k1", key1))
+	assert.NoError(t, err)
+	assert.Equal(t, "user123", userID)
+	_, err = v.ValidateJWT(sign("k1", key1))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load(), "keys are cached")
+
+	_, err = v.ValidateJWT(sign("", key1))
+	assert.Error(t, err, "token without kid")
+	_, err = v.ValidateJWT(sign("k1", key2))
+	assert.Error(t, err, "token signed with another key")
+	_, err = v.ValidateJWT("not a token")
+	assert.Error(t, err)
+
+	// Rotate the keys: concurrent tokens of the new key share a single fetch.
+	keys.Store(map[string]*rsa.PublicKey{"k2": &key2.PublicKey})
+	fetches.Store(0)
+	token2 := sign("k2", key2)
+	errs := make(chan error, 20)
+	for range 20 {
+		go func() {
+			_, err := v.ValidateJWT(token2)
+			errs <- err
+		}()
+	}
+	for range 20 {
+		assert.NoError(t, <-errs)
+	}
+	assert.Equal(t, int32(1), fetches.Load(), "rotated keys are fetched once")
+
+	// The retired key is gone after the refresh.
+	_, err = v.ValidateJWT(sign("k1", key1))
+	assert.Error(t, err)
+}
+
+// TestJWKSValidator_AudienceIssuer tests that tokens of the key set are rejected when their
+// aud or iss claim is not the expected one, or missing.
+func TestJWKSValidator_AudienceIssuer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	var keys atomic.Value
+	var fetches atomic.Int32
+	keys.Store(map[string]*rsa.PublicKey{"k1": &key.PublicKey})
+	server := jwksServer(t, &keys, &fetches)
+
+	tests := []struct {
+		name    string        // Name of the test case.
+		claims  jwt.MapClaims // Claims of the token besides sub.
+		wantErr bool          // Whether the token is expected to be rejected.
+	}{
+		{name: "expected audience and issuer", claims: jwt.MapClaims{"aud": "api-gateway", "iss": "https://idp.example.com"}},
+		{name: "audience among others", claims: jwt.MapClaims{"aud": []string{"billing", "api-gateway"}, "iss": "https://idp.example.com"}},
+		{name: "wrong audience", claims: jwt.MapClaims{"aud": "billing", "iss": "https://idp.example.com"}, wantErr: true},
+		{name: "wrong issuer", claims: jwt.MapClaims{"aud": "api-gateway", "iss": "https://other.example.com"}, wantErr: true},
+		{name: "missing claims", claims: jwt.MapClaims{}, wantErr: true},
+	}
+
+	v := NewJWKSValidator(JWKSConfig{
+		URL:      server.URL,
+		TTL:      time.Hour,
+		Timeout:  time.Second,
+		Audience: "api-gateway",
+		Issuer:   "https://idp.example.com",
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["sub"] = "user123"
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims)
+			token.Header["kid"] = "k1"
+			signed, err := token.SignedString(key)
+			assert.NoError(t, err)
+
+			userID, err := v.ValidateJWT(signed)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user123", userID)
+		})
+	}
+}
+
+// TestJWKSValidator_Refresh tests that the set is fetched again once the TTL expires, and
+// that unknown key ids do not cause fetches within the minimum refresh interval.
+func TestJWKSValidator_Refresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "user123"})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	var keys atomic.Value
+	var fetches atomic.Int32
+	keys.Store(map[string]*rsa.PublicKey{"k1": &key.PublicKey})
+	server := jwksServer(t, &keys, &fetches)
+
+	t.Run("ttl", func(t *testing.T) {
+		fetches.Store(0)
+		v := NewJWKSValidator(JWKSConfig{URL: server.URL, TTL: 50 * time.Millisecond, Timeout: time.Second})
+		_, err := v.ValidateJWT(sign("k1"))
+		assert.NoError(t, err)
+		time.Sleep(60 * time.Millisecond)
+		_, err = v.ValidateJWT(sign("k1"))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), fetches.Load())
+	})
+
+	t.Run("unknown kid throttled", func(t *testing.T) {
+		fetches.Store(0)
+		v := NewJWKSValidator(JWKSConfig{URL: server.URL, TTL: time.Hour, Timeout: time.Second, MinRefreshInterval: time.Hour})
+		_, err := v.ValidateJWT(sign("k1"))
+		assert.NoError(t, err)
+		for _, kid := range []string{"x1", "x2", "x3"} {
+			_, err = v.ValidateJWT(sign(kid))
+			assert.Error(t, err)
+		}
+		assert.Equal(t, int32(1), fetches.Load())
+	})
+
+	t.Run("endpoint down keeps keys", func(t *testing.T) {
+		v := NewJWKSValidator(JWKSConfig{URL: server.URL, TTL: 50 * time.Millisecond, Timeout: time.Second})
+		_, err := v.ValidateJWT(sign("k1"))
+		assert.NoError(t, err)
+		v.cfg.URL = "http://127.0.0.1:1"
+		time.Sleep(60 * time.Millisecond)
+		_, err = v.ValidateJWT(sign("k1"))
+		assert.NoError(t, err)
+	})
+}
+
+// TestDependencyLimiter tests that a burst of calls never exceeds the bound on concurrent
+// calls, and that calls fail with ErrDependencyBusy when no slot frees up in time.
+func TestDependencyLimiter(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	l := NewDependencyLimiter(3, 5*time.Second)
+	errs := make(chan error, 30)
+	for range 30 {
+		go func() {
+			release, err := l.Acquire(context.Background())
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer release()
+			resp, err := http.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+	for range 30 {
+		assert.NoError(t, <-errs)
+	}
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(3))
+
+	busy := NewDependencyLimiter(1, 10*time.Millisecond)
+	release, err := busy.Acquire(context.Background())
+	assert.NoError(t, err)
+	_, err = busy.Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrDependencyBusy)
+	release()
+	release, err = busy.Acquire(context.Background())
+	assert.NoError(t, err)
+	release()
+
+	var unlimited *DependencyLimiter
+	release, err = unlimited.Acquire(context.Background())
+	assert.NoError(t, err)
+	release()
+}
+
+// TestRotatingSecret tests that a rotated secret file is picked up without a restart, and
+// that tokens of the previous secret validate during the grace window only.
+func TestRotatingSecret(t *testing.T) {
+	sign := func(secret string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user123"}).SignedString([]byte(secret))
+		assert.NoError(t, err)
+		return token
+	}
+	oldToken, newToken := sign("old-secret"), sign("new-secret")
+
+	path := filepath.Join(t.TempDir(), "jwt_secret")
+	assert.NoError(t, os.WriteFile(path, []byte("old-secret\n"), 0o600))
+	source := FileSecretSource(path)
+	initial, err := source(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old-secret"), initial)
+	unchanged, err := source(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, unchanged, "unchanged file is not read again")
+
+	secrets := NewRotatingSecret(initial, 300*time.Millisecond)
+	v := &JWTObj{Secrets: secrets}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go secrets.Refresh(ctx, 10*time.Millisecond, source)
+
+	_, err = v.ValidateJWT(oldToken)
+	assert.NoError(t, err)
+	_, err = v.ValidateJWT(newToken)
+	assert.Error(t, err)
+
+	// Rotate the file; the modification time is moved so the change is seen on any file system.
+	assert.NoError(t, os.WriteFile(path, []byte("new-secret\n"), 0o600))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+
+	assert.Eventually(t, func() bool {
+		_, err := v.ValidateJWT(newToken)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	_, err = v.ValidateJWT(oldToken)
+	assert.NoError(t, err, "previous secret within the grace window")
+
+	assert.Eventually(t, func() bool {
+		_, err := v.ValidateJWT(oldToken)
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "previous secret after the grace window")
+	_, err = v.ValidateJWT(newToken)
+	assert.NoError(t, err)
+}
+
+// TestHTTPSecretSource tests that the secret is read from the provider's response body and
+// that failed and empty responses are errors.
+func TestHTTPSecretSource(t *testing.T) {
+	tests := []struct {
+		name    string // Name of the test case.
+		status  int    // Status returned by the provider.
+		body    string // Body returned by the provider.
+		want    string // Expected secret.
+		wantErr bool   // Whether reading fails.
+	}{
+		{name: "secret", status: http.StatusOK, body: "rotated-secret\n", want: "rotated-secret"},
+		{name: "error status", status: http.StatusServiceUnavailable, body: "rotated-secret", wantErr: true},
+		{name: "empty", status: http.StatusOK, body: " \n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer provider.Close()
+
+			secret, err := HTTPSecretSource(provider.URL, time.Second, nil)(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(secret))
+		})
+	}
+}
+
+// TestJWTObj_Leeway tests that tokens expired or not yet valid by a few seconds are accepted
+// within the leeway, and rejected outside it or without one.
+func TestJWTObj_Leeway(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(claims jwt.MapClaims) string {
+		claims["sub"] = "user123"
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		assert.NoError(t, err)
+		return token
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string        // Name of the test case.
+		leeway  time.Duration // Leeway of the validator.
+		claims  jwt.MapClaims // Claims of the token besides sub.
+		wantErr error         // Expected error, nil for success.
+	}{
+		{name: "expired within leeway", leeway: 5 * time.Second, claims: jwt.MapClaims{"exp": now.Add(-2 * time.Second).Unix()}},
+		{name: "expired outside leeway", leeway: 5 * time.Second, claims: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, wantErr: ErrTokenExpired},
+		{name: "expired without leeway", claims: jwt.MapClaims{"exp": now.Add(-2 * time.Second).Unix()}, wantErr: ErrTokenExpired},
+		{name: "not yet valid within leeway", leeway: 5 * time.Second, claims: jwt.MapClaims{"nbf": now.Add(2 * time.Second).Unix()}},
+		{name: "not yet valid outside leeway", leeway: 5 * time.Second, claims: jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}, wantErr: errInvalidToken},
+		{name: "not yet valid without leeway", claims: jwt.MapClaims{"nbf": now.Add(2 * time.Second).Unix()}, wantErr: errInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := (&JWTObj{Secret: secret, Leeway: tt.leeway}).ValidateJWT(sign(tt.claims))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user123", userID)
+		})
+	}
+}
+
+// TestJWTObj_AudienceIssuer tests that the aud and iss claims must match the expected values
+// when they are set, and are not checked otherwise.
+func TestJWTObj_AudienceIssuer(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(claims jwt.MapClaims) string {
+		claims["sub"] = "user123"
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		assert.NoError(t, err)
+		return token
+	}
+
+	tests := []struct {
+		name     string        // Name of the test case.
+		audience string        // Expected audience.
+		issuer   string        // Expected issuer.
+		claims   jwt.MapClaims // Claims of the token besides sub.
+		wantErr  bool          // Whether validation fails.
+	}{
+		{name: "not checked", claims: jwt.MapClaims{"aud": "other", "iss": "other"}},
+		{name: "matching", audience: "api-gateway", issuer: "https://auth.example.com", claims: jwt.MapClaims{"aud": "api-gateway", "iss": "https://auth.example.com"}},
+		{name: "audience in list", audience: "api-gateway", claims: jwt.MapClaims{"aud": []string{"billing", "api-gateway"}}},
+		{name: "audience mismatch", audience: "api-gateway", claims: jwt.MapClaims{"aud": "billing"}, wantErr: true},
+		{name: "audience absent", audience: "api-gateway", claims: jwt.MapClaims{}, wantErr: true},
+		{name: "issuer mismatch", issuer: "https://auth.example.com", claims: jwt.MapClaims{"iss": "https://evil.example.com"}, wantErr: true},
+		{name: "issuer absent", issuer: "https://auth.example.com", claims: jwt.MapClaims{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &JWTObj{Secret: secret, ExpectedAudience: tt.audience, ExpectedIssuer: tt.issuer}
+			userID, err := v.ValidateJWT(sign(tt.claims))
+			if tt.wantErr {
+				assert.EqualError(t, err, "invalid token")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user123", userID)
+		})
+	}
+}
+
+// TestJWTObj_Expiry tests the policies for tokens without expiry and with a long lifetime.
+func TestJWTObj_Expiry(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		assert.NoError(t, err)
+		return token
+	}
+	noExp := sign(jwt.MapClaims{"sub": "user123"})
+	hour := sign(jwt.MapClaims{"sub": "user123", "exp": time.Now().Add(time.Hour).Unix()})
+	year := sign(jwt.MapClaims{"sub": "user123", "exp": time.Now().Add(365 * 24 * time.Hour).Unix()})
+
+	tests := []struct {
+		name    string  // Name of the test case.
+		jwt     *JWTObj // Validator.
+		token   string  // Token validated.
+		wantErr error   // Expected error, nil for success.
+	}{
+		{name: "no exp allowed by default", jwt: &JWTObj{Secret: secret}, token: noExp},
+		{name: "no exp rejected", jwt: &JWTObj{Secret: secret, RequireExp: true}, token: noExp, wantErr: ErrTokenNoExpiry},
+		{name: "exp required and present", jwt: &JWTObj{Secret: secret, RequireExp: true}, token: hour},
+		{name: "lifetime within limit", jwt: &JWTObj{Secret: secret, MaxLifetime: 24 * time.Hour}, token: hour},
+		{name: "lifetime too long", jwt: &JWTObj{Secret: secret, MaxLifetime: 24 * time.Hour}, token: year, wantErr: ErrTokenLifetimeTooLong},
+		{name: "lifetime limit requires exp", jwt: &JWTObj{Secret: secret, MaxLifetime: 24 * time.Hour}, token: noExp, wantErr: ErrTokenNoExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := tt.jwt.ValidateJWT(tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user123", userID)
+		})
+	}
+}
+
+// TestRequireAuthWithHeader tests that the user id is forwarded in the configured header,
+// and that a client-sent X-User-ID only survives when it is that header.
+func TestRequireAuthWithHeader(t *testing.T) {
+	tests := []struct {
+		name       string // Name of the test case.
+		header     string // Header the user id is forwarded in.
+		wantHeader string // Expected value of the configured header.
+		wantLegacy string // Expected value of X-User-ID.
+	}{
+		{name: "default", header: DefaultUserIDHeader, wantHeader: "user123", wantLegacy: "user123"},
+		{name: "custom", header: "X-Authenticated-User-Id", wantHeader: "user123", wantLegacy: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(RequireAuthWithHeader(&FakeJWT{}, TokenSourceCookieFirst, tt.header))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(c.Get(tt.header) + "|" + c.Get(DefaultUserIDHeader))
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Cookie", "access_token=valid-token")
+			req.Header.Set(DefaultUserIDHeader, "spoofed")
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			bodyBytes, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantHeader+"|"+tt.wantLegacy, string(bodyBytes))
+		})
+	}
+}
+
+// TestForwardClaims tests that the configured claims of the token reach the handler in
+// their headers, and that spoofed, multi-line and structured values are not forwarded.
+func TestForwardClaims(t *testing.T) {
+	secret := []byte("secret")
+	headers := map[string]string{
+		"email":  "X-User-Email",
+		"role":   "X-User-Role",
+		"groups": "X-User-Groups",
+		"org":    "X-User-Org",
+		"tier":   "X-User-Tier",
+	}
+
+	tests := []struct {
+		name   string            // Name of the test case.
+		claims jwt.MapClaims     // Claims of the token besides sub.
+		spoof  map[string]string // Headers sent by the client.
+		want   map[string]string // Expected forwarded headers, by header name.
+	}{
+		{
+			name:   "claims",
+			claims: jwt.MapClaims{"email": "jane@example.com", "role": "editor", "groups": []string{"design", "ops"}, "tier": 2},
+			want:   map[string]string{"X-User-Email": "jane@example.com", "X-User-Role": "editor", "X-User-Groups": "design,ops", "X-User-Tier": "2"},
+		},
+		{
+			name:   "spoofed headers dropped",
+			claims: jwt.MapClaims{"email": "jane@example.com"},
+			spoof:  map[string]string{"X-User-Role": "admin"},
+			want:   map[string]string{"X-User-Email": "jane@example.com"},
+		},
+		{
+			name:   "header injection",
+			claims: jwt.MapClaims{"email": "jane@example.com\r\nX-Admin: true", "role": "editor"},
+			want:   map[string]string{"X-User-Role": "editor"},
+		},
+		{
+			name:   "object claim",
+			claims: jwt.MapClaims{"org": map[string]any{"id": 1}},
+			want:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(RequireAuth(&JWTObj{Secret: secret}), ForwardClaims(headers))
+			app.Get("/", func(c *fiber.Ctx) error {
+				got := map[string]string{}
+				for _, header := range headers {
+					if v := c.Get(header); v != "" {
+						got[header] = v
+					}
+				}
+				return c.JSON(got)
+			})
+
+			claims := jwt.MapClaims{"sub": "user123"}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			for k, v := range tt.spoof {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			var got map[string]string
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestRequireRole tests that only users with an allowed role, from a single role or a list
+// of roles, pass, and that tokens without a role claim are forbidden.
+func TestRequireRole(t *testing.T) {
+	secret := []byte("secret")
+
+	tests := []struct {
+		name   string        // Name of the test case.
+		claims jwt.MapClaims // Claims of the token besides sub.
+		status int           // Expected status code.
+	}{
+		{name: "allowed role", claims: jwt.MapClaims{"role": "admin"}, status: fiber.StatusOK},
+		{name: "allowed role in list", claims: jwt.MapClaims{"role": []string{"editor", "owner"}}, status: fiber.StatusOK},
+		{name: "disallowed role", claims: jwt.MapClaims{"role": "viewer"}, status: fiber.StatusForbidden},
+		{name: "missing role claim", claims: jwt.MapClaims{}, status: fiber.StatusForbidden},
+		{name: "invalid role claim", claims: jwt.MapClaims{"role": map[string]any{"name": "admin"}}, status: fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(RequireAuth(&JWTObj{Secret: secret}), RequireRole("admin", "owner"))
+			app.Delete("/templates/1", func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			claims := jwt.MapClaims{"sub": "user123"}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest("DELETE", "/templates/1", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.status == fiber.StatusForbidden {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Contains(t, string(body), errcode.Forbidden)
+			}
+		})
+	}
+}
+
+// revocationCheckerFunc adapts a function to the RevocationChecker interface.
+type revocationCheckerFunc func(tokenID string) (bool, error)
+
+func (f revocationCheckerFunc) IsRevoked(tokenID string) (bool, error) { return f(tokenID) }
+
+// TestRequireAuthWithRevocation tests that revoked tokens are rejected, that live tokens and
+// tokens without a jti pass, and that a failing checker fails closed.
+func TestRequireAuthWithRevocation(t *testing.T) {
+	secret := []byte("secret")
+	revoked := NewMemoryRevocationList(time.Hour)
+	revoked.Revoke("revoked-id")
+	failing := revocationCheckerFunc(func(string) (bool, error) { return false, errors.New("store unavailable") })
+
+	tests := []struct {
+		name    string            // Name of the test case.
+		checker RevocationChecker // The configured checker.
+		jti     string            // jti claim of the token (empty for none).
+		status  int               // Expected status code.
+		code    string            // Expected error code.
+	}{
+		{name: "revoked token", checker: revoked, jti: "revoked-id", status: fiber.StatusUnauthorized, code: errcode.TokenRevoked},
+		{name: "live token", checker: revoked, jti: "live-id", status: fiber.StatusOK},
+		{name: "no jti", checker: revoked, status: fiber.StatusOK},
+		{name: "no checker", jti: "revoked-id", status: fiber.StatusOK},
+		{name: "checker error", checker: failing, jti: "live-id", status: fiber.StatusServiceUnavailable, code: errcode.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(RequireAuthWithRevocation(&JWTObj{Secret: secret}, TokenSourceCookieFirst, DefaultUserIDHeader, tt.checker))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			claims := jwt.MapClaims{"sub": "user123"}
+			if tt.jti != "" {
+				claims["jti"] = tt.jti
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.code != "" {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Contains(t, string(body), tt.code)
+			}
+		})
+	}
+}
+
+// TestMemoryRevocationList tests that revoked ids are forgotten after the TTL, evicted on
+// later revocations, and that the admin endpoint revokes ids and audits the revocations.
+func TestMemoryRevocationList(t *testing.T) {
+	now := time.Now()
+	list := NewMemoryRevocationList(time.Hour)
+	list.now = func() time.Time { return now }
+
+	list.Revoke("a")
+	revoked, err := list.IsRevoked("a")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, _ = list.IsRevoked("b")
+	assert.False(t, revoked)
+
+	now = now.Add(time.Hour)
+	revoked, _ = list.IsRevoked("a")
+	assert.False(t, revoked, "Expected the id to be forgotten after the TTL")
+	list.Revoke("b")
+	assert.Len(t, list.revoked, 1, "Expected the expired id to be evicted")
+
+	sink := &fakeSink{}
+	app := fiber.New()
+	app.Post("/admin/revocations", list.AdminHandler(sink))
+	for _, tt := range []struct {
+		body   string // Request body.
+		status int    // Expected status code.
+	}{
+		{body: `{"jti":"c"}`, status: fiber.StatusNoContent},
+		{body: `{"jti":""}`, status: fiber.StatusBadRequest},
+		{body: `not json`, status: fiber.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/admin/revocations", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.body)
+	}
+	revoked, _ = list.IsRevoked("c")
+	assert.True(t, revoked)
+	if assert.Len(t, sink.events, 1, "Expected only the revocation to be audited") {
+		assert.Equal(t, audit.TypeAdminAction, sink.events[0].Type)
+		assert.Equal(t, "/admin/revocations", sink.events[0].Path)
+		assert.Equal(t, fiber.StatusNoContent, sink.events[0].Status)
+		assert.Equal(t, map[string]string{"action": "revoke_token", "token_id": "c"}, sink.events[0].Details)
+	}
+}
+
+// TestRequireAuth_PerRouteValidator tests that a token valid for one issuer is rejected on
+// the routes of another issuer's validator.
+func TestRequireAuth_PerRouteValidator(t *testing.T) {
+	internal := &JWTObj{Secret: []byte("internal-secret")}
+	partners := &JWTObj{Secret: []byte("partner-secret")}
+
+	app := fiber.New()
+	app.Get("/internal", RequireAuth(internal), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+	app.Get("/partners", RequireAuth(partners), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	sign := func(secret string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user123"}).SignedString([]byte(secret))
+		assert.NoError(t, err)
+		return token
+	}
+
+	tests := []struct {
+		name       string // Name of the test case.
+		path       string // Route requested.
+		secret     string // Secret the token is signed with.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "internal token on internal routes", path: "/internal", secret: "internal-secret", wantStatus: fiber.StatusOK},
+		{name: "partner token on partner routes", path: "/partners", secret: "partner-secret", wantStatus: fiber.StatusOK},
+		{name: "partner token on internal routes", path: "/internal", secret: "partner-secret", wantStatus: fiber.StatusUnauthorized},
+		{name: "internal token on partner routes", path: "/partners", secret: "internal-secret", wantStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+sign(tt.secret))
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestAnonymousID_IssuesCookie tests that a new anonymous id is issued as a cookie
+// and forwarded to the handler when the client has none.
+func TestAnonymousID_IssuesCookie(t *testing.T) {
+	app := fiber.New()
+	app.Use(AnonymousID(AnonIDConfig{TTL: time.Hour, SameSite: "Lax"}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(c.Get("X-Anon-ID"))
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Anon-ID", "spoofed")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+
+	var cookie *http.Cookie
+	for _, ck := range resp.Cookies() {
+		if ck.Name == AnonIDCookie {
+			cookie = ck
+		}
+	}
+	if assert.NotNil(t, cookie) {
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Expires.After(time.Now()))
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, cookie.Value, string(bodyBytes))
+	}
+}
+
+// TestAnonymousID_ReusesCookie tests that an existing anonymous id is forwarded unchanged
+// and no new cookie is issued.
+func TestAnonymousID_ReusesCookie(t *testing.T) {
+	app := fiber.New()
+	app.Use(AnonymousID(AnonIDConfig{TTL: time.Hour}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(c.Get("X-Anon-ID"))
+	})
+
+	const anonID = "3f0e6c7e-4a3b-4f43-9a53-7c1f2a6b8d10"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", AnonIDCookie+"="+anonID)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, anonID, string(bodyBytes))
+}
+
+// newOptionsApp builds an app with CORS and the OPTIONS handling of a proxied /templates route.
+// The fake upstream answers with "upstream" so tests can tell which handler responded.
+func newOptionsApp(forward bool) *fiber.App {
+	upstream := func(c *fiber.Ctx) error {
+		return c.SendString("upstream")
+	}
+
+	var prefixes []string
+	if forward {
+		prefixes = append(prefixes, "/templates/")
+	}
+
+	app := fiber.New()
+	app.Use(cors.New(cors.Config{
+		AllowOrigins: "http://frontend",
+		Next:         ForwardedOptions(prefixes...),
+	}))
+	app.Options("/templates/*", Options(forward, upstream))
+	app.All("/templates/*", upstream)
+	return app
+}
+
+// TestOptions_Local tests that by default preflights are answered by the CORS middleware
+// and plain OPTIONS requests by the gateway, never reaching the upstream.
+func TestOptions_Local(t *testing.T) {
+	app := newOptionsApp(false)
+
+	req := httptest.NewRequest("OPTIONS", "/templates/1", nil)
+	req.Header.Set("Origin", "http://frontend")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "http://frontend", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	resp, err = app.Test(httptest.NewRequest("OPTIONS", "/templates/1", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	bodyBytes, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Empty(t, bodyBytes)
+}
+
+// TestOptions_Forward tests that preflights are forwarded to the upstream, untouched by
+// the gateway's CORS middleware, when forwarding is enabled for the route.
+func TestOptions_Forward(t *testing.T) {
+	app := newOptionsApp(true)
+
+	req := httptest.NewRequest("OPTIONS", "/templates/1", nil)
+	req.Header.Set("Origin", "http://frontend")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "upstream", string(bodyBytes))
+}
+
+// TestUpstreamCORS tests that an upstream setting its own CORS headers gets duplicate
+// Access-Control-Allow-Origin headers from the gateway, which browsers reject, unless the
+// route is marked as handling CORS upstream.
+func TestUpstreamCORS(t *testing.T) {
+	for _, marked := range []bool{false, true} {
+		var prefixes []string
+		if marked {
+			prefixes = append(prefixes, "/templates/")
+		}
+
+		app := fiber.New()
+		app.Use(cors.New(cors.Config{
+			AllowOrigins: "http://frontend",
+			Next:         UpstreamCORS(prefixes...),
+		}))
+		app.Get("/templates/*", func(c *fiber.Ctx) error {
+			// Added like the proxy copies upstream headers.
+			c.Response().Header.Add(fiber.HeaderAccessControlAllowOrigin, "http://frontend")
+			return c.SendString("upstream")
+		})
+
+		req := httptest.NewRequest("GET", "/templates/1", nil)
+		req.Header.Set("Origin", "http://frontend")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		wantValues := 2
+		if marked {
+			wantValues = 1
+		}
+		assert.Len(t, resp.Header.Values(fiber.HeaderAccessControlAllowOrigin), wantValues)
+	}
+}
+
+// sendBurst sends n requests to app and returns how many were allowed.
+func sendBurst(t *testing.T, app *fiber.App, n int) int {
+	t.Helper()
+	allowed := 0
+	for i := 0; i < n; i++ {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		assert.NoError(t, err)
+		if resp.StatusCode == fiber.StatusOK {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+// TestRateLimiter_BoundaryBurst tests that a full burst right after a window boundary is
+// allowed by the fixed window but throttled by the sliding window.
+func TestRateLimiter_BoundaryBurst(t *testing.T) {
+	tests := []struct {
+		algorithm  string // The rate limiting algorithm.
+		minAllowed int    // Minimum requests allowed in the burst after the boundary.
+		maxAllowed int    // Maximum requests allowed in the burst after the boundary.
+	}{
+		{algorithm: RateLimitFixed, minAllowed: 4, maxAllowed: 4},
+		{algorithm: RateLimitSliding, minAllowed: 0, maxAllowed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			t.Parallel()
+
+			app := fiber.New()
+			app.Use(RateLimiter(tt.algorithm, 4, 2*time.Second))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			assert.Equal(t, 4, sendBurst(t, app, 5))
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			assert.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"error":"too many requests","code":"RATE_LIMITED"}`, string(body))
+
+			time.Sleep(2100 * time.Millisecond)
+			allowed := sendBurst(t, app, 4)
+			assert.GreaterOrEqual(t, allowed, tt.minAllowed)
+			assert.LessOrEqual(t, allowed, tt.maxAllowed)
+		})
+	}
+}
+
+// TestUserRateLimiter tests that authenticated users sharing an IP get independent budgets,
+// while anonymous requests share the budget of their IP.
+func TestUserRateLimiter(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if userID := c.Get("X-Test-User"); userID != "" {
+			c.Locals("user_id", userID)
+		}
+		return c.Next()
+	}, UserRateLimiter(2, time.Minute))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	send := func(userID string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		if userID != "" {
+			req.Header.Set("X-Test-User", userID)
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		userID     string // User of the request, empty for anonymous ones.
+		wantStatus int    // Expected status code.
+	}{
+		{userID: "alice", wantStatus: fiber.StatusOK},
+		{userID: "alice", wantStatus: fiber.StatusOK},
+		{userID: "alice", wantStatus: fiber.StatusTooManyRequests},
+		{userID: "bob", wantStatus: fiber.StatusOK},
+		{userID: "bob", wantStatus: fiber.StatusOK},
+		{userID: "bob", wantStatus: fiber.StatusTooManyRequests},
+		{userID: "", wantStatus: fiber.StatusOK},
+		{userID: "", wantStatus: fiber.StatusOK},
+		{userID: "", wantStatus: fiber.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		assert.Equal(t, tt.wantStatus, send(tt.userID), "request %d of %q", i, tt.userID)
+	}
+}
+
+// TestTokenBucket tests that the token bucket allows a burst up to its capacity and then
+// only lets requests through at the refill rate.
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	app := fiber.New()
+	app.Use(limiter.New(limiter.Config{
+		Max:               4,
+		Expiration:        4 * time.Second,
+		LimiterMiddleware: TokenBucket{now: func() time.Time { return now }},
+	}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	assert.Equal(t, 4, sendBurst(t, app, 6))
+
+	// One token is refilled per second.
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 0, sendBurst(t, app, 1))
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 1, sendBurst(t, app, 4))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// An idle bucket refills up to its capacity only.
+	now = now.Add(time.Minute)
+	assert.Equal(t, 4, sendBurst(t, app, 6))
+}
+
+// TestGatewayTime tests that the X-Gateway-Time header is set in the configured format
+// on both successful and error responses.
+func TestGatewayTime(t *testing.T) {
+	tests := []struct {
+		name   string // Name of the test case.
+		format string // The configured header format.
+		path   string // The requested path.
+	}{
+		{name: "rfc3339", format: GatewayTimeRFC3339, path: "/"},
+		{name: "epoch ms", format: GatewayTimeEpochMS, path: "/"},
+		{name: "error response", format: GatewayTimeRFC3339, path: "/missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(GatewayTime(tt.format))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			before := time.Now().Add(-time.Second)
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			assert.NoError(t, err)
+
+			header := resp.Header.Get("X-Gateway-Time")
+			var got time.Time
+			if tt.format == GatewayTimeEpochMS {
+				ms, err := strconv.ParseInt(header, 10, 64)
+				assert.NoError(t, err)
+				got = time.UnixMilli(ms)
+			} else {
+				got, err = time.Parse(time.RFC3339, header)
+				assert.NoError(t, err)
+			}
+			assert.True(t, got.After(before), "Expected the gateway time to be current")
+		})
+	}
+}
+
+// TestDeprecation tests that deprecation headers are set on the successful and failed
+// responses of configured routes only, and that the migration link keeps upstream links.
+func TestDeprecation(t *testing.T) {
+	deprecated := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	app := fiber.New()
+	app.Use(Deprecation(map[string]DeprecationNotice{
+		"/templates/v1":        {Deprecated: deprecated, Sunset: sunset, Link: "https://docs.example.com/v2"},
+		"/templates/v1/legacy": {Deprecated: deprecated},
+	}))
+	app.Get("/templates/*", func(c *fiber.Ctx) error {
+		if c.Query("fail") != "" {
+			return fiber.ErrBadGateway
+		}
+		c.Set(fiber.HeaderLink, `</templates/v1?page=2>; rel="next"`)
+		return c.SendString("OK")
+	})
+
+	tests := []struct {
+		name        string // Name of the test case.
+		path        string // The requested path.
+		status      int    // Expected status code.
+		deprecation string // Expected Deprecation header.
+		sunset      string // Expected Sunset header.
+		link        string // Expected Link header.
+	}{
+		{
+			name:        "deprecated route",
+			path:        "/templates/v1/42",
+			status:      fiber.StatusOK,
+			deprecation: "@1780272000",
+			sunset:      "Fri, 01 Jan 2027 00:00:00 GMT",
+			link:        `</templates/v1?page=2>; rel="next", <https://docs.example.com/v2>; rel="deprecation"; type="text/html"`,
+		},
+		{
+			name:        "error response",
+			path:        "/templates/v1?fail=1",
+			status:      fiber.StatusBadGateway,
+			deprecation: "@1780272000",
+			sunset:      "Fri, 01 Jan 2027 00:00:00 GMT",
+			link:        `<https://docs.example.com/v2>; rel="deprecation"; type="text/html"`,
+		},
+		{
+			name:        "longest prefix",
+			path:        "/templates/v1/legacy/42",
+			status:      fiber.StatusOK,
+			deprecation: "@1780272000",
+			link:        `</templates/v1?page=2>; rel="next"`,
+		},
+		{
+			name:   "other version",
+			path:   "/templates/v10/42",
+			status: fiber.StatusOK,
+			link:   `</templates/v1?page=2>; rel="next"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.deprecation, resp.Header.Get("Deprecation"))
+			assert.Equal(t, tt.sunset, resp.Header.Get("Sunset"))
+			assert.Equal(t, tt.link, resp.Header.Get(fiber.HeaderLink))
+		})
+	}
+}
+
+// TestGatewayInstance tests that the instance id is set on successful and failed responses,
+// replacing a value sent by the upstream.
+func TestGatewayInstance(t *testing.T) {
+	app := fiber.New()
+	app.Use(GatewayInstance("gateway-7f9c"))
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Set("X-Gateway-Instance", "spoofed")
+		return c.SendString("OK")
+	})
+	app.Get("/error", func(c *fiber.Ctx) error {
+		return fiber.ErrBadGateway
+	})
+
+	for _, path := range []string{"/", "/error"} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, "gateway-7f9c", resp.Header.Get("X-Gateway-Instance"))
+	}
+}
+
+// TestNotFound tests that unrouted requests get a marked JSON 404 while 404s from route
+// handlers pass through unmarked.
+func TestNotFound(t *testing.T) {
+	app := fiber.New()
+	app.Get("/templates/*", func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusNotFound).SendString("template not found")
+	})
+	app.Use(NotFound())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/unknown", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "route_not_found", resp.Header.Get("X-Gateway-Error"))
+	assert.Equal(t, "no-store", resp.Header.Get(fiber.HeaderCacheControl))
+	bodyBytes, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	result, err := parseJSONBody(bodyBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, "route not found", result["error"])
+	assert.Equal(t, errcode.RouteNotFound, result["code"])
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/templates/42", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("X-Gateway-Error"))
+}
+
+// TestUpgradeAllowlist tests that allowlisted upgrades and plain requests pass through
+// while other upgrade protocols are rejected with 400.
+func TestUpgradeAllowlist(t *testing.T) {
+	tests := []struct {
+		name       string // Name of the test case.
+		upgrade    string // Upgrade header sent by the client.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "no upgrade", upgrade: "", wantStatus: fiber.StatusOK},
+		{name: "allowed upgrade", upgrade: "WebSocket", wantStatus: fiber.StatusOK},
+		{name: "disallowed upgrade", upgrade: "h2c", wantStatus: fiber.StatusBadRequest},
+		{name: "disallowed protocol in list", upgrade: "websocket, foo/1.0", wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(UpgradeAllowlist("websocket"))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.upgrade != "" {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", tt.upgrade)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestUpgradeAllowlist_Proxy tests that an allowed upgrade is switched through the proxy:
+// the client gets the upstream's 101 response, and then data flows both ways on the
+// upgraded connection.
+func TestUpgradeAllowlist_Proxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = rw.WriteString("echo: " + line)
+			_ = rw.Flush()
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	app := fiber.New()
+	app.All("/*", UpgradeAllowlist("echo"), proxy.New(upstream.URL, proxy.Options{Name: "test"}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, "GET /socket HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	assert.NoError(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+
+	for _, msg := range []string{"ping\n", "pong\n"} {
+		_, err = io.WriteString(conn, msg)
+		assert.NoError(t, err)
+		line, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "echo: "+msg, line)
+	}
+}
+
+// newFlagsApp builds an app that authenticates every request as userID and echoes the
+// forwarded X-Feature-Flags header.
+func newFlagsApp(flagsURL, userID string) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", userID)
+		return c.Next()
+	})
+	app.Use(FeatureFlags(flagsURL, time.Minute, 100*time.Millisecond))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(c.Get("X-Feature-Flags"))
+	})
+	return app
+}
+
+// TestFeatureFlags_Forwarded tests that the user's flags are forwarded as compact JSON and
+// cached, so repeated requests fetch them only once.
+func TestFeatureFlags_Forwarded(t *testing.T) {
+	var fetches atomic.Int32
+	flags := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		assert.Equal(t, "user123", r.Header.Get("X-User-ID"))
+		_, _ = io.WriteString(w, "{\n  \"new_editor\": true\n}")
+	}))
+	defer flags.Close()
+
+	app := newFlagsApp(flags.URL, "user123")
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Feature-Flags", "spoofed")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"new_editor":true}`, string(bodyBytes))
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+// TestFeatureFlags_FailOpen tests that requests are forwarded without flags when the flags
+// service fails, instead of being blocked.
+func TestFeatureFlags_FailOpen(t *testing.T) {
+	flags := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer flags.Close()
+
+	app := newFlagsApp(flags.URL, "user123")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Feature-Flags", "spoofed")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Empty(t, bodyBytes)
+}
+
+// TestUserAgentFilter tests that blocked user agents are rejected with 403 unless they are
+// allowlisted, and that other user agents pass.
+func TestUserAgentFilter(t *testing.T) {
+	blocked := regexp.MustCompile(`(?i)(?:scraper)|(?:bot/\d+)`)
+	allowed := regexp.MustCompile(`(?i)(?:uptime-bot)`)
+
+	tests := []struct {
+		name       string // Name of the test case.
+		userAgent  string // User-Agent sent by the client.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "regular client", userAgent: "Mozilla/5.0", wantStatus: fiber.StatusOK},
+		{name: "blocked substring", userAgent: "BadScraper 1.0", wantStatus: fiber.StatusForbidden},
+		{name: "blocked regex", userAgent: "evil-bot/2", wantStatus: fiber.StatusForbidden},
+		{name: "allowlisted monitor", userAgent: "uptime-bot/1", wantStatus: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(UserAgentFilter(blocked, allowed))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestMicroCache tests that duplicate GETs within the window are replayed from the cache,
+// while other users and non-GET requests still reach the handler.
+func TestMicroCache(t *testing.T) {
+	var calls atomic.Int32
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", c.Get("X-Test-User"))
+		return c.Next()
+	})
+	app.Use(MicroCache(time.Minute))
+	app.All("/", func(c *fiber.Ctx) error {
+		n := calls.Add(1)
+		return c.SendString(strconv.Itoa(int(n)))
+	})
+
+	send := func(method, user string) (string, string) {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("X-Test-User", user)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return string(body), resp.Header.Get("X-Micro-Cache")
+	}
+
+	body, hit := send("GET", "alice")
+	assert.Equal(t, "1", body)
+	assert.Empty(t, hit)
+
+	body, hit = send("GET", "alice")
+	assert.Equal(t, "1", body)
+	assert.Equal(t, "hit", hit)
+
+	body, _ = send("GET", "bob")
+	assert.Equal(t, "2", body)
+
+	body, _ = send("POST", "alice")
+	assert.Equal(t, "3", body)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+// TestMicroCache_OuterHeaders tests that a cache hit sends the headers written by outer
+// middleware such as CORS and helmet once, and replays every value of the headers the
+// handler wrote.
+func TestMicroCache_OuterHeaders(t *testing.T) {
+	app := fiber.New()
+	app.Use(cors.New(cors.Config{AllowOrigins: "https://app.example.com"}), helmet.New())
+	app.Use(MicroCache(time.Minute))
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Response().Header.Add("Link", "</a.css>; rel=preload")
+		c.Response().Header.Add("Link", "</b.js>; rel=preload")
+		return c.SendString("ok")
+	})
+
+	for _, wantHit := range []string{"", "hit"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, wantHit, resp.Header.Get("X-Micro-Cache"))
+		assert.Equal(t, []string{"https://app.example.com"}, resp.Header.Values("Access-Control-Allow-Origin"))
+		assert.Len(t, resp.Header.Values("X-Frame-Options"), 1)
+		assert.Equal(t, []string{"</a.css>; rel=preload", "</b.js>; rel=preload"}, resp.Header.Values("Link"))
+	}
+}
+
+// TestMicroCache_Uncached tests that anonymous requests carrying credentials, which users
+// behind one IP do not share, and responses with Vary always reach the handler.
+func TestMicroCache_Uncached(t *testing.T) {
+	tests := []struct {
+		name   string // Name of the test case.
+		path   string // Path requested.
+		header string // Request header sent, if any.
+		value  string // Value of the request header.
+	}{
+		{name: "authorization", path: "/", header: "Authorization", value: "Bearer abc"},
+		{name: "cookie", path: "/", header: "Cookie", value: "session=abc"},
+		{name: "vary", path: "/vary"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			app := fiber.New()
+			app.Use(MicroCache(time.Minute))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(strconv.Itoa(int(calls.Add(1))))
+			})
+			app.Get("/vary", func(c *fiber.Ctx) error {
+				c.Vary(fiber.HeaderAcceptEncoding)
+				return c.SendString(strconv.Itoa(int(calls.Add(1))))
+			})
+
+			for _, want := range []string{"1", "2"} {
+				req := httptest.NewRequest("GET", tt.path, nil)
+				if tt.header != "" {
+					req.Header.Set(tt.header, tt.value)
+				}
+				resp, err := app.Test(req)
+				assert.NoError(t, err)
+				body, err := io.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, want, string(body))
+				assert.Empty(t, resp.Header.Get("X-Micro-Cache"))
+			}
+		})
+	}
+}
+
+// TestLogout tests that logout clears the auth cookies with an expiry in the past and the
+// same attributes they were set with, and only clears the refresh token when it was sent.
+func TestLogout(t *testing.T) {
+	app := fiber.New()
+	app.Get("/logout", Logout(AuthCookieConfig{
+		Domain:   "example.com",
+		Path:     "/",
+		SameSite: "None",
+		Secure:   true,
+	}))
+
+	for _, withRefresh := range []bool{false, true} {
+		req := httptest.NewRequest("GET", "/logout", nil)
+		req.Header.Set("Cookie", "access_token=abc")
+		if withRefresh {
+			req.Header.Set("Cookie", "access_token=abc; refresh_token=def")
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+
+		cleared := make(map[string]bool)
+		for _, ck := range resp.Cookies() {
+			cleared[ck.Name] = true
+			assert.Empty(t, ck.Value)
+			assert.True(t, ck.Expires.Before(time.Now()), "cookie %s must be expired", ck.Name)
+			assert.Equal(t, "example.com", ck.Domain)
+			assert.Equal(t, "/", ck.Path)
+			assert.True(t, ck.Secure)
+			assert.True(t, ck.HttpOnly)
+			assert.Equal(t, http.SameSiteNoneMode, ck.SameSite)
+		}
+		assert.True(t, cleared["access_token"])
+		assert.Equal(t, withRefresh, cleared[RefreshTokenCookie])
+	}
+}
+
+// TestHostAllowlist tests that only requests with an allowed Host, including wildcard
+// subdomains, pass, and that missing or other hosts are rejected with 400.
+func TestHostAllowlist(t *testing.T) {
+	app := fiber.New()
+	app.Use(HostAllowlist([]string{"api.example.com", "*.dashboard.io"}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	tests := []struct {
+		name       string // Name of the test case.
+		host       string // Host header sent by the client.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "exact host", host: "api.example.com", wantStatus: fiber.StatusOK},
+		{name: "host with port and case", host: "API.example.com:8080", wantStatus: fiber.StatusOK},
+		{name: "wildcard subdomain", host: "eu.dashboard.io", wantStatus: fiber.StatusOK},
+		{name: "wildcard apex", host: "dashboard.io", wantStatus: fiber.StatusBadRequest},
+		{name: "suffix lookalike", host: "evildashboard.io", wantStatus: fiber.StatusBadRequest},
+		{name: "other host", host: "evil.com", wantStatus: fiber.StatusBadRequest},
+		{name: "missing host", host: "", wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = tt.host
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// fakeSink records emitted audit events.
+type fakeSink struct {
+	events []audit.Event
+}
+
+// Emit records e.
+func (s *fakeSink) Emit(e audit.Event) {
+	s.events = append(s.events, e)
+}
+
+// TestAuditAuthFailures tests that 401 and 403 responses emit audit events and other
+// responses do not.
+func TestAuditAuthFailures(t *testing.T) {
+	sink := &fakeSink{}
+	app := fiber.New()
+	app.Use(AuditAuthFailures(sink))
+	app.Use(RequireAuth(&FakeJWT{}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+	app.Get("/admin", func(c *fiber.Ctx) error {
+		return fiber.ErrForbidden
+	})
+
+	for _, path := range []string{"/", "/admin"} {
+		req := httptest.NewRequest("GET", path+"?token=secret", nil)
+		req.Header.Set("Authorization", "Bearer valid-token")
+		_, err := app.Test(req)
+		assert.NoError(t, err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	_, err := app.Test(req)
+	assert.NoError(t, err)
+
+	if assert.Len(t, sink.events, 2) {
+		assert.Equal(t, audit.TypeAccessDenied, sink.events[0].Type)
+		assert.Equal(t, "/admin", sink.events[0].Path)
+		assert.Equal(t, "user123", sink.events[0].UserID)
+		assert.Equal(t, audit.TypeAuthFailure, sink.events[1].Type)
+		assert.Equal(t, fiber.StatusUnauthorized, sink.events[1].Status)
+	}
+}
+
+// TestRequireNonce tests that fresh nonces pass, while replayed nonces, stale timestamps
+// and missing headers are rejected.
+func TestRequireNonce(t *testing.T) {
+	app := fiber.New()
+	app.Use(RequireNonce(NewMemoryNonceStore(100), time.Minute))
+	app.Post("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name       string // Name of the test case.
+		nonce      string // X-Nonce sent by the client.
+		timestamp  string // X-Timestamp sent by the client.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "fresh nonce", nonce: "n1", timestamp: now, wantStatus: fiber.StatusOK},
+		{name: "replayed nonce", nonce: "n1", timestamp: now, wantStatus: fiber.StatusUnauthorized},
+		{name: "stale timestamp", nonce: "n2", timestamp: stale, wantStatus: fiber.StatusUnauthorized},
+		{name: "missing nonce", timestamp: now, wantStatus: fiber.StatusBadRequest},
+		{name: "malformed timestamp", nonce: "n3", timestamp: "yesterday", wantStatus: fiber.StatusBadRequest},
+	}
+
+	// The cases run in order, the replay relies on the fresh nonce.
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("X-Nonce", tt.nonce)
+		req.Header.Set("X-Timestamp", tt.timestamp)
+		resp, err := app.Test(req)
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.wantStatus, resp.StatusCode, tt.name)
+	}
+}
+
+// TestMemoryNonceStore_Bounded tests that a full store rejects new nonces until old ones expire.
+func TestMemoryNonceStore_Bounded(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryNonceStore(1)
+	store.now = func() time.Time { return now }
+
+	unused, ok := store.Use("a", now.Add(time.Minute))
+	assert.True(t, unused && ok)
+
+	_, ok = store.Use("b", now.Add(time.Minute))
+	assert.False(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	unused, ok = store.Use("b", now.Add(time.Minute))
+	assert.True(t, unused && ok)
+}
+
+// TestEnrich tests that configured attributes are extracted into locals and logged.
+func TestEnrich(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := zerolog.New(&logBuf)
+
+	app := fiber.New()
+	app.Use(RequestLogger(logger), Enrich(map[string]string{
+		"tenant":  "header:X-Tenant-ID",
+		"plan":    "cookie:plan",
+		"region":  "query:region",
+		"host":    "host",
+		"client":  "ip",
+		"unknown": "claim:tenant",
+	}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(Attribute(c, "tenant") + "|" + Attribute(c, "plan") + "|" + Attribute(c, "region") + "|" +
+			Attribute(c, "host") + "|" + Attribute(c, "client") + "|" + Attribute(c, "unknown"))
+	})
+
+	req := httptest.NewRequest("GET", "http://gateway.example.com:8080/?region=eu", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("Cookie", "plan=pro")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme|pro|eu|gateway.example.com|0.0.0.0|", string(body))
+	assert.Contains(t, logBuf.String(), `"attributes":{`)
+	assert.Contains(t, logBuf.String(), `"tenant":"acme"`)
+
+	// Missing values are not set.
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "|||example.com|0.0.0.0|", string(body))
+}
+
+// TestDegradedMode tests that degraded mode is toggled through the admin handler, each
+// change being audited, and announced on every response while it is on.
+func TestDegradedMode(t *testing.T) {
+	degraded := &DegradedMode{}
+	sink := &fakeSink{}
+	app := fiber.New()
+	app.Use(degraded.Handler())
+	app.Put("/admin/degraded", degraded.AdminHandler(sink))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNotFound)
+	})
+
+	put := func(body string) *http.Response {
+		req := httptest.NewRequest("PUT", "/admin/degraded", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("X-Service-Status"))
+
+	resp = put(`{"enabled":true,"message":"PDF exports are delayed"}`)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"enabled":true,"message":"PDF exports are delayed"}`, string(body))
+	if assert.Len(t, sink.events, 1) {
+		assert.Equal(t, audit.TypeAdminAction, sink.events[0].Type)
+		assert.Equal(t, "PUT", sink.events[0].Method)
+		assert.Equal(t, "/admin/degraded", sink.events[0].Path)
+		assert.Equal(t, "0.0.0.0", sink.events[0].IP)
+		assert.Equal(t, map[string]string{"action": "degraded_mode", "enabled": "true", "message": "PDF exports are delayed"}, sink.events[0].Details)
+	}
+
+	for _, path := range []string{"/", "/missing"} {
+		resp, err = app.Test(httptest.NewRequest("GET", path, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, "degraded", resp.Header.Get("X-Service-Status"), path)
+		assert.Equal(t, "PDF exports are delayed", resp.Header.Get("X-Service-Status-Message"), path)
+	}
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	// Messages that cannot be sent as a header are rejected and leave the mode unchanged.
+	resp = put(`{"enabled":true,"message":"line\nbreak"}`)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	enabled, message := degraded.Status()
+	assert.True(t, enabled)
+	assert.Equal(t, "PDF exports are delayed", message)
+	assert.Len(t, sink.events, 1, "Expected no audit event for a rejected change")
+
+	put(`{"enabled":false,"message":"ignored"}`)
+	if assert.Len(t, sink.events, 2) {
+		assert.Equal(t, map[string]string{"action": "degraded_mode", "enabled": "false", "message": ""}, sink.events[1].Details)
+	}
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("X-Service-Status"))
+	assert.Empty(t, resp.Header.Get("X-Service-Status-Message"))
+}
+
+// TestRequireAdminToken tests that admin endpoints require the admin token.
+func TestRequireAdminToken(t *testing.T) {
+	app := fiber.New()
+	app.Get("/admin", RequireAdminToken("admin-secret"), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	tests := []struct {
+		name          string // Name of the test case.
+		authorization string // Authorization header sent.
+		wantStatus    int    // Expected status code.
+	}{
+		{name: "valid token", authorization: "Bearer admin-secret", wantStatus: fiber.StatusOK},
+		{name: "wrong token", authorization: "Bearer user-token", wantStatus: fiber.StatusUnauthorized},
+		{name: "no bearer prefix", authorization: "admin-secret", wantStatus: fiber.StatusUnauthorized},
+		{name: "no token", wantStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestConcurrencyLimiter tests that in-flight requests are capped per user, per IP for
+// anonymous clients, and released when handlers return or panic.
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := NewConcurrencyLimiter(2)
+	release := make(chan struct{})
+
+	app := fiber.New()
+	app.Use(recover.New(), func(c *fiber.Ctx) error {
+		if userID := c.Get("X-Test-User"); userID != "" {
+			c.Locals("user_id", userID)
+		}
+		return c.Next()
+	}, limiter.Handler())
+	app.Get("/wait", func(c *fiber.Ctx) error {
+		<-release
+		return c.SendString("OK")
+	})
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		panic("handler failed")
+	})
+
+	send := func(path, userID string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if userID != "" {
+			req.Header.Set("X-Test-User", userID)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// Two requests of alice and two anonymous ones are held in flight.
+	statuses := make(chan int, 4)
+	for _, userID := range []string{"alice", "alice", "", ""} {
+		go func(userID string) { statuses <- send("/wait", userID) }(userID)
+	}
+	assert.Eventually(t, func() bool {
+		return limiter.InFlight()["user:alice"] == 2 && limiter.InFlight()["ip:0.0.0.0"] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, fiber.StatusTooManyRequests, send("/panic", "alice"))
+	assert.Equal(t, fiber.StatusTooManyRequests, send("/panic", ""))
+	assert.Equal(t, fiber.StatusInternalServerError, send("/panic", "bob"))
+	assert.Equal(t, fiber.StatusInternalServerError, send("/panic", "bob"))
+
+	close(release)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, fiber.StatusOK, <-statuses)
+	}
+	assert.Empty(t, limiter.InFlight())
+}
+
+// TestIPConcurrencyLimiter tests that in-flight requests are capped per client IP taken from
+// the proxy header of trusted proxies, regardless of the user, and that Top lists the IPs
+// with the most requests in flight first.
+func TestIPConcurrencyLimiter(t *testing.T) {
+	limiter := NewIPConcurrencyLimiter(2)
+	release := make(chan struct{})
+
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             fiber.HeaderXForwardedFor,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          []string{"0.0.0.0"},
+		EnableIPValidation:      true,
+	})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", c.Get("X-Test-User"))
+		return c.Next()
+	}, limiter.Handler())
+	app.Get("/wait", func(c *fiber.Ctx) error {
+		<-release
+		return c.SendString("OK")
+	})
+
+	send := func(ip, userID string) int {
+		req := httptest.NewRequest("GET", "/wait", nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, ip)
+		req.Header.Set("X-Test-User", userID)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// Two requests of different users share 203.0.113.1, one comes from 203.0.113.2.
+	statuses := make(chan int, 3)
+	for _, client := range [][2]string{{"203.0.113.1", "alice"}, {"203.0.113.1", "bob"}, {"203.0.113.2", "alice"}} {
+		go func(ip, userID string) { statuses <- send(ip, userID) }(client[0], client[1])
+	}
+	assert.Eventually(t, func() bool {
+		return limiter.InFlight()["ip:203.0.113.1"] == 2 && limiter.InFlight()["ip:203.0.113.2"] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []ClientInFlight{{Client: "ip:203.0.113.1", InFlight: 2}, {Client: "ip:203.0.113.2", InFlight: 1}}, limiter.Top(10))
+	assert.Equal(t, []ClientInFlight{{Client: "ip:203.0.113.1", InFlight: 2}}, limiter.Top(1))
+
+	go func() { statuses <- send("203.0.113.1", "carol") }()
+	assert.Equal(t, fiber.StatusTooManyRequests, <-statuses)
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fiber.StatusOK, <-statuses)
+	}
+	assert.Empty(t, limiter.Top(10))
+}
+
+// TestStatic tests that static routes are served with their content type and cache headers.
+func TestStatic(t *testing.T) {
+	app := fiber.New()
+	app.Get("/config.json", Static([]byte(`{"apiUrl":"/api"}`), fiber.MIMEApplicationJSON, "public, max-age=60"))
+	app.Get("/.well-known/security.txt", Static([]byte("Contact: security@example.com"), fiber.MIMETextPlain, ""))
+
+	tests := []struct {
+		path             string // Path requested.
+		wantBody         string // Expected body.
+		wantContentType  string // Expected Content-Type.
+		wantCacheControl string // Expected Cache-Control.
+	}{
+		{path: "/config.json", wantBody: `{"apiUrl":"/api"}`, wantContentType: fiber.MIMEApplicationJSON, wantCacheControl: "public, max-age=60"},
+		{path: "/.well-known/security.txt", wantBody: "Contact: security@example.com", wantContentType: fiber.MIMETextPlain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.wantContentType, resp.Header.Get(fiber.HeaderContentType))
+			assert.Equal(t, tt.wantCacheControl, resp.Header.Get(fiber.HeaderCacheControl))
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+// TestMetrics tests that requests are counted by method, matched route and status, errors
+// with the status they are rendered with, and that none is left in flight.
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	app := fiber.New()
+	app.Use(Metrics(metrics.NewHTTP(reg)))
+	app.Get("/templates/:id", func(c *fiber.Ctx) error {
+		return c.SendString("template")
+	})
+	app.All("/pdf/*", func(c *fiber.Ctx) error {
+		return fiber.ErrBadGateway
+	})
+	app.Use(NotFound())
+
+	for _, target := range []string{"GET /templates/1", "GET /templates/2", "POST /pdf/render", "GET /unknown"} {
+		method, path, _ := strings.Cut(target, " ")
+		resp, err := app.Test(httptest.NewRequest(method, path, nil))
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	tests := []struct {
+		method string  // Method label.
+		route  string  // Route label.
+		status string  // Status label.
+		want   float64 // Expected request count.
+	}{
+		{method: "GET", route: "/templates/:id", status: "200", want: 2},
+		{method: "POST", route: "/pdf/*", status: "502", want: 1},
+		{method: "GET", route: "/", status: "404", want: 1},
+		{method: "GET", route: "/templates/1", status: "200", want: 0},
+	}
+
+	expected := ""
+	for _, tt := range tests {
+		if tt.want > 0 {
+			expected += fmt.Sprintf("http_requests_total{method=%q,route=%q,status=%q} %v\n", tt.method, tt.route, tt.status, tt.want)
+		}
+	}
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(
+		"# HELP http_requests_total Requests served by the gateway, by method, matched route and status code.\n"+
+			"# TYPE http_requests_total counter\n"+expected), "http_requests_total"))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(
+		"# HELP http_requests_in_flight Requests currently being served by the gateway.\n"+
+			"# TYPE http_requests_in_flight gauge\n"+
+			"http_requests_in_flight 0\n"), "http_requests_in_flight"))
+
+	histograms, err := testutil.GatherAndCount(reg, "http_request_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, histograms)
+}
+
+// TestTracing tests that every request gets a server span continuing the client's trace,
+// that the upstream request carries the traceparent of that span, and that the span records
+// the route and status.
+func TestTracing(t *testing.T) {
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pdf/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = io.WriteString(w, r.Header.Get("traceparent"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name        string     // Name of the test case.
+		path        string     // Path requested.
+		traceparent string     // Traceparent sent by the client.
+		wantStatus  int        // Expected status code of the span.
+		wantCode    codes.Code // Expected status of the span.
+	}{
+		{name: "new trace", path: "/pdf/render", wantStatus: 200, wantCode: codes.Unset},
+		{name: "continued trace", path: "/pdf/render", traceparent: incoming, wantStatus: 200, wantCode: codes.Unset},
+		{name: "upstream error", path: "/pdf/fail", wantStatus: 503, wantCode: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			app := fiber.New()
+			app.Use(Tracing(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+			app.All("/pdf/*", proxy.New(upstream.URL, proxy.Options{Name: "pdf"}))
+
+			req := httptest.NewRequest("POST", tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			forwarded, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			spans := recorder.Ended()
+			if !assert.Len(t, spans, 1) {
+				return
+			}
+			span := spans[0]
+			assert.Equal(t, "POST /pdf/*", span.Name())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.Equal(t, tt.wantCode, span.Status().Code)
+			assert.Subset(t, span.Attributes(), []attribute.KeyValue{
+				attribute.String("http.request.method", "POST"),
+				attribute.String("http.route", "/pdf/*"),
+				attribute.String("url.path", tt.path),
+				attribute.Int("http.response.status_code", tt.wantStatus),
+			})
+
+			// The upstream continues the trace from the gateway's span.
+			sc := span.SpanContext()
+			assert.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01", string(forwarded))
+			if tt.traceparent != "" {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+				assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+			} else {
+				assert.False(t, span.Parent().IsValid())
+			}
+		})
+	}
+}
+
+// TestTracing_Noop tests that with a no-op provider the client's traceparent is forwarded
+// unchanged and none is added when the client sent none.
+func TestTracing_Noop(t *testing.T) {
+	app := fiber.New()
+	app.Use(Tracing(noop.NewTracerProvider()))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(c.Get("traceparent"))
+	})
+
+	for _, traceparent := range []string{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, traceparent, string(body))
+	}
+}
+
+// TestReadiness tests that the readiness probe succeeds only when every service has a
+// reachable replica, and otherwise lists the services that have none.
+func TestReadiness(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(up.Close)
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	tests := []struct {
+		name       string              // Name of the test case.
+		upstreams  map[string][]string // Replica URLs by service.
+		wantStatus int                 // Expected status code.
+		wantBody   string              // Expected JSON body.
+	}{
+		{
+			name:       "all reachable",
+			upstreams:  map[string][]string{"auth": {up.URL}, "templates": {up.URL + "/v1"}},
+			wantStatus: fiber.StatusOK,
+			wantBody:   `{"status":"ready"}`,
+		},
+		{
+			name:       "one replica down",
+			upstreams:  map[string][]string{"auth": {up.URL}, "templates": {down.URL, up.URL}},
+			wantStatus: fiber.StatusOK,
+			wantBody:   `{"status":"ready"}`,
+		},
+		{
+			name:       "services down",
+			upstreams:  map[string][]string{"auth": {up.URL}, "templates": {down.URL}, "pdf": {down.URL, down.URL}},
+			wantStatus: fiber.StatusServiceUnavailable,
+			wantBody:   `{"status":"unavailable","failing":["pdf","templates"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/readyz", Readiness(tt.upstreams, time.Second))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/readyz", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, "no-store", resp.Header.Get(fiber.HeaderCacheControl))
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+// TestDialAddress tests that the port of a replica defaults to that of its scheme.
+func TestDialAddress(t *testing.T) {
+	assert.Equal(t, "pdf:8080", dialAddress("http://pdf:8080/v1"))
+	assert.Equal(t, "pdf:80", dialAddress("http://pdf"))
+	assert.Equal(t, "pdf:443", dialAddress("https://pdf/v1"))
+	assert.Equal(t, "[::1]:80", dialAddress("http://[::1]"))
+}
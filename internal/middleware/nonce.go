@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxNonceLength bounds the size of a nonce kept in the store.
+const maxNonceLength = 128
+
+// NonceStore is an interface that defines a method for recording used nonces.
+type NonceStore interface {
+	// Use records nonce until expires and reports whether it was unused. ok is false
+	// when the store cannot accept more nonces.
+	Use(nonce string, expires time.Time) (unused, ok bool)
+}
+
+// MemoryNonceStore is an in-memory NonceStore holding at most a fixed number of nonces.
+// It is only suitable for a single gateway instance.
+type MemoryNonceStore struct {
+	mu      sync.Mutex
+	max     int
+	nonces  map[string]time.Time
+	now     func() time.Time
+	nextGC  time.Time
+	gcEvery time.Duration
+}
+
+// NewMemoryNonceStore returns an empty MemoryNonceStore.
+//
+// Parameters:
+//   - max: The maximum number of unexpired nonces kept.
+//
+// Returns:
+//   - *MemoryNonceStore: The store.
+func NewMemoryNonceStore(max int) *MemoryNonceStore {
+	return &MemoryNonceStore{
+		max:     max,
+		nonces:  make(map[string]time.Time),
+		now:     time.Now,
+		gcEvery: time.Second,
+	}
+}
+
+// Use implements NonceStore.
+func (s *MemoryNonceStore) Use(nonce string, expires time.Time) (unused, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if exp, seen := s.nonces[nonce]; seen && now.Before(exp) {
+		return false, true
+	}
+
+	if len(s.nonces) >= s.max && !now.Before(s.nextGC) {
+		for k, exp := range s.nonces {
+			if !now.Before(exp) {
+				delete(s.nonces, k)
+			}
+		}
+		s.nextGC = now.Add(s.gcEvery)
+	}
+	if len(s.nonces) >= s.max {
+		return false, false
+	}
+
+	s.nonces[nonce] = expires
+	return true, true
+}
+
+// RequireNonce is a middleware that makes every request single-use. Requests must carry a
+// unique X-Nonce header and an X-Timestamp header (Unix seconds) within window of the
+// gateway's clock. Missing or malformed values are rejected with 400, stale timestamps and
+// nonces seen within the window with 401. When the store is full requests fail closed with 503.
+//
+// Parameters:
+//   - store: Where used nonces are recorded.
+//   - window: The maximum clock difference accepted, and how long nonces are remembered.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RequireNonce(store NonceStore, window time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		nonce := c.Get("X-Nonce")
+		ts, err := strconv.ParseInt(c.Get("X-Timestamp"), 10, 64)
+		if nonce == "" || len(nonce) > maxNonceLength || err != nil {
+			return errcode.JSON(c, fiber.StatusBadRequest, errcode.NonceRequired, "nonce and timestamp required")
+		}
+
+		sent := time.Unix(ts, 0)
+		if d := time.Since(sent); d > window || d < -window {
+			return errcode.JSON(c, fiber.StatusUnauthorized, errcode.StaleRequest, "stale request")
+		}
+
+		// Once its timestamp is stale the request is rejected anyway, so the nonce can be forgotten.
+		unused, ok := store.Use(nonce, sent.Add(window))
+		if !ok {
+			return errcode.JSON(c, fiber.StatusServiceUnavailable, errcode.Unavailable, "nonce store full")
+		}
+		if !unused {
+			return errcode.JSON(c, fiber.StatusUnauthorized, errcode.ReplayedRequest, "replayed request")
+		}
+
+		return c.Next()
+	}
+}
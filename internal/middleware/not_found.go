@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+)
+
+// NotFound returns the handler for requests that match no route. It must be registered
+// after all routes. The X-Gateway-Error header tells clients and monitoring apart a routing
+// problem in the gateway from a 404 returned by an upstream for a missing resource.
+//
+// Returns:
+//   - fiber.Handler: The handler function.
+func NotFound() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("X-Gateway-Error", "route_not_found")
+		return errcode.JSON(c, fiber.StatusNotFound, errcode.RouteNotFound, "route not found")
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Options returns the handler for OPTIONS requests on a proxied route.
+// By default the gateway answers OPTIONS itself (preflights are handled by the CORS
+// middleware before routing, any other OPTIONS request gets 204 No Content).
+// When forward is set, the request is passed to the upstream, which implements its own CORS.
+//
+// Parameters:
+//   - forward: Whether OPTIONS requests are forwarded to the upstream.
+//   - upstream: The proxy handler of the route.
+//
+// Returns:
+//   - fiber.Handler: The OPTIONS handler function.
+func Options(forward bool, upstream fiber.Handler) fiber.Handler {
+	if forward {
+		return upstream
+	}
+	return func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// ForwardedOptions returns a predicate for the CORS middleware's Next field that skips
+// the middleware for OPTIONS requests whose path starts with one of the given prefixes,
+// so those preflights reach the upstream instead of being answered by the gateway.
+//
+// Parameters:
+//   - prefixes: The route prefixes whose OPTIONS requests are forwarded.
+//
+// Returns:
+//   - func(*fiber.Ctx) bool: The predicate, true when the CORS middleware should be skipped.
+func ForwardedOptions(prefixes ...string) func(*fiber.Ctx) bool {
+	return func(c *fiber.Ctx) bool {
+		if c.Method() != fiber.MethodOptions {
+			return false
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(c.Path(), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// UpstreamCORS returns a predicate for the CORS middleware's Next field that skips the
+// middleware for every request whose path starts with one of the given prefixes. Upstreams
+// behind those prefixes set their own CORS headers, and adding the gateway's as well would
+// produce duplicate Access-Control-Allow-Origin headers that browsers reject.
+//
+// Parameters:
+//   - prefixes: The route prefixes whose upstreams handle CORS.
+//
+// Returns:
+//   - func(*fiber.Ctx) bool: The predicate, true when the CORS middleware should be skipped.
+func UpstreamCORS(prefixes ...string) func(*fiber.Ctx) bool {
+	return func(c *fiber.Ctx) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(c.Path(), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
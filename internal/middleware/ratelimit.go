@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// Rate limiting algorithms accepted by RateLimiter.
+const (
+	RateLimitFixed       = "fixed"       // Fixed window counter (allows bursts across window boundaries).
+	RateLimitSliding     = "sliding"     // Sliding window weighted by the previous window's count.
+	RateLimitTokenBucket = "tokenbucket" // Token bucket refilled evenly over the window.
+)
+
+// RateLimiter returns a rate limiting middleware that allows max requests per window
+// for each client IP, counted with the given algorithm. Unknown algorithms fall back
+// to the fixed window.
+//
+// Parameters:
+//   - algorithm: One of RateLimitFixed, RateLimitSliding or RateLimitTokenBucket.
+//   - max: The number of requests allowed per window.
+//   - window: The length of the window.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RateLimiter(algorithm string, max int, window time.Duration) fiber.Handler {
+	return newRateLimiter(algorithm, max, window, func(c *fiber.Ctx) string { return c.IP() })
+}
+
+// UserRateLimiter returns a fixed window rate limiting middleware that allows max requests
+// per window for each authenticated user, so users sharing an IP behind a NAT get their own
+// budget. Anonymous requests are counted per client IP. It must run after RequireAuth.
+//
+// Parameters:
+//   - max: The number of requests allowed per window.
+//   - window: The length of the window.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func UserRateLimiter(max int, window time.Duration) fiber.Handler {
+	return newRateLimiter(RateLimitFixed, max, window, clientKey)
+}
+
+// newRateLimiter returns a rate limiting middleware counting requests by the key of key.
+func newRateLimiter(algorithm string, max int, window time.Duration, key func(c *fiber.Ctx) string) fiber.Handler {
+	var handler limiter.LimiterHandler
+	switch algorithm {
+	case RateLimitSliding:
+		handler = limiter.SlidingWindow{}
+	case RateLimitTokenBucket:
+		handler = TokenBucket{}
+	default:
+		handler = limiter.FixedWindow{}
+	}
+
+	return limiter.New(limiter.Config{
+		Max:               max,
+		Expiration:        window,
+		KeyGenerator:      key,
+		LimiterMiddleware: handler,
+		LimitReached: func(c *fiber.Ctx) error {
+			return errcode.JSON(c, fiber.StatusTooManyRequests, errcode.RateLimited, "too many requests")
+		},
+	})
+}
+
+// TokenBucket is a limiter.LimiterHandler that gives each key a bucket of Max tokens
+// refilled evenly over Expiration. Bursts are bounded by the bucket size and the
+// sustained rate never exceeds Max per Expiration.
+type TokenBucket struct {
+	now func() time.Time // Clock used for refills; time.Now when nil.
+}
+
+// bucket holds the state of a single key.
+type bucket struct {
+	tokens float64   // Tokens currently available.
+	last   time.Time // Time of the last refill.
+}
+
+// New creates a new token bucket middleware handler.
+func (tb TokenBucket) New(cfg limiter.Config) fiber.Handler {
+	now := tb.now
+	if now == nil {
+		now = time.Now
+	}
+
+	var (
+		mu        sync.Mutex
+		buckets   = make(map[string]*bucket)
+		capacity  = float64(cfg.Max)
+		perSecond = capacity / cfg.Expiration.Seconds()
+		lastSweep = now()
+	)
+
+	return func(c *fiber.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		key := cfg.KeyGenerator(c)
+
+		mu.Lock()
+		t := now()
+
+		// Buckets idle for a whole window are full again, so they can be dropped.
+		if t.Sub(lastSweep) > cfg.Expiration {
+			for k, b := range buckets {
+				if t.Sub(b.last) > cfg.Expiration {
+					delete(buckets, k)
+				}
+			}
+			lastSweep = t
+		}
+
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{tokens: capacity, last: t}
+			buckets[key] = b
+		}
+		b.tokens = math.Min(capacity, b.tokens+t.Sub(b.last).Seconds()*perSecond)
+		b.last = t
+
+		allowed := b.tokens >= 1
+		if allowed {
+			b.tokens--
+		}
+		remaining := int(b.tokens)
+		retryAfter := math.Ceil((1 - b.tokens) / perSecond)
+		mu.Unlock()
+
+		if !allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retryAfter)))
+			return cfg.LimitReached(c)
+		}
+
+		err := c.Next()
+
+		// Give the token back for requests that should not be counted.
+		if (cfg.SkipSuccessfulRequests && c.Response().StatusCode() < fiber.StatusBadRequest) ||
+			(cfg.SkipFailedRequests && c.Response().StatusCode() >= fiber.StatusBadRequest) {
+			mu.Lock()
+			b.tokens = math.Min(capacity, b.tokens+1)
+			remaining = int(b.tokens)
+			mu.Unlock()
+		}
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(cfg.Max))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+		return err
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Readiness returns the handler of the readiness probe. It opens a TCP connection to every
+// replica of every upstream service concurrently, and responds 200 {"status":"ready"} when
+// each service has at least one reachable replica, or 503 {"status":"unavailable","failing":[...]}
+// listing the services that have none. Unlike the liveness probe, it tells an orchestrator
+// to stop routing traffic to a gateway whose upstreams are all down.
+//
+// Parameters:
+//   - upstreams: The replica URLs of each upstream service, by service name.
+//   - timeout: How long connecting to a replica may take.
+//
+// Returns:
+//   - fiber.Handler: The handler function.
+func Readiness(upstreams map[string][]string, timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var (
+			mu        sync.Mutex
+			reachable = make(map[string]bool, len(upstreams))
+			wg        sync.WaitGroup
+		)
+		dialer := &net.Dialer{}
+		for name, targets := range upstreams {
+			for _, target := range targets {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					conn, err := dialer.DialContext(ctx, "tcp", dialAddress(target))
+					if err != nil {
+						return
+					}
+					_ = conn.Close()
+					mu.Lock()
+					reachable[name] = true
+					mu.Unlock()
+				}()
+			}
+		}
+		wg.Wait()
+
+		failing := []string{}
+		for name := range upstreams {
+			if !reachable[name] {
+				failing = append(failing, name)
+			}
+		}
+		slices.Sort(failing)
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		if len(failing) > 0 {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "unavailable", "failing": failing})
+		}
+		return c.JSON(fiber.Map{"status": "ready"})
+	}
+}
+
+// dialAddress returns the host and port a connection to target is opened to, the port
+// defaulting to that of its scheme.
+func dialAddress(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
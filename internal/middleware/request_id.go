@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header carrying the id that correlates a request across the
+// gateway and the upstreams.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the size of a request id accepted from the client.
+const maxRequestIDLength = 128
+
+// RequestID is a middleware that gives every request a correlation id. It reuses the
+// X-Request-ID sent by the client or an edge proxy, or generates a UUIDv4 when there is
+// none or it is not a printable single-line value of at most 128 bytes. The id is stored in
+// the context under "request_id", forwarded to upstreams and echoed on the response,
+// errors included. It should be registered before RequestLogger, which logs the id.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID := c.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength || !headerSafe(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Locals("request_id", requestID)
+		c.Request().Header.Set(RequestIDHeader, requestID)
+
+		err := c.Next()
+
+		// Set after the upstream response has been copied, so it always carries the gateway's id.
+		c.Set(RequestIDHeader, requestID)
+		return err
+	}
+}
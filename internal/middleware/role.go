@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RoleClaim is the token claim RequireRole reads the user's roles from. It holds a single
+// role or a list of roles.
+const RoleClaim = "role"
+
+// RequireRole is a middleware that rejects requests with 403 Forbidden unless the
+// authenticated user has one of the given roles. It reads the role claim stored by
+// RequireAuth, so it must run after it; tokens without the claim are rejected.
+//
+// Parameters:
+//   - roles: The roles allowed to make the request.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, _ := c.Locals("claims").(map[string]any)
+		for _, role := range claimRoles(claims[RoleClaim]) {
+			if slices.Contains(roles, role) {
+				return c.Next()
+			}
+		}
+		return errcode.JSON(c, fiber.StatusForbidden, errcode.Forbidden, "insufficient role")
+	}
+}
+
+// claimRoles returns the roles of a role claim holding a string or a list of strings.
+func claimRoles(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		roles := make([]string, 0, len(v))
+		for _, item := range v {
+			if role, ok := item.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	default:
+		return nil
+	}
+}
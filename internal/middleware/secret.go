@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxSecretSize bounds the size of a secret read from a SecretSource.
+const maxSecretSize = 4 << 10
+
+// SecretSource reads the current secret. It returns a nil secret and no error when the
+// secret is known not to have changed since the previous read.
+type SecretSource func(ctx context.Context) ([]byte, error)
+
+// RotatingSecret holds the HMAC secret of a JWTObj, which can be replaced while the gateway
+// is running. The previous secret stays valid for a grace window after a rotation, so tokens
+// issued just before it keep validating. It is safe for concurrent use.
+type RotatingSecret struct {
+	mu            sync.RWMutex
+	current       []byte
+	previous      []byte
+	previousUntil time.Time
+	grace         time.Duration
+}
+
+// NewRotatingSecret returns a RotatingSecret starting with secret.
+//
+// Parameters:
+//   - secret: The initial secret.
+//   - grace: How long the previous secret stays valid after a rotation.
+//
+// Returns:
+//   - *RotatingSecret: The rotating secret.
+func NewRotatingSecret(secret []byte, grace time.Duration) *RotatingSecret {
+	return &RotatingSecret{current: secret, grace: grace}
+}
+
+// Set makes secret the current secret. The replaced secret stays valid for the grace window;
+// setting the current secret again changes nothing.
+//
+// Parameters:
+//   - secret: The new secret.
+func (s *RotatingSecret) Set(secret []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bytes.Equal(secret, s.current) {
+		return
+	}
+	s.previous = s.current
+	s.previousUntil = time.Now().Add(s.grace)
+	s.current = secret
+}
+
+// Secrets returns the secrets tokens are accepted with: the current one, followed by the
+// previous one during its grace window.
+//
+// Returns:
+//   - [][]byte: The valid secrets.
+func (s *RotatingSecret) Secrets() [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.previous != nil && time.Now().Before(s.previousUntil) {
+		return [][]byte{s.current, s.previous}
+	}
+	return [][]byte{s.current}
+}
+
+// Refresh reads the secret from source right away and then every interval, and rotates to
+// it when it changed, until ctx is done. Failed reads are logged and the current secret is kept.
+//
+// Parameters:
+//   - ctx: Stops the refresh when done.
+//   - interval: The time between two reads.
+//   - source: Where the secret is read from.
+func (s *RotatingSecret) Refresh(ctx context.Context, interval time.Duration, source SecretSource) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		secret, err := source(ctx)
+		switch {
+		case err != nil:
+			log.Warn().Err(err).Msg("Failed to refresh JWT secret")
+		case secret != nil:
+			s.Set(secret)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// FileSecretSource returns a SecretSource reading the secret from the file at path,
+// with surrounding whitespace removed. The file is only read again when its modification
+// time changes.
+//
+// Parameters:
+//   - path: The path of the secret file.
+//
+// Returns:
+//   - SecretSource: The source.
+func FileSecretSource(path string) SecretSource {
+	var modTime time.Time
+	return func(ctx context.Context) ([]byte, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Equal(modTime) {
+			return nil, nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := trimSecret(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		modTime = info.ModTime()
+		return secret, nil
+	}
+}
+
+// HTTPSecretSource returns a SecretSource reading the secret from the plain text body of
+// a GET request to url, with surrounding whitespace removed.
+//
+// Parameters:
+//   - url: The endpoint of the secrets provider.
+//   - timeout: The maximum time spent waiting for the provider.
+//   - limiter: Bounds the request together with other dependency calls (nil for no bound).
+//
+// Returns:
+//   - SecretSource: The source.
+func HTTPSecretSource(url string, timeout time.Duration, limiter *DependencyLimiter) SecretSource {
+	client := &http.Client{Timeout: timeout}
+	return func(ctx context.Context) ([]byte, error) {
+		release, err := limiter.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("secrets provider returned status %d", resp.StatusCode)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxSecretSize+1))
+		if err != nil {
+			return nil, err
+		}
+		return trimSecret(data)
+	}
+}
+
+// trimSecret removes the whitespace around a secret read from a source and rejects
+// empty and oversized secrets.
+func trimSecret(data []byte) ([]byte, error) {
+	if len(data) > maxSecretSize {
+		return nil, errors.New("secret too large")
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+	return secret, nil
+}
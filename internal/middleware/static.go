@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Static returns a handler serving a fixed response owned by the gateway, e.g. the
+// frontend's runtime config, without proxying.
+//
+// Parameters:
+//   - body: The response body.
+//   - contentType: The Content-Type of the body.
+//   - cacheControl: The Cache-Control header; empty sends none.
+//
+// Returns:
+//   - fiber.Handler: The handler function.
+func Static(body []byte, contentType, cacheControl string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if cacheControl != "" {
+			c.Set(fiber.HeaderCacheControl, cacheControl)
+		}
+		c.Set(fiber.HeaderContentType, contentType)
+		return c.Send(body)
+	}
+}
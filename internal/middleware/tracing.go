@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the instrumentation the spans of the gateway are created by.
+const tracerName = "github.com/dashboard-platform/api-gateway"
+
+// Tracing is a middleware that starts a server span for every request, continuing the trace
+// of the W3C traceparent header sent by the client, if any. The traceparent of the span
+// replaces that header on the request, so upstreams continue the trace from the gateway. The
+// span is named after the method and matched route (e.g. "GET /templates/:id") and records
+// them, the path and the response status; 5xx responses mark it as failed. The span ends
+// once the response headers are written, before a streamed body. With a no-op provider the
+// incoming traceparent is forwarded unchanged.
+//
+// Parameters:
+//   - provider: The tracer provider the spans are created with.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func Tracing(provider trace.TracerProvider) fiber.Handler {
+	tracer := provider.Tracer(tracerName)
+	propagator := propagation.TraceContext{}
+
+	return func(c *fiber.Ctx) error {
+		carrier := headerCarrier{&c.Request().Header}
+		// Copied as fasthttp reuses its buffers, while the span keeps the attributes.
+		method := utils.CopyString(c.Method())
+
+		ctx := propagator.Extract(c.UserContext(), carrier)
+		ctx, span := tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.URLPath(utils.CopyString(c.Path())),
+			))
+		defer span.End()
+
+		// The proxy forwards the request headers and derives the upstream request from the
+		// user context, so both carry the span.
+		propagator.Inject(ctx, carrier)
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		// The response of an error that is still to be rendered does not carry its status yet.
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+		}
+
+		route := c.Route().Path
+		span.SetName(method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, utils.StatusMessage(status))
+		}
+		return err
+	}
+}
+
+// headerCarrier adapts the request headers to a propagation.TextMapCarrier.
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (h headerCarrier) Get(key string) string {
+	return string(h.header.Peek(key))
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h.header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	var keys []string
+	h.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+)
+
+// UpgradeAllowlist is a middleware that rejects protocol upgrade requests with 400
+// unless every protocol in the Upgrade header is in the allowlist (case-insensitive).
+// Requests without an Upgrade header are passed through unchanged.
+//
+// Parameters:
+//   - protocols: The permitted Upgrade values (e.g. "websocket").
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func UpgradeAllowlist(protocols ...string) fiber.Handler {
+	allowed := make(map[string]struct{}, len(protocols))
+	for _, p := range protocols {
+		allowed[strings.ToLower(strings.TrimSpace(p))] = struct{}{}
+	}
+
+	return func(c *fiber.Ctx) error {
+		upgrade := c.Get(fiber.HeaderUpgrade)
+		if upgrade == "" {
+			return c.Next()
+		}
+
+		// The header may list several protocols, e.g. "websocket, h2c".
+		for _, p := range strings.Split(upgrade, ",") {
+			// Ignore the version suffix, e.g. "HTTP/2.0".
+			name, _, _ := strings.Cut(strings.TrimSpace(p), "/")
+			if _, ok := allowed[strings.ToLower(name)]; !ok {
+				return errcode.JSON(c, fiber.StatusBadRequest, errcode.UpgradeNotAllowed, "upgrade not allowed")
+			}
+		}
+
+		return c.Next()
+	}
+}
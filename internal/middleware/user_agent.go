@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// UserAgentFilter is a middleware that rejects requests whose User-Agent matches blocked
+// with 403, unless it also matches allowed (e.g. our own monitoring agents).
+// Either pattern may be nil. Blocked requests are logged for audit.
+//
+// Parameters:
+//   - blocked: The pattern of user agents to reject.
+//   - allowed: The pattern of user agents that are never rejected.
+//
+// Returns:
+//   - fiber.Handler: The middleware handler function.
+func UserAgentFilter(blocked, allowed *regexp.Regexp) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if blocked == nil {
+			return c.Next()
+		}
+
+		ua := c.Get(fiber.HeaderUserAgent)
+		match := blocked.FindString(ua)
+		if match == "" || (allowed != nil && allowed.MatchString(ua)) {
+			return c.Next()
+		}
+
+		log.Warn().
+			Str("user_agent", ua).
+			Str("match", match).
+			Str("ip", c.IP()).
+			Str("path", c.Path()).
+			Msg("Blocked request from denied user agent")
+
+		return errcode.JSON(c, fiber.StatusForbidden, errcode.Forbidden, "forbidden")
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultHealthCheckPath is the path replicas are probed on when Options does not set it.
+const defaultHealthCheckPath = "/healthcheck"
+
+// defaultUnhealthyThreshold is the number of consecutive failed probes after which a replica
+// is taken out of rotation when Options does not set it.
+const defaultUnhealthyThreshold = 3
+
+// balancer distributes requests round-robin across the healthy replicas of an upstream. It
+// is safe for concurrent use.
+type balancer struct {
+	replicas []*url.URL
+	healthy  []atomic.Bool // Whether each replica receives requests, updated by checkHealth.
+	next     atomic.Uint64
+}
+
+// newBalancer returns a balancer over the replica URLs, all of them healthy. Only their
+// scheme and host are used.
+func newBalancer(targets []string) (*balancer, error) {
+	b := &balancer{healthy: make([]atomic.Bool, len(targets))}
+	for i, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		b.replicas = append(b.replicas, u)
+		b.healthy[i].Store(true)
+	}
+	return b, nil
+}
+
+// pick returns the replica the next request is sent to, taking the healthy ones in turn.
+// When none is healthy, all of them are used in turn anyway, so requests fail as they would
+// without health checks rather than all being rejected.
+func (b *balancer) pick() *url.URL {
+	n := b.next.Add(1) - 1
+	healthy := make([]*url.URL, 0, len(b.replicas))
+	for i, replica := range b.replicas {
+		if b.healthy[i].Load() {
+			healthy = append(healthy, replica)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = b.replicas
+	}
+	return healthy[n%uint64(len(healthy))]
+}
+
+// checkHealth probes all replicas of the named upstream every interval until ctx is done.
+// A replica is taken out of rotation after threshold consecutive failed probes and put back
+// after a successful one.
+func (b *balancer) checkHealth(ctx context.Context, name string, client *http.Client, path string, interval time.Duration, threshold int) {
+	failures := make([]int, len(b.replicas))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for i, replica := range b.replicas {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok := probe(ctx, client, replica, path, interval)
+				// A probe cut short by the shutdown says nothing about the replica.
+				if ctx.Err() != nil {
+					return
+				}
+				b.record(name, i, ok, &failures[i], threshold)
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// record updates the health of replica i from the outcome of a probe, failures being its
+// count of consecutive failed probes.
+func (b *balancer) record(name string, i int, ok bool, failures *int, threshold int) {
+	if ok {
+		*failures = 0
+		if !b.healthy[i].Swap(true) {
+			log.Info().Str("service", name).Str("replica", b.replicas[i].Host).Msg("Upstream replica recovered, back in rotation")
+		}
+		return
+	}
+	*failures++
+	if *failures >= threshold && b.healthy[i].Swap(false) {
+		log.Warn().
+			Str("service", name).
+			Str("replica", b.replicas[i].Host).
+			Int("failures", *failures).
+			Msg("Upstream replica failed its health checks, taken out of rotation")
+	}
+}
+
+// probe reports whether the replica answers a GET of path with a 2xx within timeout.
+func probe(ctx context.Context, client *http.Client, replica *url.URL, path string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	u := url.URL{Scheme: replica.Scheme, Host: replica.Host, Path: path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	// Drained so the connection can be reused by the next probe.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultBreakerCooldown is the time an open breaker rejects requests when Options does not set it.
+const defaultBreakerCooldown = 30 * time.Second
+
+// States of a breaker.
+const (
+	breakerClosed   = iota // Requests are forwarded and failures counted.
+	breakerOpen            // Requests are rejected until the cooldown has passed.
+	breakerHalfOpen        // A single trial request is forwarded to probe the upstream.
+)
+
+// breaker is a circuit breaker around an upstream. It opens after threshold consecutive
+// failures, rejects requests for the cooldown, and then lets a single trial request through
+// whose outcome closes or opens it again. It is safe for concurrent use.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    int
+	failures int       // Consecutive failures while closed.
+	openedAt time.Time // Time the breaker last opened.
+	trial    bool      // Whether the trial request of the half-open breaker is in flight.
+}
+
+// newBreaker returns a closed breaker for the upstream with the given name.
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &breaker{name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a request may be forwarded, and otherwise how long the breaker
+// stays open. Every allowed request must be followed by a call to done or abandon.
+func (b *breaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openedAt.Add(b.cooldown).Sub(b.now()); wait > 0 {
+			return false, wait
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		log.Info().Str("service", b.name).Msg("Circuit breaker half-open, sending a trial request")
+		return true, 0
+	case breakerHalfOpen:
+		if b.trial {
+			return false, 0
+		}
+		b.trial = true
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+// done records whether an allowed request failed.
+func (b *breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == breakerHalfOpen && failed:
+		b.trial = false
+		b.open()
+	case b.state == breakerHalfOpen:
+		b.trial = false
+		b.state = breakerClosed
+		b.failures = 0
+		log.Info().Str("service", b.name).Msg("Circuit breaker closed, the upstream recovered")
+	case b.state == breakerClosed && failed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	case b.state == breakerClosed:
+		b.failures = 0
+	}
+}
+
+// abandon records that an allowed request neither succeeded nor failed, e.g. because the
+// client went away, so that a half-open breaker can send another trial request.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// open opens the breaker. b.mu must be held.
+func (b *breaker) open() {
+	b.state = breakerOpen
+	b.openedAt = b.now()
+	b.failures = 0
+	log.Warn().
+		Str("service", b.name).
+		Dur("cooldown", b.cooldown).
+		Msg("Circuit breaker opened, rejecting upstream requests")
+}
@@ -1,27 +1,109 @@
 package proxy
 
 import (
+	"bufio"
+	"context"
+	"mime"
+	"net"
 	"net/http"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// responseRecorder is the http.ResponseWriter handed to the reverse proxy.
+// Regular responses are written straight into the Fiber response. Event streams
+// are handed over chunk by chunk so they can be flushed to the client as they arrive.
+// Protocol upgrades are relayed through a pipe, see Hijack.
 type responseRecorder struct {
-	ctx *fiber.Ctx
+	ctx         *fiber.Ctx
+	state       *requestState
+	header      http.Header
+	wroteHeader bool
+	streaming   bool
+	headerDone  chan struct{}   // Closed once the status and headers have been written.
+	chunks      chan []byte     // Body chunks of a streamed response.
+	done        <-chan struct{} // Closed when the client is gone and writes should stop.
+	hijacked    chan net.Conn   // Receives the handler's end of the pipe of a protocol upgrade.
+}
+
+// newResponseRecorder returns a responseRecorder writing to c until done is closed.
+func newResponseRecorder(c *fiber.Ctx, state *requestState, done <-chan struct{}) *responseRecorder {
+	return &responseRecorder{
+		ctx:        c,
+		state:      state,
+		header:     make(http.Header),
+		headerDone: make(chan struct{}),
+		chunks:     make(chan []byte),
+		done:       done,
+		hijacked:   make(chan net.Conn, 1),
+	}
 }
 
 func (r *responseRecorder) Header() http.Header {
-	h := make(http.Header)
-	r.ctx.Response().Header.VisitAll(func(k, v []byte) {
-		h.Set(string(k), string(v))
-	})
-	return h
+	return r.header
 }
 
 func (r *responseRecorder) Write(b []byte) (int, error) {
-	return r.ctx.Write(b)
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.streaming {
+		return r.ctx.Write(b)
+	}
+
+	// The reverse proxy reuses its buffer, so the chunk must be copied.
+	chunk := append([]byte(nil), b...)
+	select {
+	case r.chunks <- chunk:
+		return len(b), nil
+	case <-r.done:
+		return 0, context.Canceled
+	}
 }
 
 func (r *responseRecorder) WriteHeader(statusCode int) {
+	// Informational responses (e.g. 103 Early Hints) are not relayed.
+	if r.wroteHeader || (statusCode < http.StatusOK && statusCode != http.StatusSwitchingProtocols) {
+		return
+	}
+	r.wroteHeader = true
+
 	r.ctx.Status(statusCode)
+	h := &r.ctx.Response().Header
+	for k, vv := range r.header {
+		raw, verbatim := r.state.rawNames[k]
+		if verbatim {
+			h.DisableNormalizing()
+			k = raw
+		}
+		for _, v := range vv {
+			h.Add(k, v)
+		}
+		if verbatim && !r.ctx.App().Config().DisableHeaderNormalizing {
+			h.EnableNormalizing()
+		}
+	}
+	r.streaming = isEventStream(r.header.Get(fiber.HeaderContentType))
+	close(r.headerDone)
+}
+
+// Flush implements http.Flusher. Streamed chunks are flushed by the body stream
+// writer as soon as they are received, so there is nothing left to do here.
+func (r *responseRecorder) Flush() {}
+
+// Hijack implements http.Hijacker, which the reverse proxy switches protocols with after
+// a 101 response. The client connection is only handed over by fasthttp once the handler
+// has returned, so the reverse proxy gets one end of a pipe instead, and the handler the
+// other end to connect to the client connection. The 101 response itself is written by
+// the reverse proxy through the pipe.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	proxyEnd, handlerEnd := net.Pipe()
+	r.hijacked <- handlerEnd
+	return proxyEnd, bufio.NewReadWriter(bufio.NewReader(proxyEnd), bufio.NewWriter(proxyEnd)), nil
+}
+
+// isEventStream reports whether contentType is a server-sent events stream.
+func isEventStream(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/event-stream"
 }
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers carrying the deadline of a request set by an edge proxy or client.
+const (
+	deadlineHeader    = "X-Deadline"   // Absolute deadline in Unix milliseconds.
+	grpcTimeoutHeader = "Grpc-Timeout" // Relative timeout, e.g. "250m" (see the gRPC over HTTP/2 protocol).
+)
+
+// grpcTimeoutUnits maps the unit suffixes of Grpc-Timeout to durations.
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// requestDeadline returns the deadline announced by the request headers, preferring
+// X-Deadline over Grpc-Timeout, relative to now. ok is false when neither is valid.
+func requestDeadline(h http.Header, now time.Time) (deadline time.Time, ok bool) {
+	if ms, err := strconv.ParseInt(h.Get(deadlineHeader), 10, 64); err == nil {
+		return time.UnixMilli(ms), true
+	}
+
+	// At most 8 digits followed by a unit.
+	v := h.Get(grpcTimeoutHeader)
+	if len(v) < 2 || len(v) > 9 {
+		return time.Time{}, false
+	}
+	unit, known := grpcTimeoutUnits[v[len(v)-1]]
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if !known || err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	return now.Add(time.Duration(n) * unit), true
+}
+
+// setRequestDeadline replaces the deadline headers of h with the remaining deadline.
+// Only the headers the client sent are rewritten.
+func setRequestDeadline(h http.Header, deadline, now time.Time) {
+	if h.Get(deadlineHeader) != "" {
+		h.Set(deadlineHeader, strconv.FormatInt(deadline.UnixMilli(), 10))
+	}
+	if h.Get(grpcTimeoutHeader) != "" {
+		h.Set(grpcTimeoutHeader, strconv.FormatInt(deadline.Sub(now).Milliseconds(), 10)+"m")
+	}
+}
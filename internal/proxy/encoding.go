@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errDecodedTooLarge is returned when a compressed body expands beyond maxTransformSize.
+var errDecodedTooLarge = errors.New("decoded body too large to transform")
+
+// transformableEncoding reports whether bodies with the Content-Encoding encoding can be
+// decoded for transformation.
+func transformableEncoding(encoding string) bool {
+	switch strings.ToLower(encoding) {
+	case "", "gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+// decodeBody decompresses body according to its Content-Encoding. Bodies expanding beyond
+// maxTransformSize are rejected, so a small compressed body cannot exhaust memory.
+//
+// Parameters:
+//   - body: The encoded body.
+//   - encoding: The Content-Encoding of the body; empty when it is not compressed.
+//
+// Returns:
+//   - []byte: The decoded body.
+//   - error: An error if the body cannot be decoded or is too large.
+func decodeBody(body []byte, encoding string) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(encoding) {
+	case "":
+		return body, nil
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(r, maxTransformSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > maxTransformSize {
+		return nil, errDecodedTooLarge
+	}
+	return decoded, nil
+}
+
+// encodeBody compresses body according to a Content-Encoding supported by decodeBody.
+//
+// Parameters:
+//   - body: The body to encode.
+//   - encoding: The Content-Encoding to apply; empty leaves the body as is.
+//
+// Returns:
+//   - []byte: The encoded body.
+//   - error: An error if the body cannot be encoded.
+func encodeBody(body []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch strings.ToLower(encoding) {
+	case "":
+		return body, nil
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
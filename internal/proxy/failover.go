@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// failoverCooldown is how long a failed target is skipped before it is tried again.
+const failoverCooldown = 10 * time.Second
+
+// failoverTarget is an upstream the failover transport can send requests to.
+type failoverTarget struct {
+	url *url.URL // Nil for the primary target, the replica the request was sent to.
+
+	mu        sync.Mutex
+	downUntil time.Time // The target is skipped until then after a failure.
+}
+
+// available reports whether the target is not cooling down after a failure.
+func (t *failoverTarget) available(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !now.Before(t.downUntil)
+}
+
+// markDown skips the target for failoverCooldown.
+func (t *failoverTarget) markDown(now time.Time) {
+	t.mu.Lock()
+	t.downUntil = now.Add(failoverCooldown)
+	t.mu.Unlock()
+}
+
+// failoverTransport sends idempotent requests to an ordered list of targets, moving on to
+// the next one when a target cannot be reached or answers 502, 503 or 504. Failed targets
+// are skipped for a while, unless every target has failed. Other requests only go to the
+// first available target, as they may have had side effects upstream.
+// The host of the target that served a request is returned in the X-Gateway-Upstream header.
+type failoverTransport struct {
+	next    http.RoundTripper
+	targets []*failoverTarget
+	now     func() time.Time
+}
+
+// newFailoverTransport returns a failoverTransport over the primary target of each request
+// and the fallback URLs. Only the scheme and host of the fallback URLs are used. The replicas
+// of a balanced upstream together are the primary target.
+func newFailoverTransport(next http.RoundTripper, fallbacks []string) (*failoverTransport, error) {
+	t := &failoverTransport{next: next, now: time.Now}
+	t.targets = append(t.targets, &failoverTarget{})
+	for _, raw := range fallbacks {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		t.targets = append(t.targets, &failoverTarget{url: u})
+	}
+	return t, nil
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	targets := t.candidates()
+	if !idempotent(req.Method) {
+		targets = targets[:1]
+	}
+
+	// The body is replayed for every attempt; it is already in memory when converted from Fiber.
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody && len(targets) > 1 {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i, target := range targets {
+		attempt := req.Clone(req.Context())
+		if target.url != nil {
+			attempt.URL.Scheme = target.url.Scheme
+			attempt.URL.Host = target.url.Host
+		}
+		if body != nil {
+			attempt.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err = t.next.RoundTrip(attempt)
+		if err == nil {
+			resp.Header.Set("X-Gateway-Upstream", attempt.URL.Host)
+			if !retryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+		}
+		target.markDown(t.now())
+
+		if i == len(targets)-1 || req.Context().Err() != nil {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// candidates returns the available targets in preference order, or all targets when
+// every one of them is cooling down.
+func (t *failoverTransport) candidates() []*failoverTarget {
+	now := t.now()
+	var available []*failoverTarget
+	for _, target := range t.targets {
+		if target.available(now) {
+			available = append(available, target)
+		}
+	}
+	if len(available) == 0 {
+		return t.targets
+	}
+	return available
+}
+
+// idempotent reports whether requests with method may safely be sent more than once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryableStatus reports whether status indicates the upstream could not serve the request.
+func retryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
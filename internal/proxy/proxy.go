@@ -1,39 +1,868 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math"
+	"mime"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dashboard-platform/api-gateway/internal/errcode"
+	"github.com/dashboard-platform/api-gateway/internal/metrics"
+	"github.com/dashboard-platform/api-gateway/internal/transform"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/rs/zerolog/log"
 )
 
+// Options configures a proxy handler.
+type Options struct {
+	Name            string        // Name of the upstream service, used in logs.
+	MaxResponseSize int64         // Maximum number of response bytes copied from the upstream (0 means unlimited).
+	PathPrefix      string        // Path prepended to the request path when forwarding (e.g. "/internal/auth").
+	StripPrefix     string        // Path removed from the start of the request path before PathPrefix is prepended (e.g. "/templates").
+	SSEKeepAlive    time.Duration // Idle time after which a keepalive comment is sent on event streams (default 15s).
+	MaxHeaderSize   int           // Maximum size in bytes of the forwarded request line and headers (0 means unlimited).
+	KeepAlive       time.Duration // Interval of TCP keep-alive probes on upstream connections (default 15s).
+
+	// DialTimeout bounds connecting to the upstream, and ResponseHeaderTimeout waiting for
+	// its response headers once the request is sent. Both default to defaultTimeout.
+	// Exceeding either fails the request with 504.
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+
+	// RequestTimeout bounds the whole upstream exchange, including streaming the response,
+	// and fails requests exceeding it with 504 (0 means unlimited). RouteTimeouts overrides
+	// it for the request paths below each prefix (e.g. "/pdf/bulk"), the longest prefix winning.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+
+	// PropagateDeadline enforces the deadline sent in X-Deadline (Unix milliseconds) or
+	// Grpc-Timeout, less DeadlineOverhead for the gateway's own work, and forwards the
+	// remaining deadline. Requests whose deadline has already passed fail with 504.
+	PropagateDeadline bool
+	DeadlineOverhead  time.Duration
+
+	// CollapseSlashes replaces runs of slashes in the forwarded path by a single one
+	// (e.g. "/templates//abc" becomes "/templates/abc"). The path is otherwise unchanged.
+	CollapseSlashes bool
+
+	// UserAgentSuffix is appended to the forwarded User-Agent (e.g. "via api-gateway/1.2.3"),
+	// and the client's own is forwarded in X-Forwarded-User-Agent. Empty leaves both unchanged.
+	UserAgentSuffix string
+
+	// ExpectedContentType is the media type successful upstream responses must have
+	// (e.g. "application/pdf"); other responses are replaced by a 502. Empty disables the check.
+	ExpectedContentType string
+
+	// ResponseTransform reshapes successful application/json responses on the paths the rules
+	// match, e.g. rewriting internal asset URLs to public ones (nil disables it). Gzip and deflate bodies are decompressed for the transformation and compressed again.
+	// Bodies larger than maxTransformSize, compressed or not, are passed through unchanged.
+	ResponseTransform *transform.Rules
+
+	// RequestTransform reshapes application/json request bodies on the paths the rules match
+	// before they are forwarded (nil disables it). Bodies larger than maxTransformSize are forwarded unchanged.
+	RequestTransform *transform.Rules
+
+	// Metrics records the status codes returned by the upstream (nil disables it).
+	Metrics *metrics.Upstream
+
+	// VerbatimHeaders lists response headers whose names are sent to the client with
+	// the exact casing the upstream used instead of the canonical one. HTTPS upstreams
+	// are then spoken to over HTTP/1.1, whose header names are sent as written.
+	VerbatimHeaders []string
+
+	// RootCAs are the certificate authorities trusted for HTTPS upstreams (nil uses the
+	// system roots).
+	RootCAs *x509.CertPool
+
+	// Fallbacks are the URLs of backup upstreams, in order of preference, that idempotent
+	// requests fail over to when the target is unreachable or unavailable. Only their
+	// scheme and host are used; the path of the target URL applies to all of them.
+	Fallbacks []string
+
+	// SigningSecret is shared with the upstream, which verifies that requests come from the
+	// gateway by the X-Gateway-Signature and X-Gateway-Timestamp headers they are forwarded
+	// with (see Sign). Nil forwards requests unsigned.
+	SigningSecret []byte
+
+	// BreakerThreshold opens a circuit breaker after that many consecutive upstream requests
+	// failed without a response or with a 5xx one. While it is open, requests fail with 503
+	// at once instead of waiting on the upstream. After BreakerCooldown (default 30s) a single
+	// trial request is forwarded, which closes the breaker when it succeeds. 0 disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Retries is how many more times GET and HEAD requests are sent when the upstream cannot
+	// be connected to, e.g. refused connections and dial timeouts, waiting RetryDelay (default
+	// 100ms) before the first retry and twice as long before each following one. Other methods
+	// are never retried. With Fallbacks, each target is retried before the next one is tried.
+	Retries    int
+	RetryDelay time.Duration
+
+	// HealthCheckInterval is how often each replica of a balanced upstream is probed in the
+	// background with a GET of HealthCheckPath (default "/healthcheck"). A replica failing
+	// UnhealthyThreshold (default 3) consecutive probes, by not answering with a 2xx within the
+	// interval, receives no requests until a probe succeeds again. The probes stop once
+	// HealthContext is done (nil never stops them). 0 disables them, as does a single target.
+	HealthCheckInterval time.Duration
+	HealthCheckPath     string
+	UnhealthyThreshold  int
+	HealthContext       context.Context
+}
+
+// maxTransformSize bounds the size of bodies that are transformed.
+const maxTransformSize = 1 << 20
+
+// defaultSSEKeepAlive is the keepalive interval used when Options.SSEKeepAlive is not set.
+const defaultSSEKeepAlive = 15 * time.Second
+
+// defaultTimeout is the dial and response header timeout used when Options does not set them.
+const defaultTimeout = 5 * time.Second
+
+var (
+	// errResponseTooLarge is returned when an upstream response exceeds Options.MaxResponseSize.
+	errResponseTooLarge = errors.New("upstream response too large")
+	// errUnexpectedContentType is returned when an upstream response does not match Options.ExpectedContentType.
+	errUnexpectedContentType = errors.New("unexpected upstream content type")
+)
+
+// stateKey is the context key under which the per-request proxy state is stored.
+type stateKey struct{}
+
+// requestState carries per-request information from the reverse proxy back to the Fiber handler.
+type requestState struct {
+	tooLarge    bool              // Set when the upstream response exceeded the configured size cap.
+	contentType string            // Set to the actual content type when it did not match the expected one.
+	conn        net.Conn          // Upstream connection the request was sent on, when raw header names are needed.
+	rawNames    map[string]string // Raw names of the verbatim response headers by canonical name.
+	err         error             // Set when the upstream request or the response checks failed.
+	bodyErr     error             // Set when reading the upstream response body failed partway.
+	transform   bool              // Set when the response transform applies to the request path.
+	status      int               // Status code of the upstream response, 0 when there was none.
+}
+
 // New returns a Fiber handler that proxies requests to the target URL.
-func New(target string) fiber.Handler {
-	targetURL, err := url.Parse(target)
+func New(target string, opts Options) fiber.Handler {
+	return NewBalanced([]string{target}, opts)
+}
+
+// NewBalanced is like New but distributes requests round-robin across the replicas of an
+// upstream at the target URLs. The path of the first URL applies to all of them; only the
+// scheme and host of the others are used.
+func NewBalanced(targets []string, opts Options) fiber.Handler {
+	var (
+		targetURL *url.URL
+		replicas  *balancer
+		err       = errors.New("no target URL")
+	)
+	if len(targets) > 0 {
+		targetURL, err = url.Parse(targets[0])
+	}
+	if err == nil && len(targets) > 1 {
+		replicas, err = newBalancer(targets)
+	}
 	if err != nil {
 		log.Error().Msg("Failed to parse target URL: " + err.Error())
 		return func(c *fiber.Ctx) error {
-			return c.Status(http.StatusInternalServerError).SendString("Internal Server Error")
+			return errcode.JSON(c, fiber.StatusInternalServerError, errcode.InternalError, "Internal Server Error")
 		}
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
-	// The original director is sufficient if X-User-ID is already set
-	// by the RequireAuth middleware on c.Request().Header, which adaptor.HTTPHandler
-	// should propagate to the http.Request.
-	// proxy.Director remains the default one from NewSingleHostReverseProxy.
+	// X-User-ID is already set by the RequireAuth middleware on c.Request().Header,
+	// which adaptor.ConvertRequest propagates to the http.Request. The director only
+	// rewrites the request before the default director joins it with the target URL.
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		replica := targetURL
+		if replicas != nil {
+			replica = replicas.pick()
+		}
+		if opts.CollapseSlashes {
+			collapseSlashes(req.URL)
+		}
+		if opts.StripPrefix != "" {
+			stripPathPrefix(req.URL, opts.StripPrefix)
+		}
+		if opts.PathPrefix != "" {
+			addPathPrefix(req.URL, opts.PathPrefix)
+		}
+		// HTTP/1.0 clients may omit Host, which virtual-hosted upstreams cannot route.
+		if req.Host == "" {
+			req.Host = replica.Host
+		}
+		// Fasthttp has already answered 100 Continue and read the body, so the expectation
+		// is met and the upstream must not hold the buffered body back waiting for its own 100.
+		req.Header.Del("Expect")
+		if opts.UserAgentSuffix != "" {
+			setUserAgent(req.Header, opts.UserAgentSuffix)
+		}
+		// Event streams must reach the client uncompressed so every event can be flushed.
+		if strings.Contains(req.Header.Get(fiber.HeaderAccept), "text/event-stream") {
+			req.Header.Del(fiber.HeaderAcceptEncoding)
+		}
+		director(req)
+		req.URL.Scheme = replica.Scheme
+		req.URL.Host = replica.Host
+		// Signed last, so the signature covers the path as the upstream receives it.
+		if opts.SigningSecret != nil {
+			if err := signRequest(req, opts.SigningSecret, time.Now()); err != nil {
+				log.Error().Err(err).Str("service", opts.Name).Msg("Failed to sign upstream request")
+				req.Header.Del(SignatureHeader)
+				req.Header.Del(TimestampHeader)
+			}
+		}
+	}
+
+	dialTimeout := opts.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultTimeout
+	}
+	responseHeaderTimeout := opts.ResponseHeaderTimeout
+	if responseHeaderTimeout <= 0 {
+		responseHeaderTimeout = defaultTimeout
+	}
 
-	proxy.Transport = &http.Transport{
+	// Keep-alive probes keep idle connections alive through NATs with short idle timeouts
+	// and detect dead ones before a request is sent on them.
+	transport := &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext, // Added DialTimeout
-		ResponseHeaderTimeout: 5 * time.Second,
+		DialContext:           (&net.Dialer{Timeout: dialTimeout, KeepAlive: opts.KeepAlive}).DialContext,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		TLSClientConfig:       &tls.Config{RootCAs: opts.RootCAs},
+	}
+	// net/http canonicalizes response header names, so the raw names are recorded on the connection.
+	if len(opts.VerbatimHeaders) > 0 {
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &rawHeaderConn{Conn: conn}, nil
+		}
+		// HTTPS connections are recorded above TLS, where the response head is plain text.
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn, err := handshake(ctx, conn, addr, opts.RootCAs)
+			if err != nil {
+				return nil, err
+			}
+			return &rawHeaderConn{Conn: tlsConn}, nil
+		}
+	}
+	if replicas != nil && opts.HealthCheckInterval > 0 {
+		startHealthChecks(replicas, transport, opts)
+	}
+	var roundTripper http.RoundTripper = transport
+	if opts.Retries > 0 {
+		roundTripper = newRetryTransport(transport, opts.Retries, opts.RetryDelay)
+	}
+	proxy.Transport = roundTripper
+	if len(opts.Fallbacks) > 0 {
+		failover, err := newFailoverTransport(roundTripper, opts.Fallbacks)
+		if err != nil {
+			log.Error().Msg("Failed to parse fallback URL: " + err.Error())
+			return func(c *fiber.Ctx) error {
+				return errcode.JSON(c, fiber.StatusInternalServerError, errcode.InternalError, "Internal Server Error")
+			}
+		}
+		proxy.Transport = failover
+	}
+
+	// The error response is rendered by the handler, which knows why the request failed.
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		state := r.Context().Value(stateKey{}).(*requestState)
+		state.err = err
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		state := resp.Request.Context().Value(stateKey{}).(*requestState)
+		state.status = resp.StatusCode
+
+		// Counted before any check so the metric reflects what the upstream returned,
+		// for buffered and streamed responses alike.
+		opts.Metrics.ObserveResponse(opts.Name, resp.StatusCode)
+
+		if conn, ok := state.conn.(*rawHeaderConn); ok {
+			state.rawNames = verbatimNames(conn.headerNames(), opts.VerbatimHeaders)
+		}
+
+		// Only successful responses are checked, upstream error bodies are passed through.
+		if opts.ExpectedContentType != "" && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			actual := resp.Header.Get(fiber.HeaderContentType)
+			if !sameMediaType(actual, opts.ExpectedContentType) {
+				state.contentType = actual
+				return errUnexpectedContentType
+			}
+		}
+
+		// The body of a 101 response is the upgraded connection, which the reverse proxy
+		// writes to, so it is not wrapped.
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			return nil
+		}
+
+		resp.Body = &truncatedBody{ReadCloser: resp.Body, ctx: resp.Request.Context(), state: state}
+
+		// Reject declared oversized bodies up front, and cap the rest while copying
+		// so that streamed responses are never buffered beyond the limit.
+		if opts.MaxResponseSize > 0 {
+			if resp.ContentLength > opts.MaxResponseSize {
+				state.tooLarge = true
+				return errResponseTooLarge
+			}
+			resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: opts.MaxResponseSize, state: state}
+		}
+
+		if state.transform && resp.StatusCode >= 200 && resp.StatusCode < 300 &&
+			sameMediaType(resp.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) &&
+			transformableEncoding(resp.Header.Get(fiber.HeaderContentEncoding)) {
+			return transformResponse(resp, opts.ResponseTransform)
+		}
+
+		return nil
+	}
+
+	keepAlive := opts.SSEKeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultSSEKeepAlive
+	}
+
+	var cb *breaker
+	if opts.BreakerThreshold > 0 {
+		cb = newBreaker(opts.Name, opts.BreakerThreshold, opts.BreakerCooldown)
+	}
+
+	return func(c *fiber.Ctx) error {
+		if opts.RequestTransform != nil {
+			transformRequest(c, opts.RequestTransform)
+		}
+
+		req, err := adaptor.ConvertRequest(c, true)
+		if err != nil {
+			return err
+		}
+
+		// Fasthttp has already read and de-chunked the body, so it is forwarded with its
+		// known length; some upstreams reject chunked request bodies.
+		req.TransferEncoding = nil
+		// Retries and failover send the body as received, still encoded like the first attempt
+		// (c.Body would decompress it under an unchanged Content-Encoding). It is copied as
+		// fasthttp reuses its buffer once the handler has returned.
+		body := append([]byte(nil), c.Request().Body()...)
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+
+		path := utils.CopyString(c.Path())
+
+		// Fail clearly here rather than letting an upstream with a smaller header buffer fail opaquely.
+		if opts.MaxHeaderSize > 0 {
+			if size := headerSize(req); size > opts.MaxHeaderSize {
+				log.Warn().
+					Str("service", opts.Name).
+					Str("path", path).
+					Int("size", size).
+					Int("limit", opts.MaxHeaderSize).
+					Msg("Forwarded request headers exceed the upstream limit")
+				return errcode.JSON(c, fiber.StatusRequestHeaderFieldsTooLarge, errcode.HeadersTooLarge, "request headers too large")
+			}
+		}
+		state := &requestState{
+			transform: opts.ResponseTransform != nil && opts.ResponseTransform.Matches(path),
+		}
+		// Fiber's own context is canceled as soon as shutdown starts, which would abort the
+		// requests that are being drained, so the upstream request does not derive from it.
+		parent := context.WithValue(c.UserContext(), stateKey{}, state)
+		now := time.Now()
+		var deadline time.Time
+		if timeout := requestTimeout(opts, path); timeout > 0 {
+			deadline = now.Add(timeout)
+		}
+		if opts.PropagateDeadline {
+			if announced, ok := requestDeadline(req.Header, now); ok {
+				announced = announced.Add(-opts.DeadlineOverhead)
+				if !announced.After(now) {
+					return errcode.JSON(c, fiber.StatusGatewayTimeout, errcode.UpstreamTimeout, "deadline exceeded")
+				}
+				if deadline.IsZero() || announced.Before(deadline) {
+					deadline = announced
+				}
+				setRequestDeadline(req.Header, deadline, now)
+			}
+		}
+		if cb != nil {
+			if ok, wait := cb.allow(); !ok {
+				if wait > 0 {
+					c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				}
+				return errcode.JSON(c, fiber.StatusServiceUnavailable, errcode.Unavailable, "upstream unavailable")
+			}
+		}
+		ctx, cancel := context.WithCancel(parent)
+		if !deadline.IsZero() {
+			cancel()
+			ctx, cancel = context.WithDeadline(parent, deadline)
+		}
+		if len(opts.VerbatimHeaders) > 0 {
+			ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) { state.conn = info.Conn },
+			})
+		}
+		w := newResponseRecorder(c, state, ctx.Done())
+
+		// The reverse proxy runs in its own goroutine so that an event stream can keep
+		// copying after this handler has returned and Fiber is writing the response.
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+			defer close(w.chunks)
+			proxy.ServeHTTP(w, req.WithContext(ctx))
+			// A streamed response can only be cut short, it is too late to replace it.
+			if w.streaming && state.tooLarge {
+				logTooLarge(opts, path)
+			}
+			if w.streaming && state.bodyErr != nil {
+				logTruncated(opts, path, state.bodyErr)
+			}
+		}()
+
+		var upgraded net.Conn
+		select {
+		case <-w.headerDone:
+		case <-served:
+		case upgraded = <-w.hijacked:
+		}
+		// The reverse proxy may have switched protocols and returned since.
+		if upgraded == nil {
+			select {
+			case upgraded = <-w.hijacked:
+			default:
+			}
+		}
+
+		if upgraded != nil {
+			recordOutcome(cb, state)
+			switchProtocols(c, upgraded, served, cancel)
+			return nil
+		}
+
+		if w.streaming {
+			recordOutcome(cb, state)
+			streamResponse(c, w, keepAlive, cancel)
+			return nil
+		}
+
+		<-served
+		cancel()
+		recordOutcome(cb, state)
+
+		if state.tooLarge {
+			logTooLarge(opts, path)
+
+			c.Context().SetConnectionClose()
+			c.Response().ResetBody()
+			c.Response().Header.Del(fiber.HeaderContentLength)
+			return errcode.JSON(c, fiber.StatusBadGateway, errcode.UpstreamUnavailable, "upstream response too large")
+		}
+
+		if state.contentType != "" {
+			log.Error().
+				Str("service", opts.Name).
+				Str("path", path).
+				Str("content_type", state.contentType).
+				Str("expected", opts.ExpectedContentType).
+				Msg("Upstream response has an unexpected content type")
+
+			c.Response().ResetBody()
+			c.Response().Header.Del(fiber.HeaderContentLength)
+			return errcode.JSON(c, fiber.StatusBadGateway, errcode.UpstreamUnavailable, "unexpected upstream response")
+		}
+
+		if state.err != nil {
+			log.Error().
+				Err(state.err).
+				Str("service", opts.Name).
+				Str("path", path).
+				Msg("Upstream request failed")
+
+			c.Response().ResetBody()
+			if isTimeout(state.err) {
+				return errcode.JSON(c, fiber.StatusGatewayTimeout, errcode.UpstreamTimeout, "upstream timed out")
+			}
+			return errcode.JSON(c, fiber.StatusBadGateway, errcode.UpstreamUnavailable, "upstream unavailable")
+		}
+
+		// The body was cut short while buffering, so the client can still be told.
+		if state.bodyErr != nil {
+			logTruncated(opts, path, state.bodyErr)
+
+			c.Response().ResetBody()
+			c.Response().Header.Del(fiber.HeaderContentLength)
+			c.Response().Header.Del(fiber.HeaderContentEncoding)
+			if isTimeout(state.bodyErr) {
+				return errcode.JSON(c, fiber.StatusGatewayTimeout, errcode.UpstreamTimeout, "upstream timed out")
+			}
+			return errcode.JSON(c, fiber.StatusBadGateway, errcode.UpstreamUnavailable, "upstream response incomplete")
+		}
+
+		// Like net/http, sniff the content type when the upstream did not send one.
+		if w.header.Get(fiber.HeaderContentType) == "" {
+			body := c.Response().Body()
+			c.Set(fiber.HeaderContentType, http.DetectContentType(body[:min(len(body), 512)]))
+		}
+
+		return nil
+	}
+}
+
+// streamResponse sends an event stream to the client, flushing every chunk as soon as
+// the upstream writes it and sending a keepalive comment whenever the upstream has been
+// idle for keepAlive. done is called once the stream has ended or the client is gone,
+// which stops the upstream copy. A stream the upstream cut short is ended by closing the
+// connection without the terminating chunk, so the client can tell it is incomplete.
+func streamResponse(c *fiber.Ctx, w *responseRecorder, keepAlive time.Duration, done func()) {
+	// Intermediaries must not buffer or cache the stream.
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set("X-Accel-Buffering", "no")
+	c.Response().Header.Del(fiber.HeaderContentLength)
+
+	// The Fiber context is released before the stream is written, so the connection is kept.
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		defer done()
+
+		timer := time.NewTimer(keepAlive)
+		defer timer.Stop()
+
+		for {
+			select {
+			case chunk, ok := <-w.chunks:
+				if !ok {
+					if w.state.bodyErr != nil || w.state.tooLarge {
+						_ = bw.Flush()
+						_ = conn.Close()
+					}
+					return
+				}
+				if _, err := bw.Write(chunk); err != nil {
+					return
+				}
+			case <-timer.C:
+				if _, err := bw.WriteString(": keepalive\n\n"); err != nil {
+					return
+				}
+			}
+			if err := bw.Flush(); err != nil {
+				return
+			}
+			timer.Reset(keepAlive)
+		}
+	})
+}
+
+// switchProtocols relays an upgraded connection, e.g. a WebSocket, between the client and
+// the pipe the reverse proxy copies to and from the upstream. Fasthttp hands the client
+// connection over once the handler has returned, without writing a response of its own.
+// When either side closes, done cancels the upstream request, which closes the upstream
+// connection as well.
+func switchProtocols(c *fiber.Ctx, pipe net.Conn, served <-chan struct{}, done func()) {
+	// Recorded for the access log and metrics; the 101 response is written by the reverse proxy.
+	c.Status(fiber.StatusSwitchingProtocols)
+	c.Context().HijackSetNoResponse(true)
+	c.Context().Hijack(func(conn net.Conn) {
+		go func() {
+			_, _ = io.Copy(pipe, conn)
+			_ = pipe.Close()
+		}()
+		_, _ = io.Copy(conn, pipe)
+		_ = pipe.Close()
+		done()
+		<-served
+	})
+}
+
+// transformResponse applies rules to the JSON body of resp, keeping its Content-Encoding.
+// Bodies that are too large to transform or are not valid JSON are passed through unchanged.
+func transformResponse(resp *http.Response, rules *transform.Rules) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTransformSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxTransformSize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	_ = resp.Body.Close()
+
+	out, err := transformBody(body, resp.Header.Get(fiber.HeaderContentEncoding), rules)
+	if err != nil {
+		log.Warn().Err(err).Str("url", resp.Request.URL.String()).Msg("Failed to transform upstream response")
+		out = body
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(out))
+	resp.ContentLength = int64(len(out))
+	resp.Header.Set(fiber.HeaderContentLength, strconv.Itoa(len(out)))
+	// The validator no longer matches the transformed representation.
+	resp.Header.Del(fiber.HeaderETag)
+	return nil
+}
+
+// transformBody decodes body, applies rules and encodes the result with the same encoding.
+func transformBody(body []byte, encoding string, rules *transform.Rules) ([]byte, error) {
+	decoded, err := decodeBody(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+	out, err := rules.Apply(decoded)
+	if err != nil {
+		return nil, err
+	}
+	return encodeBody(out, encoding)
+}
+
+// transformRequest applies rules to the JSON body of the request in c. Bodies on paths the
+// rules do not match, compressed, too large to transform or not valid JSON are forwarded unchanged.
+func transformRequest(c *fiber.Ctx, rules *transform.Rules) {
+	if !rules.Matches(c.Path()) || c.Get(fiber.HeaderContentEncoding) != "" ||
+		!sameMediaType(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		return
+	}
+	body := c.Request().Body()
+	if len(body) == 0 || len(body) > maxTransformSize {
+		return
+	}
+
+	out, err := rules.Apply(body)
+	if err != nil {
+		log.Warn().Err(err).Str("path", c.Path()).Msg("Failed to transform request body")
+		return
+	}
+	c.Request().SetBody(out)
+}
+
+// sameMediaType reports whether the content types a and b have the same media type,
+// ignoring parameters such as the charset.
+func sameMediaType(a, b string) bool {
+	ma, _, err := mime.ParseMediaType(a)
+	if err != nil {
+		return false
+	}
+	mb, _, err := mime.ParseMediaType(b)
+	return err == nil && ma == mb
+}
+
+// headerSize returns the size in bytes of the request line and headers of req as
+// they are sent on the wire.
+func headerSize(req *http.Request) int {
+	// "METHOD URI HTTP/1.1\r\n" and "Host: host\r\n".
+	size := len(req.Method) + len(req.URL.RequestURI()) + len(" HTTP/1.1\r\n") + 1
+	size += len("Host: \r\n") + len(req.Host)
+	for k, vv := range req.Header {
+		for _, v := range vv {
+			size += len(k) + len(": \r\n") + len(v)
+		}
 	}
+	return size
+}
+
+// logTooLarge logs that the upstream response of a request to path exceeded the size cap,
+// and counts it in the metrics.
+func logTooLarge(opts Options, path string) {
+	opts.Metrics.ObserveOversized(opts.Name)
+	log.Warn().
+		Str("service", opts.Name).
+		Str("path", path).
+		Int64("limit", opts.MaxResponseSize).
+		Msg("Upstream response exceeded the maximum size, terminating connection")
+}
 
-	return adaptor.HTTPHandler(proxy)
+// logTruncated logs that the upstream response of a request to path was cut short by err,
+// and counts it in the metrics.
+func logTruncated(opts Options, path string, err error) {
+	opts.Metrics.ObserveTruncated(opts.Name)
+	log.Error().
+		Err(err).
+		Str("service", opts.Name).
+		Str("path", path).
+		Msg("Upstream response ended prematurely")
+}
+
+// setUserAgent appends suffix to the User-Agent of h, keeping the original in X-Forwarded-User-Agent.
+func setUserAgent(h http.Header, suffix string) {
+	ua := h.Get(fiber.HeaderUserAgent)
+	h.Del("X-Forwarded-User-Agent")
+	if ua != "" {
+		h.Set("X-Forwarded-User-Agent", ua)
+		suffix = ua + " " + suffix
+	}
+	h.Set(fiber.HeaderUserAgent, suffix)
+}
+
+// collapseSlashes replaces runs of slashes in the path of u by a single one, keeping the escaped form in sync.
+func collapseSlashes(u *url.URL) {
+	collapse := func(p string) string {
+		for strings.Contains(p, "//") {
+			p = strings.ReplaceAll(p, "//", "/")
+		}
+		return p
+	}
+	u.Path = collapse(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = collapse(u.RawPath)
+	}
+}
+
+// addPathPrefix prepends prefix to the path of u, keeping the escaped form in sync.
+func addPathPrefix(u *url.URL, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	u.Path = prefix + u.Path
+	if u.RawPath != "" {
+		u.RawPath = prefix + u.RawPath
+	}
+}
+
+// stripPathPrefix removes prefix from the path of u, keeping the escaped form in sync. Paths
+// that do not start with the whole prefix (e.g. "/templatesx" for "/templates") are unchanged.
+func stripPathPrefix(u *url.URL, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	strip := func(p string) string {
+		if p == prefix {
+			return "/"
+		}
+		if strings.HasPrefix(p, prefix+"/") {
+			return p[len(prefix):]
+		}
+		return p
+	}
+	if u.RawPath != "" {
+		// Only strip when the escaped form starts with the prefix too, so both stay consistent.
+		if raw := strip(u.RawPath); raw != u.RawPath {
+			u.RawPath = raw
+			u.Path = strip(u.Path)
+		}
+		return
+	}
+	u.Path = strip(u.Path)
+}
+
+// limitedBody wraps an upstream response body and fails once more than
+// remaining bytes have been read from it.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	state     *requestState
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	// Read one byte past the limit so that a body of exactly the limit is accepted.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		b.state.tooLarge = true
+		return n + int(b.remaining), errResponseTooLarge
+	}
+	return n, err
+}
+
+// requestTimeout returns the timeout of the request path: that of the longest matching
+// prefix of opts.RouteTimeouts, or opts.RequestTimeout when none matches.
+func requestTimeout(opts Options, path string) time.Duration {
+	timeout, matched := opts.RequestTimeout, ""
+	for prefix, t := range opts.RouteTimeouts {
+		if len(prefix) > len(matched) && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			timeout, matched = t, prefix
+		}
+	}
+	return timeout
+}
+
+// startHealthChecks starts probing the replicas of b in the background over transport,
+// as configured by opts.
+func startHealthChecks(b *balancer, transport *http.Transport, opts Options) {
+	ctx := opts.HealthContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	path := opts.HealthCheckPath
+	if path == "" {
+		path = defaultHealthCheckPath
+	}
+	threshold := opts.UnhealthyThreshold
+	if threshold <= 0 {
+		threshold = defaultUnhealthyThreshold
+	}
+	// A redirect is not a healthy answer, whatever it points to.
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	go b.checkHealth(ctx, opts.Name, client, path, opts.HealthCheckInterval, threshold)
+}
+
+// recordOutcome records in b, when it is not nil, whether the upstream failed the request
+// by not responding or responding with a 5xx.
+func recordOutcome(b *breaker, state *requestState) {
+	switch {
+	case b == nil:
+	case state.status == 0 && errors.Is(state.err, context.Canceled):
+		b.abandon()
+	default:
+		b.done(state.status >= 500 || (state.status == 0 && state.err != nil))
+	}
+}
+
+// isTimeout reports whether err is the upstream not answering in time.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// truncatedBody wraps an upstream response body and records the error of a read that
+// failed before the end of the body, unless the client is gone. A passed deadline is recorded.
+type truncatedBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	state *requestState
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && !errors.Is(b.ctx.Err(), context.Canceled) {
+		b.state.bodyErr = err
+	}
+	return n, err
 }
@@ -0,0 +1,1558 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dashboard-platform/api-gateway/internal/metrics"
+	"github.com/dashboard-platform/api-gateway/internal/transform"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// newUpstream starts a fake upstream that writes body using chunked encoding
+// unless declareLength is set.
+func newUpstream(t *testing.T, body string, declareLength bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if declareLength {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, body)
+		if f, ok := w.(http.Flusher); ok && !declareLength {
+			f.Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestNew_MaxResponseSize tests that the response size cap is enforced for both
+// declared and streamed upstream bodies, counting the responses over it in the metrics, and
+// that responses within the cap pass through.
+func TestNew_MaxResponseSize(t *testing.T) {
+	tests := []struct {
+		name          string // Name of the test case.
+		body          string // Body returned by the upstream.
+		declareLength bool   // Whether the upstream declares Content-Length.
+		limit         int64  // Configured response size cap.
+		wantStatus    int    // Expected status code returned to the client.
+	}{
+		{name: "within limit", body: "hello", declareLength: true, limit: 5, wantStatus: fiber.StatusOK},
+		{name: "declared length over limit", body: strings.Repeat("a", 100), declareLength: true, limit: 10, wantStatus: fiber.StatusBadGateway},
+		{name: "streamed body over limit", body: strings.Repeat("a", 100), declareLength: false, limit: 10, wantStatus: fiber.StatusBadGateway},
+		{name: "no limit", body: strings.Repeat("a", 100), declareLength: false, limit: 0, wantStatus: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newUpstream(t, tt.body, tt.declareLength)
+			reg := prometheus.NewRegistry()
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", MaxResponseSize: tt.limit, Metrics: metrics.NewUpstream(reg)}))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			if tt.wantStatus == fiber.StatusOK {
+				assert.Equal(t, tt.body, string(body))
+				count, err := testutil.GatherAndCount(reg, "upstream_oversized_responses_total")
+				assert.NoError(t, err)
+				assert.Zero(t, count)
+			} else {
+				assert.JSONEq(t, `{"error":"upstream response too large","code":"UPSTREAM_UNAVAILABLE"}`, string(body))
+				assert.True(t, resp.Close, "Expected the connection to be closed")
+				expected := `
+# HELP upstream_oversized_responses_total Upstream responses cut off for exceeding the maximum response size, by service.
+# TYPE upstream_oversized_responses_total counter
+upstream_oversized_responses_total{service="test"} 1
+`
+				assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "upstream_oversized_responses_total"))
+			}
+		})
+	}
+}
+
+// newEchoUpstream starts a fake upstream that responds with the request URI it received.
+func newEchoUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RequestURI())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestNew_PathPrefix tests that the configured path prefix is prepended to the forwarded path
+// and that query strings are preserved.
+func TestNew_PathPrefix(t *testing.T) {
+	tests := []struct {
+		name   string // Name of the test case.
+		prefix string // Configured path prefix.
+		path   string // Path requested from the gateway.
+		want   string // Request URI expected at the upstream.
+	}{
+		{name: "no prefix", prefix: "", path: "/auth/login", want: "/auth/login"},
+		{name: "prefix", prefix: "/internal", path: "/auth/login?next=/home", want: "/internal/auth/login?next=/home"},
+		{name: "prefix with trailing slash", prefix: "/internal/", path: "/auth/login", want: "/internal/auth/login"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newEchoUpstream(t)
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", PathPrefix: tt.prefix}))
+
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			assert.NoError(t, err)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+// TestNew_StripPrefix tests that the configured prefix is removed from the forwarded path,
+// keeping the query string, and that paths not starting with it are forwarded unchanged.
+func TestNew_StripPrefix(t *testing.T) {
+	tests := []struct {
+		name       string // Name of the test case.
+		strip      string // Configured prefix to strip.
+		pathPrefix string // Configured prefix to prepend.
+		path       string // Path requested from the gateway.
+		want       string // Request URI expected at the upstream.
+	}{
+		{name: "no strip", strip: "", path: "/templates/abc", want: "/templates/abc"},
+		{name: "strip", strip: "/templates", path: "/templates/abc?v=2&q=/templates", want: "/abc?v=2&q=/templates"},
+		{name: "strip with trailing slash", strip: "/templates/", path: "/templates/abc", want: "/abc"},
+		{name: "prefix only", strip: "/templates", path: "/templates?v=2", want: "/?v=2"},
+		{name: "other path", strip: "/templates", path: "/pdf/templates/abc", want: "/pdf/templates/abc"},
+		{name: "partial segment", strip: "/templates", path: "/templatesx/abc", want: "/templatesx/abc"},
+		{name: "escaped path", strip: "/templates", path: "/templates/a%2Fb", want: "/a%2Fb"},
+		{name: "strip then prepend", strip: "/templates", pathPrefix: "/internal", path: "/templates/abc", want: "/internal/abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newEchoUpstream(t)
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", StripPrefix: tt.strip, PathPrefix: tt.pathPrefix}))
+
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			assert.NoError(t, err)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+// serveApp starts app on a random local port and returns its base URL.
+func serveApp(t *testing.T, app *fiber.App) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+	return "http://" + ln.Addr().String()
+}
+
+// readLine reads a line from r, failing the test if none arrives within a second.
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := r.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a streamed line")
+		return ""
+	}
+}
+
+// TestNew_EventStream tests that server-sent events are delivered to the client one by one
+// while the upstream is still writing, and that keepalive comments are sent while it is idle.
+func TestNew_EventStream(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		// The second event is only sent once the client has received the first one.
+		select {
+		case <-release:
+		case <-time.After(2 * time.Second):
+			return
+		}
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	t.Cleanup(upstream.Close)
+
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{Name: "test", SSEKeepAlive: 50 * time.Millisecond}))
+	baseURL := serveApp(t, app)
+
+	resp, err := http.Get(baseURL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	body := bufio.NewReader(resp.Body)
+	assert.Equal(t, "data: first\n", readLine(t, body))
+	assert.Equal(t, "\n", readLine(t, body))
+
+	// The upstream is idle until released, so a keepalive comment arrives first.
+	assert.Equal(t, ": keepalive\n", readLine(t, body))
+	assert.Equal(t, "\n", readLine(t, body))
+
+	close(release)
+	line := readLine(t, body)
+	for line == ": keepalive\n" || line == "\n" {
+		line = readLine(t, body)
+	}
+	assert.Equal(t, "data: second\n", line)
+}
+
+// TestNew_MaxHeaderSize tests that requests whose forwarded headers exceed the configured
+// limit are rejected with 431 without reaching the upstream.
+func TestNew_MaxHeaderSize(t *testing.T) {
+	tests := []struct {
+		name       string // Name of the test case.
+		cookie     string // Cookie header sent by the client.
+		limit      int    // Configured header size limit.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "within limit", cookie: "a=b", limit: 1024, wantStatus: fiber.StatusOK},
+		{name: "over limit", cookie: "a=" + strings.Repeat("b", 2048), limit: 1024, wantStatus: fiber.StatusRequestHeaderFieldsTooLarge},
+		{name: "no limit", cookie: "a=" + strings.Repeat("b", 2048), limit: 0, wantStatus: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newEchoUpstream(t)
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", MaxHeaderSize: tt.limit}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Cookie", tt.cookie)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestNew_ExpectedContentType tests that successful upstream responses with an unexpected
+// content type are replaced by a 502, while matching and error responses pass through.
+func TestNew_ExpectedContentType(t *testing.T) {
+	tests := []struct {
+		name        string // Name of the test case.
+		contentType string // Content type returned by the upstream.
+		status      int    // Status returned by the upstream.
+		wantStatus  int    // Expected status code returned to the client.
+	}{
+		{name: "matching type", contentType: "application/pdf", status: http.StatusOK, wantStatus: fiber.StatusOK},
+		{name: "matching type with parameters", contentType: "Application/PDF; name=a.pdf", status: http.StatusOK, wantStatus: fiber.StatusOK},
+		{name: "html instead of pdf", contentType: "text/html; charset=utf-8", status: http.StatusOK, wantStatus: fiber.StatusBadGateway},
+		{name: "upstream error passes through", contentType: "application/json", status: http.StatusNotFound, wantStatus: fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, "body")
+			}))
+			defer upstream.Close()
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", ExpectedContentType: "application/pdf"}))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			if tt.wantStatus == fiber.StatusBadGateway {
+				assert.JSONEq(t, `{"error":"unexpected upstream response","code":"UPSTREAM_UNAVAILABLE"}`, string(body))
+			} else {
+				assert.Equal(t, "body", string(body))
+			}
+		})
+	}
+}
+
+// TestNew_ResponseTransform tests that JSON responses are transformed and their length
+// updated, gzip and deflate ones keeping their encoding, while other content types and
+// encodings pass through untouched.
+func TestNew_ResponseTransform(t *testing.T) {
+	tests := []struct {
+		name        string // Name of the test case.
+		contentType string // Content type returned by the upstream.
+		encoding    string // Content-Encoding of the upstream body.
+		body        string // Body returned by the upstream, before encoding.
+		want        string // Body expected by the client, after decoding.
+	}{
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{"title":"Report"}`, want: `{"name":"Report"}`},
+		{name: "non json", contentType: "text/plain", body: `{"title":"Report"}`, want: `{"title":"Report"}`},
+		{name: "gzip json", contentType: "application/json", encoding: "gzip", body: `{"title":"Report"}`, want: `{"name":"Report"}`},
+		{name: "deflate json", contentType: "application/json", encoding: "deflate", body: `{"title":"Report"}`, want: `{"name":"Report"}`},
+		{name: "br json", contentType: "application/json", encoding: "br", body: `{"title":"Report"}`, want: `{"title":"Report"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				body := []byte(tt.body)
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+					if transformableEncoding(tt.encoding) {
+						body, _ = encodeBody(body, tt.encoding)
+					}
+				}
+				_, _ = w.Write(body)
+			}))
+			defer upstream.Close()
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{
+				Name:              "test",
+				ResponseTransform: &transform.Rules{Rename: map[string]string{"title": "name"}},
+			}))
+
+			// Like a browser, so the upstream encoding reaches the gateway.
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.encoding, resp.Header.Get("Content-Encoding"))
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(body)), resp.ContentLength)
+			if transformableEncoding(tt.encoding) {
+				body, err = decodeBody(body, tt.encoding)
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+// TestNew_ResponseURLRewrite tests that internal base URLs in JSON responses are rewritten to
+// public ones on the configured paths, and that other paths and content types pass through.
+func TestNew_ResponseURLRewrite(t *testing.T) {
+	const internal = `{"logo":"http://templates:8080/assets/logo.png","items":[{"href":"http://templates:8080/t/1"}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		_, _ = io.WriteString(w, internal)
+	}))
+	defer upstream.Close()
+
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{
+		Name: "test",
+		ResponseTransform: &transform.Rules{
+			Rewrite: map[string]string{"http://templates:8080": "https://app.example.com/api/templates"},
+			Paths:   []string{"/templates"},
+		},
+	}))
+
+	tests := []struct {
+		name        string // Name of the test case.
+		path        string // Path requested.
+		contentType string // Content type returned by the upstream.
+		want        string // Body expected by the client.
+	}{
+		{
+			name:        "rewritten",
+			path:        "/templates/list",
+			contentType: "application/json",
+			want:        `{"logo":"https://app.example.com/api/templates/assets/logo.png","items":[{"href":"https://app.example.com/api/templates/t/1"}]}`,
+		},
+		{name: "other path", path: "/pdf/list", contentType: "application/json", want: internal},
+		{name: "other content type", path: "/templates/list", contentType: "text/plain", want: internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path+"?type="+url.QueryEscape(tt.contentType), nil))
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(body))
+		})
+	}
+}
+
+// TestNew_RequestTransform tests that JSON request bodies are transformed before they are
+// forwarded with their new length, and that other bodies and routes pass through unchanged.
+func TestNew_RequestTransform(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	app := fiber.New()
+	app.All("/legacy/*", New(upstream.URL, Options{
+		Name: "test",
+		RequestTransform: &transform.Rules{
+			Rename:   map[string]string{"title": "name"},
+			Defaults: map[string]json.RawMessage{"version": json.RawMessage(`2`)},
+		},
+	}))
+	app.All("/*", New(upstream.URL, Options{Name: "test"}))
+
+	tests := []struct {
+		name        string // Name of the test case.
+		path        string // Path requested.
+		contentType string // Content type of the request body.
+		body        string // Body sent by the client.
+		want        string // Body expected by the upstream.
+	}{
+		{name: "transformed", path: "/legacy/templates", contentType: "application/json; charset=utf-8", body: `{"title":"Report"}`, want: `{"name":"Report","version":2}`},
+		{name: "non json", path: "/legacy/templates", contentType: "text/plain", body: `{"title":"Report"}`, want: `{"title":"Report"}`},
+		{name: "invalid json", path: "/legacy/templates", contentType: "application/json", body: `{"title":`, want: `{"title":`},
+		{name: "passthrough route", path: "/templates", contentType: "application/json", body: `{"title":"Report"}`, want: `{"title":"Report"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+			assert.Equal(t, strconv.Itoa(len(tt.want)), resp.Header.Get("X-Content-Length"))
+		})
+	}
+}
+
+// TestNew_Metrics tests that upstream status codes are counted by class for buffered and
+// streamed responses, even when the gateway replaces the response.
+func TestNew_Metrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: done\n\n")
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html></html>")
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	reg := prometheus.NewRegistry()
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{Name: "test", Metrics: metrics.NewUpstream(reg)}))
+	baseURL := serveApp(t, app)
+
+	for _, path := range []string{"/events", "/missing", "/page"} {
+		resp, err := http.Get(baseURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	expected := `
+# HELP upstream_responses_total Responses received from upstream services by service and status class.
+# TYPE upstream_responses_total counter
+upstream_responses_total{service="test",status_class="2xx"} 2
+upstream_responses_total{service="test",status_class="4xx"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "upstream_responses_total"))
+}
+
+// TestNew_VerbatimHeaders tests that configured response headers keep the exact casing
+// and multiplicity the upstream sent, while other headers are canonicalized, for plain and
+// HTTPS upstreams. The HTTPS upstream supports HTTP/2, which must not be negotiated.
+func TestNew_VerbatimHeaders(t *testing.T) {
+	tests := []struct {
+		name string // Name of the test case.
+		tls  bool   // Whether the upstream is served over HTTPS.
+	}{
+		{name: "http", tls: false},
+		{name: "https", tls: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Keys set directly on the map are written as is by net/http.
+				w.Header()["x-sdk-TOKEN"] = []string{"a", "b"}
+				w.Header()["x-other-header"] = []string{"c"}
+				_, _ = io.WriteString(w, "ok")
+			}))
+			var rootCAs *x509.CertPool
+			if tt.tls {
+				upstream.EnableHTTP2 = true
+				upstream.StartTLS()
+				rootCAs = x509.NewCertPool()
+				rootCAs.AddCert(upstream.Certificate())
+			} else {
+				upstream.Start()
+			}
+			t.Cleanup(upstream.Close)
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", VerbatimHeaders: []string{"X-Sdk-Token"}, RootCAs: rootCAs}))
+			addr := strings.TrimPrefix(serveApp(t, app), "http://")
+
+			// Sent twice so a reused upstream connection is covered as well.
+			for i := 0; i < 2; i++ {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: gateway\r\nConnection: close\r\n\r\n")
+				assert.NoError(t, err)
+				raw, err := io.ReadAll(conn)
+				assert.NoError(t, err)
+				conn.Close()
+
+				assert.Contains(t, string(raw), "HTTP/1.1 200 OK\r\n")
+				assert.Contains(t, string(raw), "\r\nx-sdk-TOKEN: a\r\n")
+				assert.Contains(t, string(raw), "\r\nx-sdk-TOKEN: b\r\n")
+				assert.Contains(t, string(raw), "\r\nX-Other-Header: c\r\n")
+			}
+		})
+	}
+}
+
+// TestNew_HTTP10 tests that HTTP/1.0 requests without a Host header reach a virtual-hosted
+// upstream with the upstream host, and that the connection is closed unless kept alive.
+func TestNew_HTTP10(t *testing.T) {
+	upstream := httptest.NewServer(nil)
+	vhost := strings.TrimPrefix(upstream.URL, "http://")
+	upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != vhost {
+			http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	})
+	t.Cleanup(upstream.Close)
+
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{Name: "test"}))
+	addr := strings.TrimPrefix(serveApp(t, app), "http://")
+
+	tests := []struct {
+		name      string // Name of the test case.
+		request   string // Raw request sent by the client.
+		wantClose bool   // Whether the gateway must close the connection.
+	}{
+		{name: "no host", request: "GET / HTTP/1.0\r\n\r\n", wantClose: true},
+		{name: "keep-alive", request: "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", wantClose: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			_, err = io.WriteString(conn, tt.request)
+			assert.NoError(t, err)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, "ok", string(body))
+			assert.Equal(t, tt.wantClose, resp.Close)
+		})
+	}
+}
+
+// TestNew_Fallbacks tests that idempotent requests fail over to the backups in order,
+// that failed targets are skipped afterwards, and that other requests never fail over.
+func TestNew_Fallbacks(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	var unavailableHits atomic.Int32
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unavailableHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(unavailable.Close)
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	}))
+	t.Cleanup(healthy.Close)
+
+	app := fiber.New()
+	app.All("/*", New(down.URL, Options{Name: "test", Fallbacks: []string{unavailable.URL, healthy.URL}}))
+
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest("PUT", "/items/1", strings.NewReader("data")))
+		assert.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, "PUT /items/1 data", string(body))
+		assert.Equal(t, strings.TrimPrefix(healthy.URL, "http://"), resp.Header.Get("X-Gateway-Upstream"))
+	}
+	// The unavailable backup is skipped once it has failed.
+	assert.Equal(t, int32(1), unavailableHits.Load())
+
+	app = fiber.New()
+	app.All("/*", New(down.URL, Options{Name: "test", Fallbacks: []string{healthy.URL}}))
+	resp, err := app.Test(httptest.NewRequest("POST", "/items", strings.NewReader("data")))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadGateway, resp.StatusCode)
+}
+
+// TestNew_ChunkedRequestBody tests that a chunked request body reaches the upstream intact
+// and with a Content-Length instead of chunked encoding.
+func TestNew_ChunkedRequestBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, r.Header.Get("Content-Length")+" "+strings.Join(r.TransferEncoding, ",")+"|"+string(body))
+	}))
+	t.Cleanup(upstream.Close)
+
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{Name: "test"}))
+	addr := strings.TrimPrefix(serveApp(t, app), "http://")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: gateway\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n")
+	assert.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "11 |hello world", string(body))
+}
+
+// TestNew_UpstreamError tests that unreachable upstreams are reported as an uncacheable JSON 502.
+func TestNew_UpstreamError(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	app := fiber.New()
+	app.All("/*", New(down.URL, Options{Name: "test"}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/items", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadGateway, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"error":"upstream unavailable","code":"UPSTREAM_UNAVAILABLE"}`, string(body))
+	assert.Equal(t, "no-store", resp.Header.Get(fiber.HeaderCacheControl))
+}
+
+// TestNew_ResponseHeaderTimeout tests that a slow upstream succeeds within the configured
+// response header timeout and fails with 504 beyond it.
+func TestNew_ResponseHeaderTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = io.WriteString(w, "rendered")
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name       string        // Name of the test case.
+		timeout    time.Duration // Response header timeout of the proxy.
+		wantStatus int           // Expected status code.
+		wantBody   string        // Expected JSON body, empty when the upstream body is expected.
+	}{
+		{name: "within timeout", timeout: time.Second, wantStatus: fiber.StatusOK},
+		{name: "beyond timeout", timeout: 50 * time.Millisecond, wantStatus: fiber.StatusGatewayTimeout, wantBody: `{"error":"upstream timed out","code":"UPSTREAM_TIMEOUT"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", ResponseHeaderTimeout: tt.timeout}))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/render", nil), -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			if tt.wantBody == "" {
+				assert.Equal(t, "rendered", string(body))
+			} else {
+				assert.JSONEq(t, tt.wantBody, string(body))
+			}
+		})
+	}
+}
+
+// TestNew_RouteTimeouts tests that a route timeout overrides the request timeout on its
+// paths only, with the longest prefix winning.
+func TestNew_RouteTimeouts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = io.WriteString(w, "done")
+	}))
+	t.Cleanup(upstream.Close)
+
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{
+		Name:           "test",
+		RequestTimeout: 50 * time.Millisecond,
+		RouteTimeouts: map[string]time.Duration{
+			"/admin/bulk":       5 * time.Second,
+			"/admin/bulk/quick": 50 * time.Millisecond,
+		},
+	}))
+
+	tests := []struct {
+		name       string // Name of the test case.
+		path       string // Path requested.
+		wantStatus int    // Expected status code.
+	}{
+		{name: "override", path: "/admin/bulk", wantStatus: fiber.StatusOK},
+		{name: "below override", path: "/admin/bulk/import", wantStatus: fiber.StatusOK},
+		{name: "longer override", path: "/admin/bulk/quick/1", wantStatus: fiber.StatusGatewayTimeout},
+		{name: "other route", path: "/templates/list", wantStatus: fiber.StatusGatewayTimeout},
+		{name: "prefix of a segment only", path: "/admin/bulky", wantStatus: fiber.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil), -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestIsTimeout tests which upstream errors count as timeouts.
+func TestIsTimeout(t *testing.T) {
+	_, dialErr := net.DialTimeout("tcp", "10.255.255.1:80", time.Nanosecond)
+
+	assert.True(t, isTimeout(context.DeadlineExceeded))
+	assert.True(t, isTimeout(fmt.Errorf("round trip: %w", context.DeadlineExceeded)))
+	assert.True(t, isTimeout(dialErr))
+	assert.False(t, isTimeout(errors.New("connection refused")))
+}
+
+// TestNew_ExpectContinue tests that a client sending Expect: 100-continue gets 100 Continue
+// before sending its body, and that the complete body is forwarded and replayed on failover.
+func TestNew_ExpectContinue(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, r.Header.Get("Expect")+"|"+string(body))
+	}))
+	t.Cleanup(upstream.Close)
+
+	app := fiber.New()
+	app.All("/*", New(down.URL, Options{Name: "test", Fallbacks: []string{upstream.URL}}))
+	addr := strings.TrimPrefix(serveApp(t, app), "http://")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	_, err = io.WriteString(conn, "PUT /upload HTTP/1.1\r\nHost: gateway\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", readLine(t, r))
+	assert.Equal(t, "\r\n", readLine(t, r))
+
+	_, err = io.WriteString(conn, "hello")
+	assert.NoError(t, err)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "|hello", string(body))
+}
+
+// newResettingUpstream starts a fake upstream that answers every request with head and
+// the start of a body, then resets the connection.
+func newResettingUpstream(t *testing.T, head, body string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = http.ReadRequest(bufio.NewReader(conn))
+				_, _ = io.WriteString(conn, head+body)
+				time.Sleep(50 * time.Millisecond)
+				_ = conn.(*net.TCPConn).SetLinger(0)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+// TestNew_UpstreamReset tests that a response body cut short by the upstream becomes a 502
+// while it is buffered, and ends the connection abnormally once it is streamed.
+func TestNew_UpstreamReset(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.NewUpstream(reg)
+
+	buffered := newResettingUpstream(t, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n", `{"items":[`)
+	app := fiber.New()
+	app.All("/*", New(buffered, Options{Name: "test", Metrics: m}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/items", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadGateway, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"error":"upstream response incomplete","code":"UPSTREAM_UNAVAILABLE"}`, string(body))
+
+	streamed := newResettingUpstream(t, "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nTransfer-Encoding: chunked\r\n\r\n", "9\r\ndata: 1\n\n\r\n")
+	app = fiber.New()
+	app.All("/*", New(streamed, Options{Name: "test", Metrics: m}))
+	base := serveApp(t, app)
+
+	resp, err = http.Get(base + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "data: 1\n\n", string(body))
+
+	expected := `
+# HELP upstream_truncated_responses_total Upstream responses whose body ended prematurely, by service.
+# TYPE upstream_truncated_responses_total counter
+upstream_truncated_responses_total{service="test"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "upstream_truncated_responses_total"))
+}
+
+// TestNew_PropagateDeadline tests that inbound deadlines are shortened by the overhead,
+// forwarded and enforced, and that expired ones fail without calling the upstream.
+func TestNew_PropagateDeadline(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if delay, err := time.ParseDuration(r.URL.Query().Get("delay")); err == nil {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		_, _ = io.WriteString(w, r.Header.Get("X-Deadline")+"|"+r.Header.Get("Grpc-Timeout"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{Name: "test", PropagateDeadline: true, DeadlineOverhead: 100 * time.Millisecond}))
+
+	now := time.Now()
+	tests := []struct {
+		name       string // Name of the test case.
+		path       string // Path requested.
+		header     string // Deadline header sent.
+		value      string // Value of the deadline header.
+		wantStatus int    // Expected status code.
+		wantHit    bool   // Whether the upstream is called.
+	}{
+		{name: "ample X-Deadline", path: "/", header: "X-Deadline", value: strconv.FormatInt(now.Add(5*time.Second).UnixMilli(), 10), wantStatus: fiber.StatusOK, wantHit: true},
+		{name: "ample Grpc-Timeout", path: "/", header: "Grpc-Timeout", value: "5S", wantStatus: fiber.StatusOK, wantHit: true},
+		{name: "tight", path: "/?delay=1s", header: "Grpc-Timeout", value: "300m", wantStatus: fiber.StatusGatewayTimeout, wantHit: true},
+		{name: "expired", path: "/", header: "X-Deadline", value: strconv.FormatInt(now.Add(50*time.Millisecond).UnixMilli(), 10), wantStatus: fiber.StatusGatewayTimeout},
+		{name: "no deadline", path: "/", wantStatus: fiber.StatusOK, wantHit: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits.Store(0)
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, tt.wantHit, hits.Load() == 1)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			if tt.wantStatus == fiber.StatusGatewayTimeout {
+				assert.Contains(t, string(body), `"code":"UPSTREAM_TIMEOUT"`)
+				return
+			}
+
+			// The forwarded deadline leaves room for the overhead.
+			deadline, timeout, _ := strings.Cut(string(body), "|")
+			switch tt.header {
+			case "X-Deadline":
+				ms, err := strconv.ParseInt(deadline, 10, 64)
+				assert.NoError(t, err)
+				assert.Equal(t, now.Add(4900*time.Millisecond).UnixMilli(), ms)
+			case "Grpc-Timeout":
+				ms, err := strconv.Atoi(strings.TrimSuffix(timeout, "m"))
+				assert.NoError(t, err)
+				assert.InDelta(t, 4900, ms, 100)
+			default:
+				assert.Equal(t, "|", string(body))
+			}
+		})
+	}
+}
+
+// TestNew_UserAgentSuffix tests that the suffix is appended to the forwarded User-Agent and
+// that the client's own is forwarded in X-Forwarded-User-Agent.
+func TestNew_UserAgentSuffix(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("User-Agent")+"|"+r.Header.Get("X-Forwarded-User-Agent"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name      string // Name of the test case.
+		suffix    string // Configured suffix.
+		userAgent string // User-Agent sent by the client.
+		forwarded string // X-Forwarded-User-Agent sent by the client.
+		want      string // Expected User-Agent and X-Forwarded-User-Agent received upstream.
+	}{
+		{name: "suffix appended", suffix: "via api-gateway/1.2.3", userAgent: "Mozilla/5.0", want: "Mozilla/5.0 via api-gateway/1.2.3|Mozilla/5.0"},
+		{name: "no client user agent", suffix: "via api-gateway/1.2.3", want: "via api-gateway/1.2.3|"},
+		{name: "spoofed forwarded user agent", suffix: "via api-gateway/1.2.3", userAgent: "curl/8.0", forwarded: "admin-tool", want: "curl/8.0 via api-gateway/1.2.3|curl/8.0"},
+		{name: "disabled", userAgent: "Mozilla/5.0", want: "Mozilla/5.0|"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", UserAgentSuffix: tt.suffix}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-User-Agent", tt.forwarded)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+// TestNew_CollapseSlashes tests that duplicate slashes are collapsed only when enabled.
+func TestNew_CollapseSlashes(t *testing.T) {
+	tests := []struct {
+		name     string // Name of the test case.
+		collapse bool   // Whether slashes are collapsed.
+		path     string // Path requested from the gateway.
+		want     string // Request URI expected at the upstream.
+	}{
+		{name: "disabled", path: "/templates//abc", want: "/templates//abc"},
+		{name: "enabled", collapse: true, path: "/templates//abc", want: "/templates/abc"},
+		{name: "enabled with query", collapse: true, path: "///templates///abc//?next=//home", want: "/templates/abc/?next=//home"},
+		{name: "enabled with escaped slash", collapse: true, path: "/templates//a%2Fb", want: "/templates/a%2Fb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newEchoUpstream(t)
+
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", CollapseSlashes: tt.collapse}))
+
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			assert.NoError(t, err)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+// TestSign tests that signatures are deterministic and cover every part of the canonical request.
+func TestSign(t *testing.T) {
+	secret := []byte("shared-secret")
+	sig := Sign(secret, "POST", "/templates/42?draft=1", "1767225600", []byte(`{"name":"invoice"}`))
+	assert.Equal(t, sig, Sign(secret, "POST", "/templates/42?draft=1", "1767225600", []byte(`{"name":"invoice"}`)))
+	assert.Len(t, sig, 64)
+
+	assert.NotEqual(t, sig, Sign([]byte("other-secret"), "POST", "/templates/42?draft=1", "1767225600", []byte(`{"name":"invoice"}`)))
+	assert.NotEqual(t, sig, Sign(secret, "PUT", "/templates/42?draft=1", "1767225600", []byte(`{"name":"invoice"}`)))
+	assert.NotEqual(t, sig, Sign(secret, "POST", "/templates/42", "1767225600", []byte(`{"name":"invoice"}`)))
+	assert.NotEqual(t, sig, Sign(secret, "POST", "/templates/42?draft=1", "1767225601", []byte(`{"name":"invoice"}`)))
+	assert.NotEqual(t, sig, Sign(secret, "POST", "/templates/42?draft=1", "1767225600", []byte(`{"name":"receipt"}`)))
+}
+
+// TestNew_SigningSecret tests that forwarded requests carry a fresh signature that an
+// upstream verifier computes from what it received, replacing any sent by the client.
+func TestNew_SigningSecret(t *testing.T) {
+	secret := []byte("shared-secret")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodyHash := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, secret)
+		_, _ = io.WriteString(mac, r.Method+"\n"+r.URL.RequestURI()+"\n"+r.Header.Get("X-Gateway-Timestamp")+"\n"+hex.EncodeToString(bodyHash[:])+"\n")
+
+		got, err := hex.DecodeString(r.Header.Get("X-Gateway-Signature"))
+		if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, r.Header.Get("X-Gateway-Timestamp"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name   string // Name of the test case.
+		secret []byte // Configured signing secret.
+		method string // Method of the request.
+		target string // Path and query requested from the gateway.
+		body   string // Body of the request.
+		gzip   bool   // Whether the body is sent gzip encoded.
+		spoof  bool   // Whether the client sends its own signature headers.
+		status int    // Expected status code from the upstream.
+	}{
+		{name: "get", secret: secret, method: "GET", target: "/templates/42?draft=1", status: http.StatusOK},
+		{name: "post with body", secret: secret, method: "POST", target: "/templates", body: `{"name":"invoice"}`, status: http.StatusOK},
+		{name: "post with gzip body", secret: secret, method: "POST", target: "/templates", body: `{"name":"invoice"}`, gzip: true, status: http.StatusOK},
+		{name: "spoofed signature replaced", secret: secret, method: "DELETE", target: "/templates/42", spoof: true, status: http.StatusOK},
+		{name: "disabled", method: "GET", target: "/templates/42", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.All("/*", New(upstream.URL, Options{Name: "test", PathPrefix: "/internal", SigningSecret: tt.secret}))
+
+			body := []byte(tt.body)
+			if tt.gzip {
+				body, _ = encodeBody(body, "gzip")
+			}
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(body))
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			if tt.spoof {
+				req.Header.Set("X-Gateway-Timestamp", "1")
+				req.Header.Set("X-Gateway-Signature", "00")
+			}
+			before := time.Now().Unix()
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			if tt.status == http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				timestamp, err := strconv.ParseInt(string(body), 10, 64)
+				assert.NoError(t, err)
+				assert.GreaterOrEqual(t, timestamp, before)
+			}
+		})
+	}
+}
+
+// TestNew_CircuitBreaker tests that the breaker opens after consecutive upstream failures,
+// rejects requests without contacting the upstream while open, reopens when the half-open
+// trial fails and closes when it succeeds, and that 4xx responses are not failures.
+func TestNew_CircuitBreaker(t *testing.T) {
+	var status atomic.Int32
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(upstream.Close)
+
+	cooldown := 100 * time.Millisecond
+	app := fiber.New()
+	app.All("/*", New(upstream.URL, Options{Name: "test", BreakerThreshold: 2, BreakerCooldown: cooldown}))
+
+	steps := []struct {
+		name     string        // Name of the step.
+		upstream int           // Status code returned by the upstream.
+		wait     time.Duration // Time waited before the request.
+		want     int           // Expected status code at the client.
+		hit      bool          // Whether the request is expected to reach the upstream.
+	}{
+		{name: "client errors are not failures", upstream: http.StatusNotFound, want: http.StatusNotFound, hit: true},
+		{name: "client errors are not failures again", upstream: http.StatusNotFound, want: http.StatusNotFound, hit: true},
+		{name: "first failure", upstream: http.StatusInternalServerError, want: http.StatusInternalServerError, hit: true},
+		{name: "second failure opens", upstream: http.StatusBadGateway, want: http.StatusBadGateway, hit: true},
+		{name: "open", upstream: http.StatusOK, want: http.StatusServiceUnavailable},
+		{name: "failed trial reopens", upstream: http.StatusServiceUnavailable, wait: cooldown, want: http.StatusServiceUnavailable, hit: true},
+		{name: "open again", upstream: http.StatusOK, want: http.StatusServiceUnavailable},
+		{name: "successful trial closes", upstream: http.StatusOK, wait: cooldown, want: http.StatusOK, hit: true},
+		{name: "closed", upstream: http.StatusInternalServerError, want: http.StatusInternalServerError, hit: true},
+	}
+
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		status.Store(int32(step.upstream))
+		before := hits.Load()
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		assert.NoError(t, err, step.name)
+		assert.Equal(t, step.want, resp.StatusCode, step.name)
+		assert.Equal(t, step.hit, hits.Load() > before, step.name)
+		if !step.hit {
+			assert.Equal(t, "1", resp.Header.Get("Retry-After"), step.name)
+		}
+	}
+}
+
+// TestNew_CircuitBreakerUnreachable tests that connection failures open the breaker.
+func TestNew_CircuitBreakerUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target := upstream.URL
+	upstream.Close()
+
+	app := fiber.New()
+	app.All("/*", New(target, Options{Name: "test", BreakerThreshold: 1, BreakerCooldown: time.Minute}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+}
+
+// TestBreaker_SingleTrial tests that a half-open breaker lets a single trial request through
+// at a time, and lets another through when the trial is abandoned.
+func TestBreaker_SingleTrial(t *testing.T) {
+	now := time.Now()
+	b := newBreaker("test", 1, time.Second)
+	b.now = func() time.Time { return now }
+
+	ok, _ := b.allow()
+	assert.True(t, ok)
+	b.done(true)
+	ok, wait := b.allow()
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	now = now.Add(time.Second)
+	ok, _ = b.allow()
+	assert.True(t, ok, "Expected the trial request to be allowed")
+	ok, _ = b.allow()
+	assert.False(t, ok, "Expected a single trial request")
+
+	b.abandon()
+	ok, _ = b.allow()
+	assert.True(t, ok, "Expected a new trial once the previous one was abandoned")
+	b.done(false)
+	for range 3 {
+		ok, _ = b.allow()
+		assert.True(t, ok, "Expected the breaker to be closed")
+		b.done(false)
+	}
+}
+
+// flakyTransport is a fake upstream that refuses the first failures connections, then
+// records the body and headers of every request it serves.
+type flakyTransport struct {
+	failures int
+	attempts int
+	bodies   []string
+	headers  []string
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.attempts++
+	if t.attempts <= t.failures {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	t.bodies = append(t.bodies, string(body))
+	t.headers = append(t.headers, req.Header.Get("X-Test"))
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}, Request: req}, nil
+}
+
+// TestRetryTransport tests that idempotent requests are retried after connection failures
+// up to the retry count with their body and headers, and that other methods and other
+// errors are never retried.
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string // Name of the test case.
+		method   string // Method of the request.
+		body     string // Body of the request.
+		failures int    // Connections the upstream refuses before serving.
+		attempts int    // Expected attempts.
+		ok       bool   // Whether the request is expected to succeed.
+	}{
+		{name: "get succeeds after failures", method: "GET", failures: 2, attempts: 3, ok: true},
+		{name: "head succeeds after failures", method: "HEAD", failures: 1, attempts: 2, ok: true},
+		{name: "get with body", method: "GET", body: "query", failures: 3, attempts: 4, ok: true},
+		{name: "retries exhausted", method: "GET", failures: 5, attempts: 4},
+		{name: "post not retried", method: "POST", body: "payload", failures: 1, attempts: 1},
+		{name: "delete not retried", method: "DELETE", failures: 1, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &flakyTransport{failures: tt.failures}
+			rt := newRetryTransport(upstream, 3, time.Millisecond)
+
+			req := httptest.NewRequest(tt.method, "http://upstream/templates", strings.NewReader(tt.body))
+			req.Header.Set("X-Test", "kept")
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(tt.body)), nil
+			}
+
+			resp, err := rt.RoundTrip(req)
+			assert.Equal(t, tt.attempts, upstream.attempts)
+			if !tt.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, []string{tt.body}, upstream.bodies)
+			assert.Equal(t, []string{"kept"}, upstream.headers)
+		})
+	}
+}
+
+// TestRetryTransport_Backoff tests that the wait doubles between retries and that a
+// canceled request stops waiting.
+func TestRetryTransport_Backoff(t *testing.T) {
+	upstream := &flakyTransport{failures: 3}
+	rt := newRetryTransport(upstream, 3, 20*time.Millisecond)
+
+	start := time.Now()
+	_, err := rt.RoundTrip(httptest.NewRequest("GET", "http://upstream/", nil))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond, "Expected waits of 20ms, 40ms and 80ms")
+
+	upstream = &flakyTransport{failures: 3}
+	rt = newRetryTransport(upstream, 3, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = rt.RoundTrip(httptest.NewRequest("GET", "http://upstream/", nil).WithContext(ctx))
+	assert.Error(t, err)
+	assert.Equal(t, 1, upstream.attempts)
+}
+
+// TestNew_Retries tests that the proxy retries GETs to an unreachable upstream before
+// failing with 502.
+func TestNew_Retries(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target := upstream.URL
+	upstream.Close()
+
+	app := fiber.New()
+	app.All("/*", New(target, Options{Name: "test", Retries: 2, RetryDelay: 50 * time.Millisecond}))
+
+	start := time.Now()
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond, "Expected two retries")
+
+	start = time.Now()
+	resp, err = app.Test(httptest.NewRequest("POST", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Less(t, time.Since(start), 50*time.Millisecond, "Expected no retries")
+}
+
+// TestNew_RetriesEncodedBody tests that a retried request with a gzip body is sent with the
+// body as the client encoded it, matching its unchanged Content-Encoding.
+func TestNew_RetriesEncodedBody(t *testing.T) {
+	// The upstream only starts listening after the first attempt has been refused.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	app := fiber.New()
+	app.All("/*", New("http://"+addr, Options{Name: "test", Retries: 3, RetryDelay: 100 * time.Millisecond}))
+
+	encoded, err := encodeBody([]byte(`{"query":"invoices"}`), "gzip")
+	assert.NoError(t, err)
+	req := httptest.NewRequest("GET", "/search", bytes.NewReader(encoded))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	started := make(chan *httptest.Server, 1)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			decoded, err := decodeBody(body, r.Header.Get("Content-Encoding"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write(decoded)
+		}))
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			started <- nil
+			return
+		}
+		upstream.Listener = listener
+		upstream.Start()
+		started <- upstream
+	}()
+
+	resp, testErr := app.Test(req, -1)
+	upstream := <-started
+	if upstream == nil {
+		t.Skip("the upstream address was taken in the meantime")
+	}
+	t.Cleanup(upstream.Close)
+
+	assert.NoError(t, testErr)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, `{"query":"invoices"}`, string(body))
+}
+
+// TestNewBalanced tests that requests are distributed round-robin across the replicas with
+// the path of the first URL, and that a single URL still works.
+func TestNewBalanced(t *testing.T) {
+	var urls []string
+	for i := range 3 {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "replica-%d %s", i, r.URL.Path)
+		}))
+		t.Cleanup(upstream.Close)
+		urls = append(urls, upstream.URL)
+	}
+
+	tests := []struct {
+		name    string   // Name of the test case.
+		targets []string // Configured target URLs.
+		want    []string // Expected responses of consecutive requests.
+	}{
+		{
+			name:    "replicas",
+			targets: []string{urls[0] + "/v1", urls[1], urls[2]},
+			want:    []string{"replica-0 /v1/templates", "replica-1 /v1/templates", "replica-2 /v1/templates", "replica-0 /v1/templates"},
+		},
+		{
+			name:    "single url",
+			targets: []string{urls[1]},
+			want:    []string{"replica-1 /templates", "replica-1 /templates"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.All("/*", NewBalanced(tt.targets, Options{Name: "test"}))
+
+			for _, want := range tt.want {
+				resp, err := app.Test(httptest.NewRequest("GET", "/templates", nil))
+				assert.NoError(t, err)
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, want, string(body))
+			}
+		})
+	}
+}
+
+// TestBalancer_Concurrent tests that concurrent picks are spread evenly across the replicas.
+func TestBalancer_Concurrent(t *testing.T) {
+	b, err := newBalancer([]string{"http://a", "http://b", "http://c"})
+	assert.NoError(t, err)
+
+	var counts [3]atomic.Int32
+	var wg sync.WaitGroup
+	for range 300 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts[b.pick().Host[0]-'a'].Add(1)
+		}()
+	}
+	wg.Wait()
+
+	for i := range counts {
+		assert.Equal(t, int32(100), counts[i].Load())
+	}
+}
+
+// TestNewBalanced_HealthChecks tests that a replica failing its health checks stops
+// receiving requests until it recovers, and that the probes stop with their context.
+func TestNewBalanced_HealthChecks(t *testing.T) {
+	var (
+		healthy atomic.Bool  // Whether the second replica passes its health checks.
+		probes  atomic.Int32 // Probes received by the second replica.
+	)
+	healthy.Store(true)
+
+	var urls []string
+	for i := range 2 {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/ready" {
+				if i == 1 {
+					probes.Add(1)
+					if !healthy.Load() {
+						w.WriteHeader(http.StatusServiceUnavailable)
+					}
+				}
+				return
+			}
+			_, _ = fmt.Fprintf(w, "replica-%d", i)
+		}))
+		t.Cleanup(upstream.Close)
+		urls = append(urls, upstream.URL)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	app := fiber.New()
+	app.All("/*", NewBalanced(urls, Options{
+		Name:                "test",
+		HealthCheckInterval: 10 * time.Millisecond,
+		HealthCheckPath:     "/ready",
+		UnhealthyThreshold:  2,
+		HealthContext:       ctx,
+	}))
+
+	// replicas returns the replicas that served four consecutive requests.
+	replicas := func() map[string]bool {
+		served := map[string]bool{}
+		for range 4 {
+			resp, err := app.Test(httptest.NewRequest("GET", "/templates", nil))
+			assert.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			served[string(body)] = true
+		}
+		return served
+	}
+
+	assert.Equal(t, map[string]bool{"replica-0": true, "replica-1": true}, replicas())
+
+	healthy.Store(false)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]bool{"replica-0": true}, replicas())
+	}, time.Second, 10*time.Millisecond)
+
+	healthy.Store(true)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]bool{"replica-0": true, "replica-1": true}, replicas())
+	}, time.Second, 10*time.Millisecond)
+
+	stop()
+	time.Sleep(30 * time.Millisecond)
+	stopped := probes.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, probes.Load())
+}
+
+// TestBalancer_Unhealthy tests that unhealthy replicas are skipped, and that all replicas
+// are used in turn when none is healthy.
+func TestBalancer_Unhealthy(t *testing.T) {
+	b, err := newBalancer([]string{"http://a", "http://b", "http://c"})
+	assert.NoError(t, err)
+
+	pick := func() (hosts string) {
+		for range 4 {
+			hosts += b.pick().Host
+		}
+		return hosts
+	}
+
+	b.healthy[1].Store(false)
+	assert.Equal(t, "acac", pick())
+
+	b.healthy[0].Store(false)
+	b.healthy[2].Store(false)
+	assert.Equal(t, "bcab", pick())
+}
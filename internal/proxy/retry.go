@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultRetryDelay is the wait before the first retry when Options does not set it.
+const defaultRetryDelay = 100 * time.Millisecond
+
+// retryTransport retries GET and HEAD requests that failed because the upstream could not
+// be connected to, with exponential backoff. Such requests never reached the upstream, and
+// other methods are never retried.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	delay   time.Duration
+}
+
+// newRetryTransport returns a retryTransport making up to retries more attempts, waiting
+// delay before the first retry and twice as long before each following one.
+func newRetryTransport(next http.RoundTripper, retries int, delay time.Duration) *retryTransport {
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	return &retryTransport{next: next, retries: retries, delay: delay}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	delay := t.delay
+	for attempt := 0; ; attempt++ {
+		// Every attempt gets a fresh copy of the body; the first one may have consumed it.
+		try := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(req.Context())
+			try.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(try)
+		if err == nil || attempt == t.retries || !dialFailed(err) {
+			return resp, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// dialFailed reports whether err is a failure to connect to the upstream, such as a
+// refused connection or a dial timeout, so that the request was never sent.
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
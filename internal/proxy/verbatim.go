@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/textproto"
+	"sync"
+)
+
+// maxRawHeadSize bounds the bytes of a response head kept to recover raw header names.
+// Larger heads are passed through with canonical header names.
+const maxRawHeadSize = 64 << 10
+
+// rawHeaderConn is an upstream connection that records the header names of responses
+// exactly as the upstream wrote them, before net/http canonicalizes them.
+// Requests on a connection are sequential, so the names always belong to the latest response.
+type rawHeaderConn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	head  []byte            // Bytes of the current response head read so far.
+	done  bool              // Set once the head of the current response has been read.
+	names map[string]string // Raw header names of the latest response by canonical name.
+}
+
+// Write starts a new request, so the next response head is recorded afresh.
+func (c *rawHeaderConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if c.done {
+		c.head, c.done, c.names = nil, false, nil
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *rawHeaderConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		c.record(b[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// headerNames returns the raw header names of the latest response by canonical name.
+func (c *rawHeaderConn) headerNames() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.names
+}
+
+// record appends b to the current response head and parses it once it is complete.
+// Interim (1xx) heads other than 101 Switching Protocols are skipped.
+func (c *rawHeaderConn) record(b []byte) {
+	if c.done {
+		return
+	}
+	c.head = append(c.head, b...)
+
+	for {
+		end := bytes.Index(c.head, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(c.head) > maxRawHeadSize {
+				c.head, c.done = nil, true
+			}
+			return
+		}
+
+		lines := bytes.Split(c.head[:end], []byte("\r\n"))
+		if interim(lines[0]) {
+			c.head = c.head[end+4:]
+			continue
+		}
+
+		c.names = make(map[string]string, len(lines)-1)
+		for _, line := range lines[1:] {
+			if name, _, ok := bytes.Cut(line, []byte(":")); ok {
+				raw := string(bytes.TrimSpace(name))
+				c.names[textproto.CanonicalMIMEHeaderKey(raw)] = raw
+			}
+		}
+		c.head, c.done = nil, true
+		return
+	}
+}
+
+// handshake runs the TLS handshake of a connection to the upstream at addr, offering only
+// HTTP/1.1: HTTP/2 sends header names lowercased and compressed, so their casing is lost.
+// The connection is closed when the handshake fails.
+func handshake(ctx context.Context, conn net.Conn, addr string, rootCAs *x509.CertPool) (*tls.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: host,
+		RootCAs:    rootCAs,
+		NextProtos: []string{"http/1.1"},
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// interim reports whether statusLine is the status line of an interim 1xx response
+// other than 101 Switching Protocols.
+func interim(statusLine []byte) bool {
+	fields := bytes.Fields(statusLine)
+	return len(fields) > 1 && len(fields[1]) == 3 && fields[1][0] == '1' && string(fields[1]) != "101"
+}
+
+// verbatimNames returns the raw names of the given headers in names that differ from
+// their canonical form, by canonical name.
+func verbatimNames(names map[string]string, headers []string) map[string]string {
+	var raw map[string]string
+	for _, h := range headers {
+		key := textproto.CanonicalMIMEHeaderKey(h)
+		if name, ok := names[key]; ok && name != key {
+			if raw == nil {
+				raw = make(map[string]string)
+			}
+			raw[key] = name
+		}
+	}
+	return raw
+}
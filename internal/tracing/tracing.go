@@ -0,0 +1,51 @@
+// Package tracing sets up the OpenTelemetry tracer provider of the gateway. Spans are
+// exported in batches to an OTLP/HTTP collector, or not recorded at all when none is
+// configured, so the tracing middleware can be registered unconditionally.
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// ServiceName is the service.name resource attribute of the spans of the gateway.
+const ServiceName = "api-gateway"
+
+// tracesPath is the path of the OTLP/HTTP traces endpoint below the collector base URL.
+const tracesPath = "/v1/traces"
+
+// NewProvider returns the tracer provider exporting spans to the OTLP/HTTP collector at
+// endpoint, and a function that flushes the pending spans and stops the export. The
+// collector is not contacted until the first batch is exported.
+//
+// Parameters:
+//   - endpoint: The base URL of the collector (e.g. "http://otel-collector:4318"); empty
+//     returns a no-op provider.
+//
+// Returns:
+//   - trace.TracerProvider: The tracer provider.
+//   - func(context.Context) error: The shutdown function, to be called once the server has stopped.
+//   - error: An error if the exporter could not be created.
+func NewProvider(endpoint string) (trace.TracerProvider, func(context.Context) error, error) {
+	if endpoint == "" {
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+tracesPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName))),
+	)
+	return provider, provider.Shutdown, nil
+}
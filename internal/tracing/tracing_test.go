@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TestNewProvider tests that a no-op provider is returned without an endpoint, and that
+// otherwise spans are exported to the traces path of the collector on shutdown.
+func TestNewProvider(t *testing.T) {
+	provider, shutdown, err := NewProvider("")
+	assert.NoError(t, err)
+	assert.IsType(t, noop.TracerProvider{}, provider)
+	assert.NoError(t, shutdown(context.Background()))
+
+	exported := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case exported <- r.URL.Path:
+		default:
+		}
+	}))
+	t.Cleanup(collector.Close)
+
+	provider, shutdown, err = NewProvider(collector.URL + "/")
+	assert.NoError(t, err)
+	assert.IsType(t, &sdktrace.TracerProvider{}, provider)
+
+	_, span := provider.Tracer("test").Start(context.Background(), "request")
+	span.End()
+	assert.NoError(t, shutdown(context.Background()))
+
+	select {
+	case path := <-exported:
+		assert.Equal(t, "/v1/traces", path)
+	default:
+		t.Fatal("expected the span to be exported on shutdown")
+	}
+}
@@ -0,0 +1,270 @@
+// Package transform implements declarative transformations of JSON bodies.
+// Transformations only move fields around and rewrite URL prefixes, so they are bounded and
+// safe to configure without running arbitrary code. They let upstream APIs evolve without breaking old clients.
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rules describes a transformation of a JSON body.
+// Paths are dot-separated field names, e.g. "meta.owner".
+type Rules struct {
+	// Rename moves the value at each source path to the destination path, creating
+	// intermediate objects as needed. Renaming "name" to "meta.title" nests a field,
+	// renaming "meta.title" to "name" flattens it.
+	Rename map[string]string `json:"rename"`
+
+	// Defaults sets the value at each path when the object has none, after the renames.
+	// Intermediate objects are created as needed, but existing non-object values are
+	// never replaced. Values are JSON documents, e.g. {"meta.version": 1}.
+	Defaults map[string]json.RawMessage `json:"defaults"`
+
+	// Rewrite replaces each source base URL by its destination in string values anywhere in
+	// the document, after the defaults. Only values starting with the base URL followed by
+	// "/", "?", "#" or nothing are rewritten, e.g. {"http://templates:8080": "https://api.example.com"}
+	// turns "http://templates:8080/assets/logo.png" into "https://api.example.com/assets/logo.png".
+	Rewrite map[string]string `json:"rewrite"`
+
+	// Paths limits the rules to the given request paths and the paths below them,
+	// e.g. ["/templates/list"]. Empty applies the rules to every path.
+	Paths []string `json:"paths"`
+}
+
+// Parse parses rules from their JSON representation,
+// e.g. {"rename": {"title": "name", "owner_id": "meta.owner"}, "defaults": {"locale": "en"}}.
+//
+// Parameters:
+//   - data: The JSON encoded rules.
+//
+// Returns:
+//   - *Rules: The parsed rules.
+//   - error: An error if data is not valid JSON rules.
+func Parse(data []byte) (*Rules, error) {
+	var r Rules
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	for src, dst := range r.Rename {
+		if !validPath(src) || !validPath(dst) {
+			return nil, fmt.Errorf("invalid rename %q -> %q", src, dst)
+		}
+	}
+	for path := range r.Defaults {
+		if !validPath(path) {
+			return nil, fmt.Errorf("invalid default %q", path)
+		}
+	}
+	for src := range r.Rewrite {
+		if src == "" {
+			return nil, fmt.Errorf("invalid rewrite of an empty base URL")
+		}
+	}
+	for _, path := range r.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid path %q: must start with /", path)
+		}
+	}
+	return &r, nil
+}
+
+// Apply transforms the JSON document body according to the rules.
+// A top-level object is transformed directly; the object elements of a top-level
+// array are transformed one by one. Rewrites apply to string values in any document.
+// Documents without objects are returned unchanged when there is nothing to rewrite.
+//
+// Parameters:
+//   - body: The JSON document to transform.
+//
+// Returns:
+//   - []byte: The transformed JSON document.
+//   - error: An error if body is not valid JSON.
+func (r *Rules) Apply(body []byte) ([]byte, error) {
+	// Numbers are kept as written so large integers do not lose precision.
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	switch v := doc.(type) {
+	case map[string]any:
+		r.applyObject(v)
+	case []any:
+		for _, item := range v {
+			if obj, ok := item.(map[string]any); ok {
+				r.applyObject(obj)
+			}
+		}
+	default:
+		if len(r.Rewrite) == 0 {
+			return body, nil
+		}
+	}
+
+	if len(r.Rewrite) > 0 {
+		doc = rewrite(doc, r.rewriteBases())
+	}
+	return json.Marshal(doc)
+}
+
+// Matches reports whether the rules apply to the request path.
+//
+// Parameters:
+//   - path: The request path, e.g. "/templates/list".
+//
+// Returns:
+//   - bool: True if Paths is empty or path is one of Paths or below one of them.
+func (r *Rules) Matches(path string) bool {
+	if len(r.Paths) == 0 {
+		return true
+	}
+	for _, p := range r.Paths {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteBases returns the source base URLs of the rewrites, longest first, so that a more
+// specific base URL wins over a shorter one it starts with.
+func (r *Rules) rewriteBases() [][2]string {
+	bases := make([][2]string, 0, len(r.Rewrite))
+	for src, dst := range r.Rewrite {
+		bases = append(bases, [2]string{src, dst})
+	}
+	sort.Slice(bases, func(i, j int) bool {
+		if len(bases[i][0]) != len(bases[j][0]) {
+			return len(bases[i][0]) > len(bases[j][0])
+		}
+		return bases[i][0] < bases[j][0]
+	})
+	return bases
+}
+
+// rewrite replaces the base URLs of the string values in v, recursing into objects and arrays.
+func rewrite(v any, bases [][2]string) any {
+	switch v := v.(type) {
+	case string:
+		for _, b := range bases {
+			if rest, ok := strings.CutPrefix(v, b[0]); ok &&
+				(rest == "" || strings.HasSuffix(b[0], "/") || strings.ContainsAny(rest[:1], "/?#")) {
+				return b[1] + rest
+			}
+		}
+	case map[string]any:
+		for key, item := range v {
+			v[key] = rewrite(item, bases)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = rewrite(item, bases)
+		}
+	}
+	return v
+}
+
+// applyObject applies the rules to a single object in place.
+func (r *Rules) applyObject(obj map[string]any) {
+	// Renames are applied in a stable order so the result does not depend on map iteration.
+	sources := make([]string, 0, len(r.Rename))
+	for src := range r.Rename {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+
+	// Take every source value out first so renames can swap fields.
+	values := make(map[string]any, len(sources))
+	for _, src := range sources {
+		if v, ok := take(obj, strings.Split(src, ".")); ok {
+			values[src] = v
+		}
+	}
+	for _, src := range sources {
+		if v, ok := values[src]; ok {
+			put(obj, strings.Split(r.Rename[src], "."), v)
+		}
+	}
+
+	paths := make([]string, 0, len(r.Defaults))
+	for path := range r.Defaults {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		// Each object gets its own copy of the value, so later defaults cannot alias it.
+		dec := json.NewDecoder(bytes.NewReader(r.Defaults[path]))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err == nil {
+			putDefault(obj, strings.Split(path, "."), v)
+		}
+	}
+}
+
+// validPath reports whether path is a dot-separated list of non-empty field names.
+func validPath(path string) bool {
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// take removes and returns the value at path in obj.
+func take(obj map[string]any, path []string) (any, bool) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		obj = next
+	}
+	last := path[len(path)-1]
+	v, ok := obj[last]
+	if ok {
+		delete(obj, last)
+	}
+	return v, ok
+}
+
+// putDefault stores v at path in obj unless a value is already there or a value on the
+// way is not an object.
+func putDefault(obj map[string]any, path []string, v any) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			if _, exists := obj[key]; exists {
+				return
+			}
+			next = make(map[string]any)
+			obj[key] = next
+		}
+		obj = next
+	}
+	last := path[len(path)-1]
+	if _, exists := obj[last]; !exists {
+		obj[last] = v
+	}
+}
+
+// put stores v at path in obj, replacing non-object values on the way with objects.
+func put(obj map[string]any, path []string, v any) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			obj[key] = next
+		}
+		obj = next
+	}
+	obj[path[len(path)-1]] = v
+}
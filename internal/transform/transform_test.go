@@ -0,0 +1,147 @@
+package transform
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParse tests that rules are parsed from JSON and that empty path segments are rejected.
+func TestParse(t *testing.T) {
+	r, err := Parse([]byte(`{"rename": {"title": "name"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"title": "name"}, r.Rename)
+
+	_, err = Parse([]byte(`{"rename": {"title": "meta..name"}}`))
+	assert.Error(t, err)
+
+	r, err = Parse([]byte(`{"defaults": {"meta.version": 2}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{"meta.version": json.RawMessage("2")}, r.Defaults)
+
+	_, err = Parse([]byte(`{"defaults": {".version": 2}}`))
+	assert.Error(t, err)
+
+	r, err = Parse([]byte(`{"rewrite": {"http://templates:8080": "https://api.example.com"}, "paths": ["/templates/list"]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"http://templates:8080": "https://api.example.com"}, r.Rewrite)
+	assert.Equal(t, []string{"/templates/list"}, r.Paths)
+
+	_, err = Parse([]byte(`{"rewrite": {"": "https://api.example.com"}}`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`{"paths": ["templates"]}`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+// TestRules_Apply tests renames, nesting, flattening, swaps, defaults and URL rewrites on
+// objects and arrays.
+func TestRules_Apply(t *testing.T) {
+	tests := []struct {
+		name     string                     // Name of the test case.
+		rename   map[string]string          // Rename rules.
+		defaults map[string]json.RawMessage // Default values.
+		rewrite  map[string]string          // Base URL rewrites.
+		body     string                     // Input document.
+		want     string                     // Expected output document.
+	}{
+		{
+			name:   "rename",
+			rename: map[string]string{"title": "name"},
+			body:   `{"title":"Report","id":12345678901234567890}`,
+			want:   `{"name":"Report","id":12345678901234567890}`,
+		},
+		{
+			name:   "nest and flatten",
+			rename: map[string]string{"owner_id": "meta.owner", "meta.created": "created"},
+			body:   `{"owner_id":"u1","meta":{"created":"2024-01-01"}}`,
+			want:   `{"meta":{"owner":"u1"},"created":"2024-01-01"}`,
+		},
+		{
+			name:   "swap",
+			rename: map[string]string{"a": "b", "b": "a"},
+			body:   `{"a":1,"b":2}`,
+			want:   `{"a":2,"b":1}`,
+		},
+		{
+			name:   "array of objects",
+			rename: map[string]string{"title": "name"},
+			body:   `[{"title":"a"},{"title":"b"},"other"]`,
+			want:   `[{"name":"a"},{"name":"b"},"other"]`,
+		},
+		{
+			name:   "missing source",
+			rename: map[string]string{"missing.field": "x"},
+			body:   `{"missing":"scalar"}`,
+			want:   `{"missing":"scalar"}`,
+		},
+		{
+			name:     "defaults",
+			defaults: map[string]json.RawMessage{"locale": json.RawMessage(`"en"`), "meta.version": json.RawMessage(`2`), "tags": json.RawMessage(`[]`)},
+			body:     `{"locale":"fr"}`,
+			want:     `{"locale":"fr","meta":{"version":2},"tags":[]}`,
+		},
+		{
+			name:     "defaults after renames",
+			rename:   map[string]string{"title": "name"},
+			defaults: map[string]json.RawMessage{"name": json.RawMessage(`"Untitled"`)},
+			body:     `[{"title":"Report"},{}]`,
+			want:     `[{"name":"Report"},{"name":"Untitled"}]`,
+		},
+		{
+			name:     "default below scalar",
+			defaults: map[string]json.RawMessage{"meta.version": json.RawMessage(`2`)},
+			body:     `{"meta":"legacy"}`,
+			want:     `{"meta":"legacy"}`,
+		},
+		{
+			name:    "rewrite nested strings",
+			rewrite: map[string]string{"http://templates:8080": "https://api.example.com/templates"},
+			body:    `{"logo":"http://templates:8080/assets/logo.png","pages":[{"thumb":"http://templates:8080/t/1?size=s"}],"home":"http://templates:8080","count":2}`,
+			want:    `{"logo":"https://api.example.com/templates/assets/logo.png","pages":[{"thumb":"https://api.example.com/templates/t/1?size=s"}],"home":"https://api.example.com/templates","count":2}`,
+		},
+		{
+			name:    "rewrite keeps other hosts and keys",
+			rewrite: map[string]string{"http://templates:8080": "https://api.example.com"},
+			body:    `{"http://templates:8080/key":"http://templates:80801/x","other":"see http://templates:8080/x"}`,
+			want:    `{"http://templates:8080/key":"http://templates:80801/x","other":"see http://templates:8080/x"}`,
+		},
+		{
+			name:    "rewrite prefers longest base",
+			rewrite: map[string]string{"http://internal": "https://a.example.com", "http://internal/cdn": "https://cdn.example.com"},
+			body:    `["http://internal/cdn/logo.png","http://internal/api"]`,
+			want:    `["https://cdn.example.com/logo.png","https://a.example.com/api"]`,
+		},
+		{
+			name:    "rewrite top-level string",
+			rewrite: map[string]string{"http://internal": "https://a.example.com"},
+			body:    `"http://internal/logo.png"`,
+			want:    `"https://a.example.com/logo.png"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Rules{Rename: tt.rename, Defaults: tt.defaults, Rewrite: tt.rewrite}
+			got, err := r.Apply([]byte(tt.body))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+// TestRules_Matches tests that rules apply to their paths and the paths below them only.
+func TestRules_Matches(t *testing.T) {
+	assert.True(t, (&Rules{}).Matches("/anything"))
+
+	r := &Rules{Paths: []string{"/templates/list", "/assets/"}}
+	assert.True(t, r.Matches("/templates/list"))
+	assert.True(t, r.Matches("/templates/list/42"))
+	assert.True(t, r.Matches("/assets/logo"))
+	assert.False(t, r.Matches("/templates/listing"))
+	assert.False(t, r.Matches("/templates"))
+}